@@ -0,0 +1,232 @@
+// Command git-http-backend serves Git repositories from disk over HTTP
+// with the go-git-http-backend server, e.g. as a service of a
+// docker-compose test setup.
+//
+// Usage:
+//
+//	git-http-backend [flags] [[owner/name=]path ...]
+//
+// Every path argument is served as a single repository, under owner and
+// name if given and otherwise under its parent and base directory
+// names. The -root flag serves every owner/name.git bare repository
+// below a directory.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+var (
+	ErrNoRepositories = fmt.Errorf("no repositories to serve")
+	ErrTLSConfig      = fmt.Errorf("-tls-cert and -tls-key must be set together")
+	ErrCreateConfig   = fmt.Errorf("-create-on-push requires -root and excludes -read-only")
+)
+
+type config struct {
+	listen       string
+	tlsCert      string
+	tlsKey       string
+	users        string
+	root         string
+	readOnly     bool
+	createOnPush bool
+	repos        []string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string, output io.Writer) error {
+	cfg, err := parseFlags(args, output)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(output, "", log.LstdFlags)
+
+	handler, err := newHandler(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return serve(ctx, cfg, handler, logger)
+}
+
+func parseFlags(args []string, output io.Writer) (config, error) {
+	cfg := config{}
+
+	flags := flag.NewFlagSet("git-http-backend", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: git-http-backend [flags] [[owner/name=]path ...]\n\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&cfg.listen, "listen", ":8080", "`address` to listen on")
+	flags.StringVar(&cfg.tlsCert, "tls-cert", "", "TLS certificate `file`, serves HTTPS together with -tls-key")
+	flags.StringVar(&cfg.tlsKey, "tls-key", "", "TLS private key `file`")
+	flags.StringVar(&cfg.users, "users", "", "htpasswd `file` with bcrypt hashed passwords, requires authentication")
+	flags.StringVar(&cfg.root, "root", "", "`directory` of owner/name.git bare repositories to serve")
+	flags.BoolVar(&cfg.readOnly, "read-only", false, "reject every push")
+	flags.BoolVar(&cfg.createOnPush, "create-on-push", false, "create unknown repositories below -root on first push")
+
+	if err := flags.Parse(args); err != nil {
+		return config{}, fmt.Errorf("parse flags: %w", err)
+	}
+
+	cfg.repos = flags.Args()
+
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return config{}, ErrTLSConfig
+	}
+
+	if cfg.createOnPush && (cfg.root == "" || cfg.readOnly) {
+		return config{}, ErrCreateConfig
+	}
+
+	if len(cfg.repos) == 0 && cfg.root == "" {
+		return config{}, ErrNoRepositories
+	}
+
+	return cfg, nil
+}
+
+// newHandler loads the repositories of the configuration and returns
+// the handler serving them.
+func newHandler(cfg config, logger *log.Logger) (http.Handler, error) {
+	registry := server.NewRegistry()
+
+	for _, arg := range cfg.repos {
+		if err := addRepository(registry, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.createOnPush {
+		if err := os.MkdirAll(cfg.root, 0o755); err != nil { //nolint:gomnd
+			return nil, fmt.Errorf("create root: %w", err)
+		}
+	}
+
+	if cfg.root != "" {
+		if err := addRoot(registry, cfg.root); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := []server.Option{}
+
+	var authenticator server.Authenticator
+
+	if cfg.users != "" {
+		users, err := server.LoadHtpasswd(cfg.users)
+		if err != nil {
+			return nil, fmt.Errorf("users: %w", err)
+		}
+
+		authenticator = users
+		opts = append(opts, server.WithAuthenticator(users))
+	}
+
+	if cfg.readOnly {
+		opts = append(opts, server.WithAuthorizer(readOnly()))
+	}
+
+	srv, err := server.NewWithRegistry(registry, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("server: %w", err)
+	}
+
+	for _, repoPath := range registry.Paths() {
+		logger.Printf("serving %s", repoPath)
+	}
+
+	if cfg.createOnPush {
+		return &createOnPush{
+			next:          srv,
+			registry:      registry,
+			opts:          opts,
+			authenticator: authenticator,
+			root:          cfg.root,
+			logger:        logger,
+			mu:            sync.Mutex{},
+		}, nil
+	}
+
+	return srv, nil
+}
+
+// readOnly denies write access to every repository.
+func readOnly() server.Authorizer { //nolint:ireturn
+	return server.AuthorizerFunc(func(_ context.Context, _ server.Principal, repoPath string, access server.Access) error {
+		if access >= server.AccessWrite {
+			return fmt.Errorf("%s is read only: %w", repoPath, server.ErrForbidden)
+		}
+
+		return nil
+	})
+}
+
+// serve listens on the configured address until ctx is done and then
+// shuts the HTTP server down gracefully.
+func serve(ctx context.Context, cfg config, handler http.Handler, logger *log.Logger) error {
+	httpServer := &http.Server{ //nolint:exhaustivestruct
+		Addr:              cfg.listen,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Minute,
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		logger.Printf("listening on %s", cfg.listen)
+
+		if cfg.tlsCert != "" {
+			errs <- httpServer.ListenAndServeTLS(cfg.tlsCert, cfg.tlsKey)
+
+			return
+		}
+
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	return nil
+}
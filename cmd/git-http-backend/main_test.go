@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseFlags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+		err  error
+	}{
+		{name: "repository", args: []string{"-listen", ":9000", "bob/shed=/srv/shed"}, err: nil},
+		{name: "root", args: []string{"-root", "/srv/git", "-create-on-push"}, err: nil},
+		{name: "no repositories", args: []string{"-read-only"}, err: ErrNoRepositories},
+		{name: "certificate without key", args: []string{"-tls-cert", "cert.pem", "/srv/shed"}, err: ErrTLSConfig},
+		{name: "create without root", args: []string{"-create-on-push", "/srv/shed"}, err: ErrCreateConfig},
+		{
+			name: "create read only",
+			args: []string{"-root", "/srv/git", "-create-on-push", "-read-only"},
+			err:  ErrCreateConfig,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseFlags(tc.args, io.Discard)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestServeRepositories(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	single := diskRepository(t, filepath.Join(dir, "repos", "shed"), false)
+	inRoot := diskRepository(t, filepath.Join(dir, "root", "alice", "tools.git"), true)
+
+	ts := newTestServer(t, config{
+		repos: []string{filepath.Join(dir, "repos", "shed"), "bob/renamed=" + filepath.Join(dir, "repos", "shed")},
+		root:  filepath.Join(dir, "root"),
+	})
+
+	for url, head := range map[string]plumbing.Hash{
+		ts.URL + "/repos/shed.git":  single,
+		ts.URL + "/bob/renamed.git": single,
+		ts.URL + "/alice/tools.git": inRoot,
+		ts.URL + "/bob/missing.git": plumbing.ZeroHash,
+	} {
+		repo, err := clone(url, nil)
+		if head.IsZero() {
+			require.Error(t, err, url)
+
+			continue
+		}
+
+		require.NoError(t, err, url)
+
+		ref, err := repo.Head()
+		require.NoError(t, err)
+		require.Equal(t, head, ref.Hash(), url)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "bob", "shed.git")
+	diskRepository(t, dir, true)
+
+	ts := newTestServer(t, config{repos: []string{dir}, readOnly: true})
+
+	local, err := clone(ts.URL+"/bob/shed.git", nil)
+	require.NoError(t, err)
+
+	commit(t, local, "second commit")
+	require.ErrorIs(t, pushAll(local, ts.URL+"/bob/shed.git", nil), transport.ErrAuthenticationRequired)
+}
+
+func TestCreateOnPush(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	users := filepath.Join(dir, "htpasswd")
+	require.NoError(t, os.WriteFile(users, []byte("bob:"+string(hash)+"\n"), 0o600))
+
+	root := filepath.Join(dir, "root")
+	ts := newTestServer(t, config{root: root, users: users, createOnPush: true})
+
+	local, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	head := commit(t, local, "initial commit")
+
+	url := ts.URL + "/bob/new.git"
+
+	// unauthenticated pushes do not create the repository.
+	require.ErrorIs(t, pushAll(local, url, nil), transport.ErrAuthenticationRequired)
+	require.NoDirExists(t, filepath.Join(root, "bob", "new.git"))
+
+	auth := &githttp.BasicAuth{Username: "bob", Password: "secret"}
+
+	// neither does the reference discovery preceding the push.
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+		url+"/info/refs?service=git-receive-pack", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(auth.Username, auth.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoDirExists(t, filepath.Join(root, "bob", "new.git"))
+
+	require.NoError(t, pushAll(local, url, auth))
+
+	onDisk, err := git.PlainOpen(filepath.Join(root, "bob", "new.git"))
+	require.NoError(t, err)
+
+	ref, err := onDisk.Reference(plumbing.NewBranchReferenceName("master"), true)
+	require.NoError(t, err)
+	require.Equal(t, head, ref.Hash())
+
+	cloned, err := clone(url, auth)
+	require.NoError(t, err)
+
+	ref, err = cloned.Head()
+	require.NoError(t, err)
+	require.Equal(t, head, ref.Hash())
+}
+
+func TestPushedRepository(t *testing.T) {
+	t.Parallel()
+
+	for url, want := range map[string]string{
+		"/bob/shed.git/info/refs?service=git-receive-pack": "bob/shed",
+		"/prefix/bob/shed.git/git-receive-pack":            "bob/shed",
+		"/bob/shed.git/info/refs?service=git-upload-pack":  "",
+		"/bob/shed.git/git-upload-pack":                    "",
+		"/../shed.git/git-receive-pack":                    "",
+		"/bob/.git/git-receive-pack":                       "",
+	} {
+		owner, name, ok := pushedRepository(httptest.NewRequest(http.MethodGet, url, nil))
+
+		if want == "" {
+			require.False(t, ok, url)
+
+			continue
+		}
+
+		require.True(t, ok, url)
+		require.Equal(t, want, owner+"/"+name)
+	}
+}
+
+func newTestServer(t *testing.T, cfg config) *httptest.Server {
+	t.Helper()
+
+	var output bytes.Buffer
+
+	handler, err := newHandler(cfg, log.New(&output, "", 0))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// diskRepository creates a repository with a single commit at dir and
+// returns the commit hash.
+func diskRepository(t *testing.T, dir string, bare bool) plumbing.Hash {
+	t.Helper()
+
+	local, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	head := commit(t, local, "initial commit")
+
+	repo, err := git.PlainInit(dir, bare)
+	require.NoError(t, err)
+
+	iter, err := local.Storer.IterEncodedObjects(plumbing.AnyObject)
+	require.NoError(t, err)
+
+	require.NoError(t, iter.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := repo.Storer.SetEncodedObject(obj)
+
+		return err
+	}))
+
+	master := plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), head)
+	require.NoError(t, repo.Storer.SetReference(master))
+
+	return head
+}
+
+func commit(t *testing.T, repo *git.Repository, msg string) plumbing.Hash {
+	t.Helper()
+
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	file, err := worktree.Filesystem.Create("somefile")
+	require.NoError(t, err)
+
+	_, err = file.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = worktree.Add("somefile")
+	require.NoError(t, err)
+
+	hash, err := worktree.Commit(msg, &git.CommitOptions{ //nolint:exhaustivestruct
+		Author: &object.Signature{Name: "bob the builder", Email: "bob@builder.test", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	return hash
+}
+
+func clone(url string, auth transport.AuthMethod) (*git.Repository, error) {
+	return git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{ //nolint:exhaustivestruct
+		URL:  url,
+		Auth: auth,
+	})
+}
+
+func pushAll(repo *git.Repository, url string, auth transport.AuthMethod) error {
+	remote, err := repo.CreateRemoteAnonymous(&gitconfig.RemoteConfig{ //nolint:exhaustivestruct
+		Name: "anonymous",
+		URLs: []string{url},
+	})
+	if err != nil {
+		return err
+	}
+
+	return remote.Push(&git.PushOptions{ //nolint:exhaustivestruct
+		RemoteName: "anonymous",
+		RefSpecs:   []gitconfig.RefSpec{"refs/heads/*:refs/heads/*"},
+		Auth:       auth,
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+)
+
+const receivePack = "git-receive-pack"
+
+var ErrInvalidRepository = fmt.Errorf("invalid repository argument")
+
+// addRepository registers the repository of the [owner/name=]path
+// argument. Without owner and name, the names of the parent and base
+// directory of path are used, e.g. /srv/bob/shed.git is served as
+// bob/shed.git.
+func addRepository(registry *server.Registry, arg string) error {
+	repoPath, dir := "", arg
+
+	if fields := strings.SplitN(arg, "=", 2); len(fields) == 2 { //nolint:gomnd
+		repoPath, dir = fields[0], fields[1]
+	}
+
+	owner, name, err := repositoryNames(repoPath, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", arg, err)
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+
+	if _, err := registry.Add(owner, name, repo); err != nil {
+		return fmt.Errorf("add %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+func repositoryNames(repoPath, dir string) (string, string, error) {
+	if repoPath != "" {
+		fields := strings.Split(repoPath, "/")
+		if len(fields) != 2 || fields[0] == "" || strings.TrimSuffix(fields[1], ".git") == "" { //nolint:gomnd
+			return "", "", ErrInvalidRepository
+		}
+
+		return fields[0], strings.TrimSuffix(fields[1], ".git"), nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("path: %w", err)
+	}
+
+	// the .git directory of a worktree is named after the worktree.
+	if filepath.Base(abs) == ".git" {
+		abs = filepath.Dir(abs)
+	}
+
+	owner, name := filepath.Base(filepath.Dir(abs)), strings.TrimSuffix(filepath.Base(abs), ".git")
+	if owner == string(filepath.Separator) || name == "" {
+		return "", "", ErrInvalidRepository
+	}
+
+	return owner, name, nil
+}
+
+// addRoot registers every owner/name.git repository below root.
+func addRoot(registry *server.Registry, root string) error {
+	owners, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("read root: %w", err)
+	}
+
+	for _, owner := range owners {
+		if !owner.IsDir() {
+			continue
+		}
+
+		entries, err := os.ReadDir(filepath.Join(root, owner.Name()))
+		if err != nil {
+			return fmt.Errorf("read owner: %w", err)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".git") {
+				continue
+			}
+
+			arg := owner.Name() + "/" + entry.Name() + "=" + filepath.Join(root, owner.Name(), entry.Name())
+			if err := addRepository(registry, arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createOnPush initialises a bare repository below root for pushes to
+// unknown repositories, before handing the request on to the server.
+// With an authenticator, only authenticated pushes create repositories.
+// The reference discovery preceding the push advertises an empty
+// repository, only the push itself creates it.
+type createOnPush struct {
+	next          http.Handler
+	registry      *server.Registry
+	opts          []server.Option
+	authenticator server.Authenticator
+	root          string
+	logger        *log.Logger
+
+	mu sync.Mutex
+}
+
+func (c *createOnPush) ServeHTTP(respWriter http.ResponseWriter, req *http.Request) {
+	owner, name, ok := pushedRepository(req)
+	if !ok {
+		c.next.ServeHTTP(respWriter, req)
+
+		return
+	}
+
+	if _, err := c.registry.Lookup(server.RepoPath(owner, name)); err == nil {
+		c.next.ServeHTTP(respWriter, req)
+
+		return
+	}
+
+	// Git only sends credentials once challenged, which the server does
+	// not do for unknown repositories.
+	if !c.authenticated(req) {
+		respWriter.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+		http.Error(respWriter, "authentication required", http.StatusUnauthorized)
+
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		c.discover(respWriter, req, owner, name)
+	case http.MethodPost:
+		if err := c.create(owner, name); err != nil {
+			http.Error(respWriter, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		c.next.ServeHTTP(respWriter, req)
+	default:
+		c.next.ServeHTTP(respWriter, req)
+	}
+}
+
+// discover serves the reference discovery of an unknown repository
+// from an empty in-memory repository, so that a client giving up after
+// the discovery leaves nothing behind.
+func (c *createOnPush) discover(respWriter http.ResponseWriter, req *http.Request, owner, name string) {
+	empty, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	registry := server.NewRegistry()
+	if _, err := registry.Add(owner, name, empty); err != nil {
+		http.Error(respWriter, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	srv, err := server.NewWithRegistry(registry, c.opts...)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	defer srv.Close()
+
+	srv.ServeHTTP(respWriter, req)
+}
+
+func (c *createOnPush) authenticated(req *http.Request) bool {
+	if c.authenticator == nil {
+		return true
+	}
+
+	_, err := c.authenticator.Authenticate(req)
+
+	return err == nil
+}
+
+func (c *createOnPush) create(owner, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repoPath := server.RepoPath(owner, name)
+	if _, err := c.registry.Lookup(repoPath); err == nil {
+		return nil
+	}
+
+	dir := filepath.Join(c.root, filepath.FromSlash(repoPath))
+
+	repo, err := git.PlainInit(dir, true)
+	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		repo, err = git.PlainOpen(dir)
+	}
+
+	if err != nil {
+		return fmt.Errorf("create %s: %w", repoPath, err)
+	}
+
+	if _, err := c.registry.Add(owner, name, repo); err != nil {
+		return fmt.Errorf("create %s: %w", repoPath, err)
+	}
+
+	c.logger.Printf("created %s", repoPath)
+
+	return nil
+}
+
+// pushedRepository returns the owner and name of the repository a
+// git-receive-pack request addresses, i.e. the reference discovery or
+// the push itself.
+func pushedRepository(req *http.Request) (string, string, bool) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	for i := 1; i < len(segments); i++ {
+		if !strings.HasSuffix(segments[i], ".git") {
+			continue
+		}
+
+		owner, name := segments[i-1], strings.TrimSuffix(segments[i], ".git")
+		if !validName(owner) || !validName(name) {
+			return "", "", false
+		}
+
+		switch rest := strings.Join(segments[i+1:], "/"); rest {
+		case "info/refs":
+			return owner, name, req.URL.Query().Get("service") == receivePack
+		case receivePack:
+			return owner, name, true
+		default:
+			return "", "", false
+		}
+	}
+
+	return "", "", false
+}
+
+// validName rejects names that would escape the root directory.
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `\:`)
+}
@@ -0,0 +1,420 @@
+// Package fixture builds in-memory Git repositories for tests
+// declaratively, as a sequence of branches, commits, merges and tags:
+//
+//	repo, err := fixture.New().
+//		Commit("initial commit", fixture.File("README.md", "hello")).
+//		Branch("feature").
+//		Commit("add script", fixture.Executable("run.sh", "#!/bin/sh\n")).
+//		Checkout("master").
+//		Merge("feature", "merge feature").
+//		AnnotatedTag("v1.0.0", "release 1.0.0").
+//		Build()
+//
+// Authors and timestamps are deterministic, every commit and tag is
+// one minute after the previous one, so building the same fixture
+// twice results in the same hashes. The returned repository is bare
+// and can be passed to server.New or server.NewHTTPTest.
+package fixture
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const defaultBranch = "master"
+
+var (
+	ErrBranchExists  = fmt.Errorf("branch already exists")
+	ErrBranchUnknown = fmt.Errorf("branch does not exist")
+	ErrTagExists     = fmt.Errorf("tag already exists")
+	ErrNoCommits     = fmt.Errorf("branch has no commits")
+	ErrInvalidPath   = fmt.Errorf("invalid path")
+)
+
+// DefaultTime is the time of the first commit unless WithTime is set.
+var DefaultTime = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC) //nolint:gochecknoglobals
+
+// Builder records the steps of a fixture, the first failing step is
+// returned by Build and every later step is skipped.
+type Builder struct {
+	storage       *memory.Storage
+	defaultBranch string
+	author        object.Signature
+	tick          time.Duration
+
+	current  string
+	branches map[string]plumbing.Hash
+	tags     map[string]plumbing.Hash
+	files    map[plumbing.Hash]files
+	parents  map[plumbing.Hash][]plumbing.Hash
+
+	err error
+}
+
+type Option func(*Builder)
+
+// WithDefaultBranch names the branch the fixture starts on, which HEAD
+// points to, master by default.
+func WithDefaultBranch(name string) Option {
+	return func(b *Builder) {
+		b.defaultBranch = name
+	}
+}
+
+// WithAuthor sets the author and committer of every commit and the
+// tagger of annotated tags, unless overridden with Author.
+func WithAuthor(name, email string) Option {
+	return func(b *Builder) {
+		b.author.Name = name
+		b.author.Email = email
+	}
+}
+
+// WithTime sets the time of the first commit, DefaultTime by default.
+func WithTime(when time.Time) Option {
+	return func(b *Builder) {
+		b.author.When = when
+	}
+}
+
+// New returns a Builder of an empty repository on the default branch.
+func New(opts ...Option) *Builder {
+	builder := &Builder{
+		storage:       memory.NewStorage(),
+		defaultBranch: defaultBranch,
+		author: object.Signature{
+			Name:  "fixture",
+			Email: "fixture@example.com",
+			When:  DefaultTime,
+		},
+		tick: time.Minute,
+
+		current:  "",
+		branches: map[string]plumbing.Hash{},
+		tags:     map[string]plumbing.Hash{},
+		files:    map[plumbing.Hash]files{},
+		parents:  map[plumbing.Hash][]plumbing.Hash{},
+
+		err: nil,
+	}
+
+	for _, opt := range opts {
+		opt(builder)
+	}
+
+	builder.current = builder.defaultBranch
+
+	return builder
+}
+
+// Commit commits the changes on top of the current branch.
+func (b *Builder) Commit(msg string, changes ...Change) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	parents := []plumbing.Hash{}
+	if head, ok := b.branches[b.current]; ok {
+		parents = append(parents, head)
+	}
+
+	b.err = b.commit(msg, parents, b.parentFiles(parents), changes)
+
+	return b
+}
+
+// Merge commits the merge of branch into the current branch. Files
+// changed by the merged branch since the merge base are taken from it,
+// so conflicting changes are resolved in favour of the merged branch.
+// The changes are applied on top of the merged tree.
+func (b *Builder) Merge(branch, msg string, changes ...Change) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	head, ok := b.branches[b.current]
+	if !ok {
+		b.err = fmt.Errorf("merge into %s: %w", b.current, ErrNoCommits)
+
+		return b
+	}
+
+	other, ok := b.branches[branch]
+	if !ok {
+		b.err = fmt.Errorf("merge %s: %w", branch, ErrBranchUnknown)
+
+		return b
+	}
+
+	base := files{}
+	if mergeBase, ok := b.mergeBase(head, other); ok {
+		base = b.files[mergeBase]
+	}
+
+	merged := b.files[head].clone()
+
+	for path, entry := range b.files[other] {
+		if !entry.equal(base[path]) {
+			merged[path] = entry
+		}
+	}
+
+	for path := range base {
+		if _, ok := b.files[other][path]; !ok {
+			delete(merged, path)
+		}
+	}
+
+	b.err = b.commit(msg, []plumbing.Hash{head, other}, merged, changes)
+
+	return b
+}
+
+// Branch creates a branch at the head of the current branch and checks
+// it out. A branch created before the first commit starts empty.
+func (b *Builder) Branch(name string) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	if _, ok := b.branches[name]; ok || name == b.current {
+		b.err = fmt.Errorf("branch %s: %w", name, ErrBranchExists)
+
+		return b
+	}
+
+	if head, ok := b.branches[b.current]; ok {
+		b.branches[name] = head
+	}
+
+	b.current = name
+
+	return b
+}
+
+// Checkout switches to an existing branch.
+func (b *Builder) Checkout(name string) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	if _, ok := b.branches[name]; !ok {
+		b.err = fmt.Errorf("checkout %s: %w", name, ErrBranchUnknown)
+
+		return b
+	}
+
+	b.current = name
+
+	return b
+}
+
+// Tag creates a lightweight tag of the head of the current branch.
+func (b *Builder) Tag(name string) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	head, err := b.tagTarget(name)
+	if err != nil {
+		b.err = err
+
+		return b
+	}
+
+	b.tags[name] = head
+
+	return b
+}
+
+// AnnotatedTag creates an annotated tag of the head of the current
+// branch with the message.
+func (b *Builder) AnnotatedTag(name, msg string) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	head, err := b.tagTarget(name)
+	if err != nil {
+		b.err = err
+
+		return b
+	}
+
+	tag := &object.Tag{
+		Name:       name,
+		Tagger:     b.signature(),
+		Message:    msg,
+		TargetType: plumbing.CommitObject,
+		Target:     head,
+	}
+
+	hash, err := b.store(tag)
+	if err != nil {
+		b.err = fmt.Errorf("tag %s: %w", name, err)
+
+		return b
+	}
+
+	b.tags[name] = hash
+
+	return b
+}
+
+// Build writes the references of the fixture and returns the
+// repository, HEAD points to the default branch.
+func (b *Builder) Build() (*git.Repository, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	refs := []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(b.defaultBranch)),
+	}
+
+	for name, hash := range b.branches {
+		refs = append(refs, plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash))
+	}
+
+	for name, hash := range b.tags {
+		refs = append(refs, plumbing.NewHashReference(plumbing.NewTagReferenceName(name), hash))
+	}
+
+	for _, ref := range refs {
+		if err := b.storage.SetReference(ref); err != nil {
+			return nil, fmt.Errorf("reference %s: %w", ref.Name(), err)
+		}
+	}
+
+	repo, err := git.Open(b.storage, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open fixture: %w", err)
+	}
+
+	return repo, nil
+}
+
+// Head returns the hash of the head of the branch, it is meant for
+// tests which check the hashes of a fixture.
+func (b *Builder) Head(branch string) (plumbing.Hash, bool) {
+	hash, ok := b.branches[branch]
+
+	return hash, ok
+}
+
+func (b *Builder) commit(msg string, parents []plumbing.Hash, tree files, changes []Change) error {
+	spec := &commitSpec{files: tree.clone(), author: b.signature(), err: nil}
+
+	for _, change := range changes {
+		change(spec)
+	}
+
+	if spec.err != nil {
+		return fmt.Errorf("commit %q: %w", msg, spec.err)
+	}
+
+	treeHash, err := writeTree(b.storage, spec.files)
+	if err != nil {
+		return fmt.Errorf("commit %q: %w", msg, err)
+	}
+
+	commit := &object.Commit{
+		Author:       spec.author,
+		Committer:    spec.author,
+		Message:      msg,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+
+	hash, err := b.store(commit)
+	if err != nil {
+		return fmt.Errorf("commit %q: %w", msg, err)
+	}
+
+	b.files[hash] = spec.files
+	b.parents[hash] = parents
+	b.branches[b.current] = hash
+
+	return nil
+}
+
+// mergeBase returns the first ancestor of other, in breadth first
+// order, which is an ancestor of head as well.
+func (b *Builder) mergeBase(head, other plumbing.Hash) (plumbing.Hash, bool) {
+	ancestors := map[plumbing.Hash]bool{}
+
+	for queue := []plumbing.Hash{head}; len(queue) > 0; queue = queue[1:] {
+		if !ancestors[queue[0]] {
+			ancestors[queue[0]] = true
+			queue = append(queue, b.parents[queue[0]]...)
+		}
+	}
+
+	seen := map[plumbing.Hash]bool{}
+
+	for queue := []plumbing.Hash{other}; len(queue) > 0; queue = queue[1:] {
+		if ancestors[queue[0]] {
+			return queue[0], true
+		}
+
+		if !seen[queue[0]] {
+			seen[queue[0]] = true
+			queue = append(queue, b.parents[queue[0]]...)
+		}
+	}
+
+	return plumbing.ZeroHash, false
+}
+
+func (b *Builder) parentFiles(parents []plumbing.Hash) files {
+	if len(parents) == 0 {
+		return files{}
+	}
+
+	return b.files[parents[0]]
+}
+
+func (b *Builder) tagTarget(name string) (plumbing.Hash, error) {
+	if _, ok := b.tags[name]; ok {
+		return plumbing.ZeroHash, fmt.Errorf("tag %s: %w", name, ErrTagExists)
+	}
+
+	head, ok := b.branches[b.current]
+	if !ok {
+		return plumbing.ZeroHash, fmt.Errorf("tag %s of %s: %w", name, b.current, ErrNoCommits)
+	}
+
+	return head, nil
+}
+
+// signature returns the author of the next commit or tag, each one is
+// a tick after the previous one.
+func (b *Builder) signature() object.Signature {
+	sig := b.author
+	b.author.When = b.author.When.Add(b.tick)
+
+	return sig
+}
+
+type encoder interface {
+	Encode(obj plumbing.EncodedObject) error
+}
+
+func (b *Builder) store(obj encoder) (plumbing.Hash, error) {
+	encoded := b.storage.NewEncodedObject()
+	if err := obj.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encode: %w", err)
+	}
+
+	hash, err := b.storage.SetEncodedObject(encoded)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store: %w", err)
+	}
+
+	return hash, nil
+}
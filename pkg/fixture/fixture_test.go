@@ -0,0 +1,250 @@
+package fixture_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/fixture"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+var binary = []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0x0a}
+
+func build(t *testing.T) (*git.Repository, *fixture.Builder) {
+	t.Helper()
+
+	builder := fixture.New().
+		Commit("initial commit",
+			fixture.File("README.md", "hello\n"),
+			fixture.File("docs/guide.md", "guide\n"),
+		).
+		Tag("v0.1.0").
+		Branch("feature").
+		Commit("add script and logo",
+			fixture.Executable("bin/run.sh", "#!/bin/sh\necho run\n"),
+			fixture.Binary("logo.png", binary),
+			fixture.Author("alice", "alice@example.com"),
+		).
+		Checkout("master").
+		Commit("update readme", fixture.File("README.md", "hello world\n"), fixture.Delete("docs")).
+		Merge("feature", "merge feature").
+		AnnotatedTag("v1.0.0", "release 1.0.0")
+
+	repo, err := builder.Build()
+	require.NoError(t, err)
+
+	return repo, builder
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := build(t)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	require.Equal(t, plumbing.NewBranchReferenceName("master"), head.Name())
+
+	merge, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "merge feature", merge.Message)
+	require.Len(t, merge.ParentHashes, 2)
+
+	feature, err := repo.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.NoError(t, err)
+	require.Equal(t, feature.Hash(), merge.ParentHashes[1])
+
+	featureCommit, err := repo.CommitObject(feature.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "alice", featureCommit.Author.Name)
+	require.Equal(t, "alice@example.com", featureCommit.Committer.Email)
+
+	tests := []struct {
+		name    string
+		path    string
+		mode    filemode.FileMode
+		content string
+	}{
+		{name: "updated file", path: "README.md", mode: filemode.Regular, content: "hello world\n"},
+		{name: "executable", path: "bin/run.sh", mode: filemode.Executable, content: "#!/bin/sh\necho run\n"},
+		{name: "binary", path: "logo.png", mode: filemode.Regular, content: string(binary)},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			file, err := merge.File(tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.mode, file.Mode)
+			require.Equal(t, tc.content, blobContent(t, file))
+		})
+	}
+
+	_, err = merge.File("docs/guide.md")
+	require.ErrorIs(t, err, object.ErrFileNotFound)
+}
+
+func TestBuildTags(t *testing.T) {
+	t.Parallel()
+
+	repo, builder := build(t)
+	master, _ := builder.Head("master")
+
+	lightweight, err := repo.Tag("v0.1.0")
+	require.NoError(t, err)
+
+	_, err = repo.TagObject(lightweight.Hash())
+	require.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+
+	initial, err := repo.CommitObject(lightweight.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "initial commit", initial.Message)
+
+	annotated, err := repo.Tag("v1.0.0")
+	require.NoError(t, err)
+
+	tag, err := repo.TagObject(annotated.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "release 1.0.0", tag.Message)
+	require.Equal(t, master, tag.Target)
+	require.Equal(t, "fixture", tag.Tagger.Name)
+}
+
+func TestBuildIsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	_, first := build(t)
+	_, second := build(t)
+
+	for _, branch := range []string{"master", "feature"} {
+		firstHead, ok := first.Head(branch)
+		require.True(t, ok)
+
+		secondHead, ok := second.Head(branch)
+		require.True(t, ok)
+
+		require.Equal(t, firstHead, secondHead, branch)
+	}
+
+	repo, err := fixture.New(
+		fixture.WithAuthor("bob", "bob@example.com"),
+		fixture.WithTime(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		fixture.WithDefaultBranch("main"),
+	).
+		Commit("first", fixture.File("a", "a")).
+		Commit("second", fixture.File("b", "b")).
+		Build()
+	require.NoError(t, err)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	require.Equal(t, plumbing.NewBranchReferenceName("main"), head.Name())
+
+	commit, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "bob", commit.Author.Name)
+	require.Equal(t, time.Date(2000, time.January, 1, 0, 1, 0, 0, time.UTC), commit.Author.When.UTC())
+}
+
+func TestBuildErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		builder *fixture.Builder
+		err     error
+	}{
+		{
+			name:    "checkout unknown branch",
+			builder: fixture.New().Commit("initial", fixture.File("a", "a")).Checkout("feature"),
+			err:     fixture.ErrBranchUnknown,
+		},
+		{
+			name:    "existing branch",
+			builder: fixture.New().Commit("initial", fixture.File("a", "a")).Branch("master"),
+			err:     fixture.ErrBranchExists,
+		},
+		{
+			name:    "tag without commits",
+			builder: fixture.New().Tag("v1.0.0"),
+			err:     fixture.ErrNoCommits,
+		},
+		{
+			name:    "existing tag",
+			builder: fixture.New().Commit("initial", fixture.File("a", "a")).Tag("v1").AnnotatedTag("v1", "again"),
+			err:     fixture.ErrTagExists,
+		},
+		{
+			name:    "merge unknown branch",
+			builder: fixture.New().Commit("initial", fixture.File("a", "a")).Merge("feature", "merge"),
+			err:     fixture.ErrBranchUnknown,
+		},
+		{
+			name:    "git directory",
+			builder: fixture.New().Commit("initial", fixture.File(".git/config", "")),
+			err:     fixture.ErrInvalidPath,
+		},
+		{
+			name:    "file and directory",
+			builder: fixture.New().Commit("initial", fixture.File("a", "a"), fixture.File("a/b", "b")),
+			err:     fixture.ErrInvalidPath,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tc.builder.Build()
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestServeFixture(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := build(t)
+
+	srv, err := server.NewHTTPTest(repo, "bob", "shed")
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	clone, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{URL: srv.URL()}) //nolint:exhaustivestruct
+	require.NoError(t, err)
+
+	worktree, err := clone.Worktree()
+	require.NoError(t, err)
+
+	info, err := worktree.Filesystem.Lstat("bin/run.sh")
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&0o100)
+}
+
+func blobContent(t *testing.T, file *object.File) string {
+	t.Helper()
+
+	reader, err := file.Reader()
+	require.NoError(t, err)
+
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	return string(content)
+}
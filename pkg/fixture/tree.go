@@ -0,0 +1,212 @@
+package fixture
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Change changes the tree or the metadata of a commit.
+type Change func(*commitSpec)
+
+type commitSpec struct {
+	files  files
+	author object.Signature
+	err    error
+}
+
+// File writes a regular file with the content.
+func File(name, content string) Change {
+	return blob(name, filemode.Regular, []byte(content))
+}
+
+// Executable writes an executable file with the content.
+func Executable(name, content string) Change {
+	return blob(name, filemode.Executable, []byte(content))
+}
+
+// Binary writes a regular file with binary content.
+func Binary(name string, content []byte) Change {
+	return blob(name, filemode.Regular, content)
+}
+
+// Symlink writes a symbolic link to target.
+func Symlink(name, target string) Change {
+	return blob(name, filemode.Symlink, []byte(target))
+}
+
+// Delete removes a file, or every file below a directory.
+func Delete(name string) Change {
+	return func(spec *commitSpec) {
+		name, ok := cleanPath(name)
+		if !ok {
+			spec.fail(name)
+
+			return
+		}
+
+		for file := range spec.files {
+			if file == name || strings.HasPrefix(file, name+"/") {
+				delete(spec.files, file)
+			}
+		}
+	}
+}
+
+// Author overrides the author and committer of the commit, the time is
+// kept deterministic.
+func Author(name, email string) Change {
+	return func(spec *commitSpec) {
+		spec.author.Name = name
+		spec.author.Email = email
+	}
+}
+
+func blob(name string, mode filemode.FileMode, content []byte) Change {
+	return func(spec *commitSpec) {
+		name, ok := cleanPath(name)
+		if !ok {
+			spec.fail(name)
+
+			return
+		}
+
+		spec.files[name] = entry{mode: mode, content: content}
+	}
+}
+
+func (c *commitSpec) fail(name string) {
+	if c.err == nil {
+		c.err = fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+}
+
+// cleanPath normalises a slash separated path relative to the root of
+// the tree.
+func cleanPath(name string) (string, bool) {
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned == ".git" || strings.HasPrefix(cleaned, ".git/") {
+		return name, false
+	}
+
+	return cleaned, true
+}
+
+// files is the flat content of a tree by path.
+type files map[string]entry
+
+type entry struct {
+	mode    filemode.FileMode
+	content []byte
+}
+
+func (e entry) equal(other entry) bool {
+	return e.mode == other.mode && bytes.Equal(e.content, other.content)
+}
+
+func (f files) clone() files {
+	cloned := make(files, len(f))
+	for path, entry := range f {
+		cloned[path] = entry
+	}
+
+	return cloned
+}
+
+// writeTree stores the blobs and the nested trees of the files and
+// returns the hash of the root tree.
+func writeTree(st *memory.Storage, tree files) (plumbing.Hash, error) {
+	entries := []object.TreeEntry{}
+	dirs := map[string]files{}
+
+	for name, file := range tree {
+		dir, rest, nested := strings.Cut(name, "/")
+		if nested {
+			if dirs[dir] == nil {
+				dirs[dir] = files{}
+			}
+
+			dirs[dir][rest] = file
+
+			continue
+		}
+
+		hash, err := writeBlob(st, file.content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		entries = append(entries, object.TreeEntry{Name: name, Mode: file.mode, Hash: hash})
+	}
+
+	for dir, content := range dirs {
+		if _, ok := tree[dir]; ok {
+			return plumbing.ZeroHash, fmt.Errorf("%w: %q is a file and a directory", ErrInvalidPath, dir)
+		}
+
+		hash, err := writeTree(st, content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// git sorts directories as if their name ended in a slash.
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	obj := st.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil { //nolint:exhaustivestruct
+		return plumbing.ZeroHash, fmt.Errorf("encode tree: %w", err)
+	}
+
+	hash, err := st.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store tree: %w", err)
+	}
+
+	return hash, nil
+}
+
+func writeBlob(st *memory.Storage, content []byte) (plumbing.Hash, error) {
+	obj := st.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("blob writer: %w", err)
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("write blob: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("write blob: %w", err)
+	}
+
+	hash, err := st.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("store blob: %w", err)
+	}
+
+	return hash, nil
+}
+
+func sortName(entry object.TreeEntry) string {
+	if entry.Mode == filemode.Dir {
+		return entry.Name + "/"
+	}
+
+	return entry.Name
+}
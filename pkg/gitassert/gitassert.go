@@ -0,0 +1,427 @@
+// Package gitassert checks the state of the repositories served by a
+// server.HTTPTestServer, e.g. after the tool under test pushed to it:
+//
+//	repo := gitassert.New(t, srv)
+//	repo.RefExists("main")
+//	repo.FileContent("main", "deploy/app.yaml", "replicas: 3\n")
+//	repo.CommitTrailer("main", "Signed-off-by", "bob <bob@example.com>")
+//	repo.CommitCount("v1.0.0", "main", 2)
+//
+// Every assertion reports its failure with Errorf, including a diff of
+// the expected and actual content where it applies, and returns
+// whether it succeeded. Assertions of Require stop the test on failure.
+// Any TestingT works, i.e. *testing.T as well as the testify mocks.
+package gitassert
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+)
+
+var errStop = fmt.Errorf("stop walking")
+
+// TestingT is the part of *testing.T the assertions use, it is the
+// same as testify's assert.TestingT.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type tHelper interface {
+	Helper()
+}
+
+type failNower interface {
+	FailNow()
+}
+
+// Repo asserts the state of a repository served by the test server.
+// The repository is looked up by every assertion, so it may be created
+// after the Repo, e.g. on push.
+type Repo struct {
+	t        TestingT
+	srv      *server.HTTPTestServer
+	repoPath string
+	failNow  bool
+}
+
+// New returns the assertions of the repository served by a test server
+// created with server.NewHTTPTest.
+func New(t TestingT, srv *server.HTTPTestServer) *Repo {
+	return &Repo{t: t, srv: srv, repoPath: srv.Server.RepoPath(), failNow: false}
+}
+
+// ForRepo returns the assertions of the repository of owner and
+// repoName, it is meant for servers hosting many repositories.
+func ForRepo(t TestingT, srv *server.HTTPTestServer, owner, repoName string) *Repo {
+	return &Repo{t: t, srv: srv, repoPath: server.RepoPath(owner, repoName), failNow: false}
+}
+
+// Require returns the same assertions which stop the test on failure,
+// if the TestingT supports it like *testing.T or testify's
+// require.TestingT.
+func (r *Repo) Require() *Repo {
+	return &Repo{t: r.t, srv: r.srv, repoPath: r.repoPath, failNow: true}
+}
+
+// RefExists asserts the reference exists. Short branch and tag names
+// are accepted, e.g. main for refs/heads/main.
+func (r *Repo) RefExists(ref string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return false
+	}
+
+	if _, err := resolveRef(repo, ref); err != nil {
+		return r.fail("ref %s does not exist: %s\nrefs: %s", ref, err, refNames(repo))
+	}
+
+	return true
+}
+
+// NoRef asserts the reference does not exist.
+func (r *Repo) NoRef(ref string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return false
+	}
+
+	if found, err := resolveRef(repo, ref); err == nil {
+		return r.fail("ref %s exists: %s", found.Name(), found.Hash())
+	}
+
+	return true
+}
+
+// RefTarget asserts the reference points to the revision, e.g. a
+// commit hash, a branch or a tag. An annotated tag matches both its own
+// hash and the hash of the commit it tags.
+func (r *Repo) RefTarget(ref, rev string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return false
+	}
+
+	found, err := resolveRef(repo, ref)
+	if err != nil {
+		return r.fail("ref %s does not exist: %s\nrefs: %s", ref, err, refNames(repo))
+	}
+
+	want, err := resolveRevision(repo, rev)
+	if err != nil {
+		return r.fail("revision %s: %s", rev, err)
+	}
+
+	if found.Hash() == want {
+		return true
+	}
+
+	if tag, err := repo.TagObject(found.Hash()); err == nil && tag.Target == want {
+		return true
+	}
+
+	return r.fail("ref %s points to %s, expected %s (%s)", found.Name(), found.Hash(), want, rev)
+}
+
+// FileContent asserts the file at the path has the content at the
+// revision.
+func (r *Repo) FileContent(rev, path, content string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	commit, ok := r.commit(rev)
+	if !ok {
+		return false
+	}
+
+	file, err := commit.File(path)
+	if err != nil {
+		return r.fail("file %s at %s: %s", path, rev, err)
+	}
+
+	actual, err := file.Contents()
+	if err != nil {
+		return r.fail("file %s at %s: %s", path, rev, err)
+	}
+
+	if actual != content {
+		return r.fail("file %s at %s differs:\n%s", path, rev, diff(content, actual))
+	}
+
+	return true
+}
+
+// NoFile asserts there is neither a file nor a directory at the path
+// at the revision.
+func (r *Repo) NoFile(rev, path string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	commit, ok := r.commit(rev)
+	if !ok {
+		return false
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return r.fail("tree of %s: %s", rev, err)
+	}
+
+	entry, err := tree.FindEntry(strings.Trim(path, "/"))
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return true
+	}
+
+	if err != nil {
+		return r.fail("file %s at %s: %s", path, rev, err)
+	}
+
+	return r.fail("%s at %s exists: %s %s", path, rev, entry.Mode, entry.Hash)
+}
+
+// CommitMessage asserts the message of the commit at the revision,
+// leading and trailing white space is ignored.
+func (r *Repo) CommitMessage(rev, msg string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	commit, ok := r.commit(rev)
+	if !ok {
+		return false
+	}
+
+	expected, actual := strings.TrimSpace(msg), strings.TrimSpace(commit.Message)
+	if actual != expected {
+		return r.fail("message of commit %s (%s) differs:\n%s", commit.Hash, rev, diff(expected, actual))
+	}
+
+	return true
+}
+
+// CommitAuthor asserts the name and email of the author of the commit
+// at the revision.
+func (r *Repo) CommitAuthor(rev, name, email string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	commit, ok := r.commit(rev)
+	if !ok {
+		return false
+	}
+
+	if commit.Author.Name != name || commit.Author.Email != email {
+		return r.fail("author of commit %s (%s) is %s <%s>, expected %s <%s>",
+			commit.Hash, rev, commit.Author.Name, commit.Author.Email, name, email)
+	}
+
+	return true
+}
+
+// CommitTrailer asserts the commit at the revision has the trailer,
+// e.g. Signed-off-by, with the value. Keys are case-insensitive.
+func (r *Repo) CommitTrailer(rev, key, value string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	commit, ok := r.commit(rev)
+	if !ok {
+		return false
+	}
+
+	found := trailers(commit.Message)
+	for _, trailer := range found {
+		if strings.EqualFold(trailer[0], key) && trailer[1] == value {
+			return true
+		}
+	}
+
+	lines := make([]string, 0, len(found))
+	for _, trailer := range found {
+		lines = append(lines, trailer[0]+": "+trailer[1])
+	}
+
+	return r.fail("commit %s (%s) has no trailer %s: %s\ntrailers:\n%s",
+		commit.Hash, rev, key, value, strings.Join(lines, "\n"))
+}
+
+// CommitCount asserts the number of commits reachable from the
+// revision to and not from the revision from, as git rev-list
+// from..to counts them. Every commit reachable from to is counted if
+// from is empty.
+func (r *Repo) CommitCount(from, to string, count int) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return false
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+
+	if from != "" {
+		start, err := resolveRevision(repo, from)
+		if err != nil {
+			return r.fail("revision %s: %s", from, err)
+		}
+
+		if err := walk(repo, start, func(commit *object.Commit) error {
+			excluded[commit.Hash] = true
+
+			return nil
+		}); err != nil {
+			return r.fail("history of %s: %s", from, err)
+		}
+	}
+
+	end, err := resolveRevision(repo, to)
+	if err != nil {
+		return r.fail("revision %s: %s", to, err)
+	}
+
+	commits := []string{}
+
+	err = walk(repo, end, func(commit *object.Commit) error {
+		if !excluded[commit.Hash] {
+			commits = append(commits, fmt.Sprintf("%s %s", commit.Hash.String()[:7], subject(commit)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return r.fail("history of %s: %s", to, err)
+	}
+
+	if len(commits) != count {
+		return r.fail("%d commits in %s..%s, expected %d:\n%s", len(commits), from, to, count, strings.Join(commits, "\n"))
+	}
+
+	return true
+}
+
+// LinearHistory asserts there is no merge commit in the history of the
+// revision.
+func (r *Repo) LinearHistory(rev string) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return false
+	}
+
+	start, err := resolveRevision(repo, rev)
+	if err != nil {
+		return r.fail("revision %s: %s", rev, err)
+	}
+
+	var merge *object.Commit
+
+	err = walk(repo, start, func(commit *object.Commit) error {
+		if commit.NumParents() > 1 {
+			merge = commit
+
+			return errStop
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return r.fail("history of %s: %s", rev, err)
+	}
+
+	if merge != nil {
+		return r.fail("history of %s is not linear, %s is a merge: %s", rev, merge.Hash, subject(merge))
+	}
+
+	return true
+}
+
+func (r *Repo) repository() (*git.Repository, bool) {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, err := r.srv.Server.Registry().Lookup(r.repoPath)
+	if err != nil {
+		return nil, r.fail("%s", err)
+	}
+
+	return repo, true
+}
+
+func (r *Repo) commit(rev string) (*object.Commit, bool) {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	repo, ok := r.repository()
+	if !ok {
+		return nil, false
+	}
+
+	hash, err := resolveRevision(repo, rev)
+	if err != nil {
+		return nil, r.fail("revision %s: %s", rev, err)
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, r.fail("commit %s (%s): %s", hash, rev, err)
+	}
+
+	return commit, true
+}
+
+// fail reports the failure in the repository and always returns false.
+func (r *Repo) fail(format string, args ...interface{}) bool {
+	if h, ok := r.t.(tHelper); ok {
+		h.Helper()
+	}
+
+	r.t.Errorf("%s: "+format, append([]interface{}{r.repoPath}, args...)...)
+
+	if t, ok := r.t.(failNower); ok && r.failNow {
+		t.FailNow()
+	}
+
+	return false
+}
+
+// diff returns the unified diff of the expected and actual content.
+func diff(expected, actual string) string {
+	unified, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{ //nolint:exhaustivestruct
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "Expected",
+		ToFile:   "Actual",
+		Context:  1,
+	})
+
+	return unified
+}
@@ -0,0 +1,256 @@
+package gitassert_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/fixture"
+	"github.com/sata-form3/go-git-http-backend/pkg/gitassert"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	owner    = "bob"
+	repoName = "shed"
+
+	message = "update readme\n\nSigned-off-by: alice <alice@example.com>"
+)
+
+// recorder is a TestingT recording the failures.
+type recorder struct {
+	errors []string
+	failed bool
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) FailNow() {
+	r.failed = true
+}
+
+// newServer serves a fixture to which a linear commit and a merge were
+// pushed.
+func newServer(t *testing.T) *server.HTTPTestServer {
+	t.Helper()
+
+	repo, err := fixture.New().
+		Commit("initial commit", fixture.File("README.md", "hello\n"), fixture.File("docs/guide.md", "guide\n")).
+		AnnotatedTag("v1.0.0", "release 1.0.0").
+		Branch("feature").
+		Commit("add feature", fixture.File("feature.txt", "feature\n")).
+		Checkout("master").
+		Merge("feature", "merge feature").
+		Build()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(repo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{URL: srv.URL()}) //nolint:exhaustivestruct
+	require.NoError(t, err)
+
+	worktree, err := local.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, worktree.Filesystem.Remove("docs/guide.md"))
+
+	file, err := worktree.Filesystem.Create("README.md")
+	require.NoError(t, err)
+
+	_, err = file.Write([]byte("hello\nworld\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = worktree.Commit(message+"\n", &git.CommitOptions{ //nolint:exhaustivestruct
+		All:    true,
+		Author: &object.Signature{Name: "alice", Email: "alice@example.com"}, //nolint:exhaustivestruct
+	})
+	require.NoError(t, err)
+
+	err = local.Push(&git.PushOptions{ //nolint:exhaustivestruct
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/release"},
+	})
+	require.NoError(t, err)
+
+	return srv
+}
+
+func TestAssertions(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+	repo := gitassert.New(t, srv)
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "branch exists", ok: repo.RefExists("release")},
+		{name: "full ref exists", ok: repo.RefExists("refs/heads/feature")},
+		{name: "tag exists", ok: repo.RefExists("v1.0.0")},
+		{name: "no ref", ok: repo.NoRef("refs/heads/unknown")},
+		{name: "ref target", ok: repo.RefTarget("release", "master")},
+		{name: "annotated tag target", ok: repo.RefTarget("v1.0.0", "master~2")},
+		{name: "file content", ok: repo.FileContent("master", "README.md", "hello\nworld\n")},
+		{name: "file content of tag", ok: repo.FileContent("v1.0.0", "docs/guide.md", "guide\n")},
+		{name: "no file", ok: repo.NoFile("master", "docs/guide.md")},
+		{name: "no directory", ok: repo.NoFile("master", "docs")},
+		{name: "commit message", ok: repo.CommitMessage("master", message)},
+		{name: "commit author", ok: repo.CommitAuthor("master", "alice", "alice@example.com")},
+		{name: "commit trailer", ok: repo.CommitTrailer("master", "signed-off-by", "alice <alice@example.com>")},
+		{name: "commit count", ok: repo.CommitCount("v1.0.0", "master", 3)},
+		{name: "commit count of history", ok: repo.CommitCount("", "feature", 2)},
+		{name: "linear history", ok: repo.LinearHistory("feature")},
+		{name: "other repository", ok: gitassert.ForRepo(t, srv, owner, repoName).RefExists("master")},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.True(t, tc.ok)
+		})
+	}
+}
+
+func TestAssertionFailures(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+
+	tests := []struct {
+		name   string
+		assert func(repo *gitassert.Repo) bool
+		errors []string
+	}{
+		{
+			name:   "missing ref",
+			assert: func(repo *gitassert.Repo) bool { return repo.RefExists("unknown") },
+			errors: []string{"bob/shed.git: ref unknown does not exist", "refs: HEAD, refs/heads/feature, refs/heads/master"},
+		},
+		{
+			name:   "existing ref",
+			assert: func(repo *gitassert.Repo) bool { return repo.NoRef("feature") },
+			errors: []string{"ref refs/heads/feature exists"},
+		},
+		{
+			name:   "ref target",
+			assert: func(repo *gitassert.Repo) bool { return repo.RefTarget("feature", "master") },
+			errors: []string{"ref refs/heads/feature points to"},
+		},
+		{
+			name:   "file content",
+			assert: func(repo *gitassert.Repo) bool { return repo.FileContent("master", "README.md", "hello\nthere\n") },
+			errors: []string{"file README.md at master differs", "--- Expected\n+++ Actual\n", " hello\n-there\n+world\n"},
+		},
+		{
+			name:   "missing file",
+			assert: func(repo *gitassert.Repo) bool { return repo.FileContent("master", "docs/guide.md", "guide\n") },
+			errors: []string{"file docs/guide.md at master: file not found"},
+		},
+		{
+			name:   "existing file",
+			assert: func(repo *gitassert.Repo) bool { return repo.NoFile("master", "feature.txt") },
+			errors: []string{"feature.txt at master exists"},
+		},
+		{
+			name:   "commit message",
+			assert: func(repo *gitassert.Repo) bool { return repo.CommitMessage("feature", "add features") },
+			errors: []string{"-add features\n+add feature\n"},
+		},
+		{
+			name:   "commit author",
+			assert: func(repo *gitassert.Repo) bool { return repo.CommitAuthor("master", "bob", "bob@example.com") },
+			errors: []string{"is alice <alice@example.com>, expected bob <bob@example.com>"},
+		},
+		{
+			name: "commit trailer",
+			assert: func(repo *gitassert.Repo) bool {
+				return repo.CommitTrailer("master", "Signed-off-by", "bob <bob@example.com>")
+			},
+			errors: []string{
+				"has no trailer Signed-off-by: bob <bob@example.com>",
+				"trailers:\nSigned-off-by: alice <alice@example.com>",
+			},
+		},
+		{
+			name:   "commit count",
+			assert: func(repo *gitassert.Repo) bool { return repo.CommitCount("v1.0.0", "master", 1) },
+			errors: []string{"3 commits in v1.0.0..master, expected 1", "update readme", "merge feature", "add feature"},
+		},
+		{
+			name:   "linear history",
+			assert: func(repo *gitassert.Repo) bool { return repo.LinearHistory("master") },
+			errors: []string{"history of master is not linear", "is a merge: merge feature"},
+		},
+		{
+			name:   "unknown revision",
+			assert: func(repo *gitassert.Repo) bool { return repo.CommitMessage("unknown", "") },
+			errors: []string{"revision unknown"},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &recorder{errors: []string{}, failed: false}
+
+			require.False(t, tc.assert(gitassert.New(rec, srv)))
+
+			for _, msg := range tc.errors {
+				require.Len(t, rec.errors, 1)
+				require.Contains(t, rec.errors[0], msg)
+			}
+
+			require.False(t, rec.failed)
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+
+	rec := &recorder{errors: []string{}, failed: false}
+	require.True(t, gitassert.New(rec, srv).Require().RefExists("master"))
+	require.False(t, rec.failed)
+
+	require.False(t, gitassert.New(rec, srv).Require().RefExists("unknown"))
+	require.True(t, rec.failed)
+	require.Len(t, rec.errors, 1)
+
+	unknown := &recorder{errors: []string{}, failed: false}
+	require.False(t, gitassert.ForRepo(unknown, srv, owner, "unknown").RefExists("master"))
+	require.Len(t, unknown.errors, 1)
+	require.Contains(t, unknown.errors[0], server.ErrRepoNotFound.Error())
+}
+
+func TestTestify(t *testing.T) {
+	t.Parallel()
+
+	srv := newServer(t)
+
+	// any testify TestingT works, e.g. the one of a suite.
+	var testingT assert.TestingT = t
+
+	repo := gitassert.New(testingT, srv)
+	assert.True(t, repo.RefExists("master"))
+	assert.True(t, repo.CommitCount("master~1", "master", 1))
+}
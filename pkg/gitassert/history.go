@@ -0,0 +1,120 @@
+package gitassert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// resolveRef returns the reference of the name, short names are tried
+// as branch and then as tag. Symbolic references are resolved.
+func resolveRef(repo *git.Repository, name string) (*plumbing.Reference, error) {
+	candidates := []plumbing.ReferenceName{
+		plumbing.ReferenceName(name),
+		plumbing.NewBranchReferenceName(name),
+		plumbing.NewTagReferenceName(name),
+	}
+
+	for _, candidate := range candidates {
+		ref, err := repo.Reference(candidate, true)
+		if err == nil {
+			return ref, nil
+		}
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+// resolveRevision returns the commit of the revision, e.g. a hash, a
+// branch, a tag or HEAD~2.
+func resolveRevision(repo *git.Repository, rev string) (plumbing.Hash, error) {
+	if plumbing.IsHash(rev) {
+		return plumbing.NewHash(rev), nil
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("resolve: %w", err)
+	}
+
+	return *hash, nil
+}
+
+// refNames returns the sorted names of the references of the
+// repository, to tell which ones exist when one is missing.
+func refNames(repo *git.Repository) string {
+	refs, err := repo.References()
+	if err != nil {
+		return err.Error()
+	}
+
+	names := []string{}
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().String())
+
+		return nil
+	})
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// walk visits every commit reachable from start once, in breadth first
+// order.
+func walk(repo *git.Repository, start plumbing.Hash, visit func(*object.Commit) error) error {
+	seen := map[plumbing.Hash]bool{}
+
+	for queue := []plumbing.Hash{start}; len(queue) > 0; queue = queue[1:] {
+		if seen[queue[0]] {
+			continue
+		}
+
+		seen[queue[0]] = true
+
+		commit, err := repo.CommitObject(queue[0])
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", queue[0], err)
+		}
+
+		if err := visit(commit); err != nil {
+			return err
+		}
+
+		queue = append(queue, commit.ParentHashes...)
+	}
+
+	return nil
+}
+
+// trailers returns the key and value pairs of the last paragraph of
+// the commit message, as git interpret-trailers parses them. A message
+// made of the subject alone has no trailers.
+func trailers(message string) [][2]string {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+
+	found := [][2]string{}
+
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) != "" && strings.TrimSpace(value) != "" {
+			found = append(found, [2]string{strings.TrimSpace(key), strings.TrimSpace(value)})
+		}
+	}
+
+	return found
+}
+
+func subject(commit *object.Commit) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+
+	return line
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMissingCredentials = fmt.Errorf("missing credentials")
+	ErrUnsupportedHash    = fmt.Errorf("unsupported password hash")
+)
+
+// Principal is the identity a request was authenticated as. The zero
+// value is the anonymous principal used when no Authenticator is
+// configured.
+type Principal struct {
+	Name string
+}
+
+// IsAnonymous reports whether the principal is the anonymous one.
+func (p Principal) IsAnonymous() bool {
+	return p.Name == ""
+}
+
+// Authenticator resolves the principal of a request. An error is
+// responded to with 401 Unauthorized.
+type Authenticator interface {
+	Authenticate(req *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(req *http.Request) (Principal, error)
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *http.Request) (Principal, error) {
+	return f(req)
+}
+
+// StaticUsers authenticates basic auth credentials against a map of
+// usernames to plain text passwords.
+type StaticUsers map[string]string
+
+// Authenticate implements Authenticator.
+func (u StaticUsers) Authenticate(req *http.Request) (Principal, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return Principal{}, ErrMissingCredentials
+	}
+
+	expected, ok := u[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return Principal{}, ErrInvalidAuth
+	}
+
+	return Principal{Name: username}, nil
+}
+
+// BearerTokens authenticates tokens against a map of tokens to
+// principal names. The token is read from the Authorization bearer
+// header, or from the basic auth password as sent by git clients
+// configured with a token.
+type BearerTokens map[string]string
+
+// Authenticate implements Authenticator.
+func (b BearerTokens) Authenticate(req *http.Request) (Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return Principal{}, ErrMissingCredentials
+	}
+
+	for known, name := range b {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return Principal{Name: name}, nil
+		}
+	}
+
+	return Principal{}, ErrInvalidAuth
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, prefix) {
+		return strings.TrimPrefix(header, prefix), true
+	}
+
+	if _, password, ok := req.BasicAuth(); ok {
+		return password, true
+	}
+
+	return "", false
+}
+
+// Htpasswd authenticates basic auth credentials against bcrypt hashed
+// passwords in the htpasswd format, as created by htpasswd -B.
+type Htpasswd struct {
+	users map[string][]byte
+}
+
+// NewHtpasswd parses htpasswd entries from r, only bcrypt hashes are
+// supported.
+func NewHtpasswd(r io.Reader) (*Htpasswd, error) {
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 2) //nolint:gomnd
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "$2") {
+			return nil, fmt.Errorf("user %q: %w", fields[0], ErrUnsupportedHash)
+		}
+
+		users[fields[0]] = []byte(fields[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read htpasswd: %w", err)
+	}
+
+	return &Htpasswd{users: users}, nil
+}
+
+// LoadHtpasswd parses the htpasswd file at path.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open htpasswd: %w", err)
+	}
+	defer file.Close()
+
+	return NewHtpasswd(file)
+}
+
+// Authenticate implements Authenticator.
+func (h *Htpasswd) Authenticate(req *http.Request) (Principal, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return Principal{}, ErrMissingCredentials
+	}
+
+	hash, ok := h.users[username]
+	if !ok || bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return Principal{}, ErrInvalidAuth
+	}
+
+	return Principal{Name: username}, nil
+}
+
+// PerRepository authenticates requests with the Authenticator of the
+// addressed repository path, e.g. owner/name.git. Paths are matched
+// like Registry.Lookup does, ignoring case and a leading slash.
+// Requests for repositories without an Authenticator are anonymous.
+type PerRepository map[string]Authenticator
+
+// Authenticate implements Authenticator.
+func (p PerRepository) Authenticate(req *http.Request) (Principal, error) {
+	repoPath, _, _ := splitRepoPath(req.URL.Path)
+
+	for key, auth := range p {
+		if normaliseRepoPath(key) == repoPath {
+			return auth.Authenticate(req)
+		}
+	}
+
+	return Principal{}, nil
+}
+
+// WithAuthenticator sets the Authenticator every request is
+// authenticated with, it replaces WithBasicAuth.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = auth
+	}
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal the request of the
+// context was authenticated as.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+
+	return principal, ok
+}
+
+func contextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// authenticate resolves the principal of the request and adds it to
+// the request context. It responds with 401 Unauthorized on failure.
+func (s *Server) authenticate(respWriter http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	principal := Principal{}
+
+	if s.authenticator != nil {
+		var err error
+
+		principal, err = s.authenticator.Authenticate(req)
+		if err != nil {
+			observe(req.Context()).failedAuth(fmt.Errorf("authenticate: %w", err))
+			respWriter.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(respWriter, "invalid auth", http.StatusUnauthorized)
+
+			return nil, false
+		}
+	}
+
+	observe(req.Context()).authenticated(principal)
+
+	return req.WithContext(contextWithPrincipal(req.Context(), principal)), true
+}
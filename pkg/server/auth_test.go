@@ -0,0 +1,159 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
+func TestAuthenticators(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("IDKFA"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	htpasswd, err := server.NewHtpasswd(strings.NewReader(fmt.Sprintf("# users\ngodmode:%s\n", hash)))
+	require.NoError(t, err)
+
+	withBasic := func(username, password string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/bob/shed.git/info/refs", nil)
+		req.SetBasicAuth(username, password)
+
+		return req
+	}
+
+	withBearer := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/bob/shed.git/info/refs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		return req
+	}
+
+	tests := map[string]struct {
+		auth      server.Authenticator
+		req       *http.Request
+		principal string
+		err       error
+	}{
+		"StaticUsers": {
+			server.StaticUsers{"godmode": "IDKFA"}, withBasic("godmode", "IDKFA"), "godmode", nil,
+		},
+		"StaticUsersInvalid": {
+			server.StaticUsers{"godmode": "IDKFA"}, withBasic("godmode", "IDDQD"), "", server.ErrInvalidAuth,
+		},
+		"StaticUsersMissing": {
+			server.StaticUsers{"godmode": "IDKFA"}, withBearer("IDKFA"), "", server.ErrMissingCredentials,
+		},
+		"BearerTokens": {
+			server.BearerTokens{"s3cr3t": "ci-bot"}, withBearer("s3cr3t"), "ci-bot", nil,
+		},
+		"BearerTokensAsPassword": {
+			server.BearerTokens{"s3cr3t": "ci-bot"}, withBasic("x-access-token", "s3cr3t"), "ci-bot", nil,
+		},
+		"BearerTokensInvalid": {
+			server.BearerTokens{"s3cr3t": "ci-bot"}, withBearer("guess"), "", server.ErrInvalidAuth,
+		},
+		"Htpasswd": {
+			htpasswd, withBasic("godmode", "IDKFA"), "godmode", nil,
+		},
+		"HtpasswdInvalid": {
+			htpasswd, withBasic("godmode", "IDDQD"), "", server.ErrInvalidAuth,
+		},
+		"PerRepository": {
+			server.PerRepository{"bob/shed.git": server.StaticUsers{"bob": "pw"}}, withBasic("bob", "pw"), "bob", nil,
+		},
+		"PerRepositoryMixedCase": {
+			server.PerRepository{"/Bob/Shed.git": server.StaticUsers{"bob": "pw"}}, withBasic("bob", "guess"), "",
+			server.ErrInvalidAuth,
+		},
+		"PerRepositoryOther": {
+			server.PerRepository{"alice/shed.git": server.StaticUsers{"alice": "pw"}}, withBasic("bob", "pw"), "", nil,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			principal, err := test.auth.Authenticate(test.req)
+			require.ErrorIs(t, err, test.err)
+			require.Equal(t, test.principal, principal.Name)
+		})
+	}
+}
+
+func TestNewHtpasswdRejectsUnsupportedHashes(t *testing.T) {
+	t.Parallel()
+
+	_, err := server.NewHtpasswd(strings.NewReader("bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	require.ErrorIs(t, err, server.ErrUnsupportedHash)
+}
+
+func TestCloneHTTPWithBearerToken(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithAuthenticator(server.BearerTokens{"s3cr3t": "ci-bot"}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	clone := func(token string) error {
+		_, err := git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &git.CloneOptions{
+			URL:  srv.URL(),
+			Auth: &githttp.TokenAuth{Token: token},
+		})
+
+		return err
+	}
+
+	require.NoError(t, clone("s3cr3t"))
+	require.ErrorIs(t, clone("guess"), transport.ErrAuthenticationRequired)
+}
+
+func TestCloneWithPerRepositoryCredentials(t *testing.T) {
+	t.Parallel()
+
+	reg := server.NewRegistry()
+
+	_, err := reg.Add("bob", "shed", repoWithInitCommit(t, filename, "bob's"))
+	require.NoError(t, err)
+
+	_, err = reg.Add("alice", "shed", repoWithInitCommit(t, filename, "alice's"))
+	require.NoError(t, err)
+
+	bob := server.BasicAuth{Username: "bob", Password: "bob-pw"}
+	alice := server.BasicAuth{Username: "alice", Password: "alice-pw"}
+
+	srv, err := server.NewHTTPTestWithRegistry(reg, server.WithAuthenticator(server.PerRepository{
+		"bob/shed.git":   server.StaticUsers{bob.Username: bob.Password},
+		"alice/shed.git": server.StaticUsers{alice.Username: alice.Password},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	newCloneAssert(t, srv.RepoURL("bob", "shed"), withAuth(bob)).assert(filename, "bob's")
+	newCloneAssert(t, srv.RepoURL("alice", "shed"), withAuth(alice)).assert(filename, "alice's")
+
+	_, err = git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &git.CloneOptions{
+		URL:  srv.RepoURL("alice", "shed"),
+		Auth: &githttp.BasicAuth{Username: bob.Username, Password: bob.Password},
+	})
+	require.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+var ErrForbidden = fmt.Errorf("forbidden")
+
+// Access is the level of access an operation requires on a repository,
+// write access implies read access.
+type Access int
+
+const (
+	// AccessNone grants no access at all.
+	AccessNone Access = iota
+	// AccessRead is required to clone and fetch.
+	AccessRead
+	// AccessWrite is required to push.
+	AccessWrite
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessNone:
+		return "none"
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+}
+
+// Authorizer decides whether the principal is granted access to the
+// repository at repoPath, e.g. owner/name.git. Returning an error
+// denies the request, with 401 Unauthorized for the anonymous
+// principal and 403 Forbidden otherwise.
+type Authorizer interface {
+	Authorize(ctx context.Context, principal Principal, repoPath string, access Access) error
+}
+
+// AuthorizerFunc adapts a function to an Authorizer.
+type AuthorizerFunc func(ctx context.Context, principal Principal, repoPath string, access Access) error
+
+// Authorize calls f(ctx, principal, repoPath, access).
+func (f AuthorizerFunc) Authorize(ctx context.Context, principal Principal, repoPath string, access Access) error {
+	return f(ctx, principal, repoPath, access)
+}
+
+// AnyRepository is the repository path matching every repository in
+// Permissions.
+const AnyRepository = "*"
+
+// Permissions grants access by principal name and repository path,
+// AnyRepository applies to repositories without an explicit grant. The
+// anonymous principal is granted access under the empty name.
+// Repository paths are matched like Registry.Lookup does, ignoring case
+// and a leading slash.
+type Permissions map[string]map[string]Access
+
+// Authorize implements Authorizer.
+func (p Permissions) Authorize(_ context.Context, principal Principal, repoPath string, access Access) error {
+	grants := p[principal.Name]
+	granted := grants[AnyRepository]
+
+	for key, grant := range grants {
+		if normaliseRepoPath(key) == normaliseRepoPath(repoPath) {
+			granted = grant
+
+			break
+		}
+	}
+
+	if granted < access {
+		return fmt.Errorf("%s access to %s: %w", access, repoPath, ErrForbidden)
+	}
+
+	return nil
+}
+
+// WithAuthorizer sets the Authorizer deciding access of authenticated
+// requests, by default every principal has write access.
+func WithAuthorizer(authz Authorizer) Option {
+	return func(s *Server) {
+		s.authorizer = authz
+	}
+}
+
+// authorize checks the principal of the authenticated request has the
+// access to the repository, otherwise it responds with 401 or 403.
+func (s *Server) authorize(respWriter http.ResponseWriter, req *http.Request, repoPath string, access Access) bool {
+	if s.authorizer == nil {
+		return true
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+
+	err := s.authorizer.Authorize(req.Context(), principal, repoPath, access)
+	if err == nil {
+		return true
+	}
+
+	observe(req.Context()).failedAuth(fmt.Errorf("authorize %s access to %s: %w", access, repoPath, err))
+
+	if principal.IsAnonymous() {
+		respWriter.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+		http.Error(respWriter, "authentication required", http.StatusUnauthorized)
+
+		return false
+	}
+
+	http.Error(respWriter, err.Error(), http.StatusForbidden)
+
+	return false
+}
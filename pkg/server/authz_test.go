@@ -0,0 +1,93 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
+func TestPermissions(t *testing.T) {
+	t.Parallel()
+
+	perms := server.Permissions{
+		"ci-bot": {server.AnyRepository: server.AccessRead},
+		"deploy": {
+			server.AnyRepository: server.AccessRead, "bob/shed.git": server.AccessWrite, "/Bob/Tools.git": server.AccessWrite,
+		},
+		"": {"bob/public.git": server.AccessRead},
+	}
+
+	tests := map[string]struct {
+		principal string
+		repoPath  string
+		access    server.Access
+		err       error
+	}{
+		"ReadAnyRepository":   {"ci-bot", "bob/shed.git", server.AccessRead, nil},
+		"WriteReadOnly":       {"ci-bot", "bob/shed.git", server.AccessWrite, server.ErrForbidden},
+		"WriteGranted":        {"deploy", "bob/shed.git", server.AccessWrite, nil},
+		"WriteOtherRepo":      {"deploy", "bob/other.git", server.AccessWrite, server.ErrForbidden},
+		"WriteMixedCaseGrant": {"deploy", "bob/tools.git", server.AccessWrite, nil},
+		"AnonymousRead":       {"", "bob/public.git", server.AccessRead, nil},
+		"AnonymousOtherRepo":  {"", "bob/shed.git", server.AccessRead, server.ErrForbidden},
+		"UnknownPrincipal":    {"mallory", "bob/shed.git", server.AccessRead, server.ErrForbidden},
+		"NoAccessIsAlwaysMet": {"mallory", "bob/shed.git", server.AccessNone, nil},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := perms.Authorize(context.Background(), server.Principal{Name: test.principal}, test.repoPath, test.access)
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestReadOnlyPrincipalCannotPush(t *testing.T) {
+	t.Parallel()
+
+	users := server.StaticUsers{"ci-bot": "read-token", "deploy": "deploy-token"}
+	anonymousOrUser := server.AuthenticatorFunc(func(req *http.Request) (server.Principal, error) {
+		if _, _, ok := req.BasicAuth(); !ok {
+			return server.Principal{}, nil
+		}
+
+		return users.Authenticate(req)
+	})
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithAuthenticator(anonymousOrUser),
+		server.WithAuthorizer(server.Permissions{
+			"ci-bot": {server.AnyRepository: server.AccessRead},
+			"deploy": {server.AnyRepository: server.AccessWrite},
+		}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	ciBot := server.BasicAuth{Username: "ci-bot", Password: "read-token"}
+	deploy := server.BasicAuth{Username: "deploy", Password: "deploy-token"}
+
+	local := cloneRepository(t, srv.URL(), ciBot)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	err = push(local, ciBot, "refs/heads/master:refs/heads/master")
+	require.ErrorIs(t, err, transport.ErrAuthorizationFailed)
+
+	err = push(local, noAuth, "refs/heads/master:refs/heads/master")
+	require.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+
+	err = push(local, deploy, "refs/heads/master:refs/heads/master")
+	require.NoError(t, err)
+
+	newCloneAssert(t, srv.URL(), withAuth(ciBot)).assert(filename, "pushed content")
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Endpoint identifies a Git HTTP endpoint faults are injected into.
+type Endpoint string
+
+const (
+	EndpointInfoRefs    Endpoint = infoRefs
+	EndpointUploadPack  Endpoint = uploadPack
+	EndpointReceivePack Endpoint = receivePack
+)
+
+// BodyFault is a fault applied to the response body.
+type BodyFault int
+
+const (
+	// BodyIntact leaves the response body untouched.
+	BodyIntact BodyFault = iota
+	// BodyDrop closes the connection once AfterBytes of the body were
+	// written, the client sees an unexpected end of the response.
+	BodyDrop
+	// BodyTruncate ends the response once AfterBytes of the body were
+	// written, the client sees a well formed but short response.
+	BodyTruncate
+	// BodyCorrupt flips every bit of the body past AfterBytes.
+	BodyCorrupt
+)
+
+// Schedule selects the requests a fault applies to, n is the number of
+// the request to the endpoint since the fault was injected, starting
+// at 1.
+type Schedule func(n int) bool
+
+// OnRequests applies the fault to the listed requests only, e.g. to
+// fail the first request of a retry loop.
+func OnRequests(nth ...int) Schedule {
+	return func(n int) bool {
+		for _, i := range nth {
+			if i == n {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// EveryNth applies the fault to every nth request. It never applies
+// the fault if nth is less than 1.
+func EveryNth(nth int) Schedule {
+	if nth < 1 {
+		return func(int) bool { return false }
+	}
+
+	return func(n int) bool {
+		return n%nth == 0
+	}
+}
+
+// Randomly applies the fault with the given probability, the seed
+// makes the sequence of faulty requests reproducible.
+func Randomly(probability float64, seed int64) Schedule {
+	var mu sync.Mutex
+
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec // not used for security
+
+	return func(int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return rnd.Float64() < probability
+	}
+}
+
+// Fault describes the misbehaviour of an endpoint.
+type Fault struct {
+	// Latency delays the response.
+	Latency time.Duration
+	// Jitter adds a random delay of up to Jitter to the Latency.
+	Jitter time.Duration
+	// Status responds with the status code instead of serving the
+	// request, if non zero.
+	Status int
+	// Body is the fault applied to the response body, from AfterBytes
+	// onwards.
+	Body       BodyFault
+	AfterBytes int64
+	// Schedule selects the requests the fault applies to, every
+	// request if nil.
+	Schedule Schedule
+}
+
+// faultInjector is a middleware injecting faults into the responses
+// of the endpoints.
+type faultInjector struct {
+	mu     sync.Mutex
+	faults map[Endpoint]Fault
+	counts map[Endpoint]int
+	next   http.Handler
+}
+
+func newFaultInjector(next http.Handler) *faultInjector {
+	return &faultInjector{
+		mu:     sync.Mutex{},
+		faults: map[Endpoint]Fault{},
+		counts: map[Endpoint]int{},
+		next:   next,
+	}
+}
+
+// InjectFault injects the fault into every following request to the
+// endpoint, replacing any previous fault. The request count of the
+// Schedule restarts.
+func (h *HTTPTestServer) InjectFault(endpoint Endpoint, fault Fault) {
+	h.faults.mu.Lock()
+	defer h.faults.mu.Unlock()
+
+	h.faults.faults[endpoint] = fault
+	h.faults.counts[endpoint] = 0
+}
+
+// ClearFaults removes every injected fault.
+func (h *HTTPTestServer) ClearFaults() {
+	h.faults.mu.Lock()
+	defer h.faults.mu.Unlock()
+
+	h.faults.faults = map[Endpoint]Fault{}
+	h.faults.counts = map[Endpoint]int{}
+}
+
+// Requests returns the number of requests to the endpoint since the
+// fault was injected, or since the server started.
+func (h *HTTPTestServer) Requests(endpoint Endpoint) int {
+	h.faults.mu.Lock()
+	defer h.faults.mu.Unlock()
+
+	return h.faults.counts[endpoint]
+}
+
+// fault returns the fault applying to the request, if any.
+func (f *faultInjector) fault(req *http.Request) (Fault, bool) {
+	_, rest, ok := splitRepoPath(req.URL.Path)
+	if !ok {
+		return Fault{}, false
+	}
+
+	endpoint := Endpoint(rest)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.counts[endpoint]++
+
+	fault, ok := f.faults[endpoint]
+	if !ok || (fault.Schedule != nil && !fault.Schedule(f.counts[endpoint])) {
+		return Fault{}, false
+	}
+
+	return fault, true
+}
+
+func (f *faultInjector) ServeHTTP(respWriter http.ResponseWriter, req *http.Request) {
+	fault, ok := f.fault(req)
+	if !ok {
+		f.next.ServeHTTP(respWriter, req)
+
+		return
+	}
+
+	delay := fault.Latency
+	if fault.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(fault.Jitter))) //nolint:gosec // not used for security
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		return
+	}
+
+	if fault.Status != 0 {
+		http.Error(respWriter, fmt.Sprintf("injected fault: %s", http.StatusText(fault.Status)), fault.Status)
+
+		return
+	}
+
+	if fault.Body == BodyIntact {
+		f.next.ServeHTTP(respWriter, req)
+
+		return
+	}
+
+	faulty := &faultyWriter{ResponseWriter: respWriter, fault: fault, written: 0, dropped: false}
+	f.next.ServeHTTP(faulty, req)
+
+	if fault.Body == BodyDrop {
+		faulty.drop()
+	}
+}
+
+// faultyWriter applies the body fault of a Fault to a response.
+type faultyWriter struct {
+	http.ResponseWriter
+
+	fault   Fault
+	written int64
+	dropped bool
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	if w.dropped {
+		return 0, http.ErrHijacked
+	}
+
+	remaining := w.fault.AfterBytes - w.written
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	switch w.fault.Body {
+	case BodyDrop, BodyTruncate:
+		if int64(len(p)) <= remaining {
+			break
+		}
+
+		if _, err := w.ResponseWriter.Write(p[:remaining]); err != nil {
+			return 0, fmt.Errorf("write: %w", err)
+		}
+
+		w.written += remaining
+
+		if w.fault.Body == BodyDrop {
+			w.drop()
+		}
+
+		// pretend success so the handler completes
+		return len(p), nil
+	case BodyCorrupt:
+		corrupted := make([]byte, len(p))
+		copy(corrupted, p)
+
+		for i := remaining; i < int64(len(corrupted)); i++ {
+			corrupted[i] ^= 0xff
+		}
+
+		p = corrupted
+	case BodyIntact:
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+
+	if err != nil {
+		return n, fmt.Errorf("write: %w", err)
+	}
+
+	return n, nil
+}
+
+// drop closes the underlying connection, flushing what was written.
+func (w *faultyWriter) drop() {
+	if w.dropped {
+		return
+	}
+
+	w.dropped = true
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	_ = conn.Close()
+}
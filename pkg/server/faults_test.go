@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func tryClone(url string) error {
+	_, err := git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &git.CloneOptions{URL: url})
+
+	return err
+}
+
+//nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
+func TestFaultsFailClone(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		endpoint server.Endpoint
+		fault    server.Fault
+	}{
+		"Status": {
+			server.EndpointInfoRefs,
+			server.Fault{Status: http.StatusServiceUnavailable},
+		},
+		"DropMidPack": {
+			server.EndpointUploadPack,
+			server.Fault{Body: server.BodyDrop, AfterBytes: 64},
+		},
+		"TruncatedPack": {
+			server.EndpointUploadPack,
+			server.Fault{Body: server.BodyTruncate, AfterBytes: 64},
+		},
+		"CorruptedPack": {
+			server.EndpointUploadPack,
+			server.Fault{Body: server.BodyCorrupt, AfterBytes: 64},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			srv.InjectFault(test.endpoint, test.fault)
+			require.Error(t, tryClone(srv.URL()))
+			require.Equal(t, 1, srv.Requests(test.endpoint))
+
+			srv.ClearFaults()
+			newCloneAssert(t, srv.URL()).assert(filename, content)
+		})
+	}
+}
+
+func TestFaultScheduleFailsNthRequest(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	srv.InjectFault(server.EndpointInfoRefs, server.Fault{
+		Status:   http.StatusInternalServerError,
+		Schedule: server.OnRequests(2),
+	})
+
+	require.NoError(t, tryClone(srv.URL()))
+	require.Error(t, tryClone(srv.URL()))
+	require.NoError(t, tryClone(srv.URL()))
+	require.Equal(t, 3, srv.Requests(server.EndpointInfoRefs))
+}
+
+func TestFaultLatency(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	latency := 100 * time.Millisecond
+	srv.InjectFault(server.EndpointUploadPack, server.Fault{Latency: latency, Jitter: 10 * time.Millisecond})
+
+	start := time.Now()
+
+	newCloneAssert(t, srv.URL()).assert(filename, content)
+	require.GreaterOrEqual(t, time.Since(start), latency)
+}
+
+func TestRandomlyIsReproducible(t *testing.T) {
+	t.Parallel()
+
+	first, second := server.Randomly(0.5, 42), server.Randomly(0.5, 42)
+
+	for n := 1; n <= 20; n++ {
+		require.Equal(t, first(n), second(n))
+	}
+
+	require.True(t, server.EveryNth(3)(6))
+	require.False(t, server.EveryNth(3)(7))
+	require.False(t, server.EveryNth(0)(1))
+	require.False(t, server.EveryNth(-2)(2))
+}
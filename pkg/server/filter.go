@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var ErrInvalidFilter = fmt.Errorf("invalid filter")
+
+type filterKind int
+
+const (
+	filterBlobNone filterKind = iota
+	filterBlobLimit
+	filterTreeDepth
+	filterSparse
+)
+
+// objectFilter omits objects from the pack sent to a partial clone,
+// see the --filter option of git-rev-list(1). Objects wanted
+// explicitly are never omitted, which is how clients lazily fetch
+// missing objects afterwards.
+type objectFilter struct {
+	kind filterKind
+	// limit is the blob size limit or the tree depth.
+	limit int64
+	// sparse matches the paths of the blobs included by a sparse
+	// filter.
+	sparse gitignore.Matcher
+}
+
+// parseFilter parses a filter spec as sent by the client, the
+// patterns of a sparse filter are read from the blob in repo. An
+// empty spec results in no filter.
+func parseFilter(repo *git.Repository, spec string) (*objectFilter, error) {
+	kind, value := cutFilter(spec, ":")
+
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "blob:none":
+		return &objectFilter{kind: filterBlobNone, limit: 0, sparse: nil}, nil
+	case kind == "blob" && strings.HasPrefix(value, "limit="):
+		limit, err := parseFilterSize(strings.TrimPrefix(value, "limit="))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+
+		return &objectFilter{kind: filterBlobLimit, limit: limit, sparse: nil}, nil
+	case kind == "tree":
+		depth, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || depth < 0 {
+			return nil, fmt.Errorf("%q: %w", spec, ErrInvalidFilter)
+		}
+
+		return &objectFilter{kind: filterTreeDepth, limit: depth, sparse: nil}, nil
+	case kind == "sparse" && strings.HasPrefix(value, "oid="):
+		matcher, err := sparsePatterns(repo, strings.TrimPrefix(value, "oid="))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+
+		return &objectFilter{kind: filterSparse, limit: 0, sparse: matcher}, nil
+	default:
+		return nil, fmt.Errorf("%q: %w", spec, ErrInvalidFilter)
+	}
+}
+
+// cutFilter slices s around the first instance of sep.
+func cutFilter(s, sep string) (string, string) {
+	parts := strings.SplitN(s, sep, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// parseFilterSize parses a blob size limit with an optional k, m or g
+// unit suffix.
+func parseFilterSize(value string) (int64, error) {
+	units := map[string]int64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+
+	multiplier := int64(1)
+
+	if len(value) > 0 {
+		if unit, ok := units[strings.ToLower(value[len(value)-1:])]; ok {
+			multiplier = unit
+			value = value[:len(value)-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, ErrInvalidFilter
+	}
+
+	return size * multiplier, nil
+}
+
+// sparsePatterns reads the sparse-checkout patterns from the blob
+// named by blobish, either a blob hash or <rev>:<path>.
+func sparsePatterns(repo *git.Repository, blobish string) (gitignore.Matcher, error) {
+	hash := plumbing.NewHash(blobish)
+
+	if rev, filePath := cutFilter(blobish, ":"); filePath != "" {
+		commitHash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", rev, err)
+		}
+
+		commit, err := repo.CommitObject(*commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", commitHash, err)
+		}
+
+		file, err := commit.File(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", filePath, err)
+		}
+
+		hash = file.Hash
+	}
+
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s: %w", blobish, err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("blob reader: %w", err)
+	}
+
+	defer reader.Close()
+
+	return readSparsePatterns(reader)
+}
+
+func readSparsePatterns(r io.Reader) (gitignore.Matcher, error) {
+	patterns := []gitignore.Pattern{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read patterns: %w", err)
+	}
+
+	return gitignore.NewMatcher(patterns), nil
+}
+
+// includesTree reports whether trees at depth below the root tree pass
+// the filter, a nil filter includes everything.
+func (f *objectFilter) includesTree(depth int) bool {
+	return f == nil || f.kind != filterTreeDepth || int64(depth) < f.limit
+}
+
+// includesBlob reports whether the blob at depth below the root tree
+// and blobPath passes the filter.
+func (f *objectFilter) includesBlob(
+	st storer.EncodedObjectStorer, hash plumbing.Hash, depth int, blobPath string,
+) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	switch f.kind {
+	case filterBlobNone:
+		return false, nil
+	case filterBlobLimit:
+		obj, err := st.EncodedObject(plumbing.BlobObject, hash)
+		if err != nil {
+			return false, fmt.Errorf("blob %s: %w", hash, err)
+		}
+
+		return obj.Size() < f.limit, nil
+	case filterTreeDepth:
+		return int64(depth) < f.limit, nil
+	case filterSparse:
+		return f.sparse.Match(strings.Split(blobPath, "/"), false), nil
+	}
+
+	return true, nil
+}
+
+// pathDependent reports whether the filter depends on the path objects
+// are found at, not only on the objects themselves.
+func (f *objectFilter) pathDependent() bool {
+	return f != nil && f.kind == filterSparse
+}
@@ -0,0 +1,182 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchWithFilter(t *testing.T) {
+	t.Parallel()
+
+	testRepo := emptyRepository(t)
+	commitFiles(t, testRepo, map[string]string{
+		filename:    content,
+		".sparse":   "dir/\n",
+		"dir/small": "x",
+		"dir/big":   strings.Repeat("a", 100),
+	}, "initial commit", time.Now())
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	tests := []struct {
+		name   string
+		filter string
+		trees  int
+		blobs  []string
+	}{
+		{"no filter", "", 2, []string{filename, ".sparse", "dir/small", "dir/big"}},
+		{"blob none", "blob:none", 2, []string{}},
+		{"blob limit", "blob:limit=10", 2, []string{".sparse", "dir/small"}},
+		{"blob limit with unit", "blob:limit=1k", 2, []string{filename, ".sparse", "dir/small", "dir/big"}},
+		{"tree depth 0", "tree:0", 0, []string{}},
+		{"tree depth 1", "tree:1", 1, []string{}},
+		{"tree depth 2", "tree:2", 2, []string{filename, ".sparse"}},
+		{"sparse", "sparse:oid=master:.sparse", 2, []string{"dir/small", "dir/big"}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			args := []string{"want " + head.Hash().String(), "done"}
+			if test.filter != "" {
+				args = append(args, "filter "+test.filter)
+			}
+
+			st := fetchPackV2(t, srv.URL(), args...)
+
+			require.Equal(t, test.trees, countObjects(t, st, plumbing.TreeObject))
+			require.Equal(t, len(test.blobs), countObjects(t, st, plumbing.BlobObject))
+
+			for _, name := range test.blobs {
+				require.NoError(t, st.HasEncodedObject(blobHash(t, testRepo, name)), name)
+			}
+		})
+	}
+}
+
+func TestFetchMissingBlobLazily(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	blob := blobHash(t, testRepo, filename)
+	st := fetchPackV2(t, srv.URL(), "want "+blob.String(), "done", "filter blob:none")
+
+	require.Equal(t, 1, countObjects(t, st, plumbing.AnyObject))
+	require.NoError(t, st.HasEncodedObject(blob))
+}
+
+func TestPartialCloneWithGitCLI(t *testing.T) {
+	t.Parallel()
+
+	for _, version := range []string{"0", "2"} {
+		version := version
+
+		t.Run("protocol version "+version, func(t *testing.T) {
+			t.Parallel()
+
+			testRepo := repoWithInitCommit(t, filename, "first content")
+			commitFile(t, testRepo, filename, content, "second commit")
+
+			srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			// the blob of HEAD is fetched lazily on checkout.
+			dir := t.TempDir()
+			runGit(t, dir, version, "clone", "--filter=blob:none", srv.URL(), ".")
+
+			actual, err := os.ReadFile(filepath.Join(dir, filename))
+			require.NoError(t, err)
+			require.Equal(t, content, string(actual))
+
+			// the blob of the first commit was never fetched.
+			missing := runGit(t, dir, version, "rev-list", "--objects", "--all", "--missing=print")
+			require.Contains(t, missing, "?"+blobHash(t, testRepo, filename, "HEAD~1").String())
+		})
+	}
+}
+
+// blobHash returns the hash of the file at name in HEAD, or the
+// revision given.
+func blobHash(t *testing.T, repo *git.Repository, name string, rev ...string) plumbing.Hash {
+	t.Helper()
+
+	revision := plumbing.Revision("HEAD")
+	if len(rev) > 0 {
+		revision = plumbing.Revision(rev[0])
+	}
+
+	hash, err := repo.ResolveRevision(revision)
+	require.NoError(t, err)
+
+	commit, err := repo.CommitObject(*hash)
+	require.NoError(t, err)
+
+	file, err := commit.File(name)
+	require.NoError(t, err)
+
+	return file.Hash
+}
+
+// fetchPackV2 sends a protocol version 2 fetch and stores the objects
+// of the returned pack.
+func fetchPackV2(t *testing.T, url string, args ...string) *memory.Storage {
+	t.Helper()
+
+	resp := postCommandV2(t, url, "fetch", args...)
+	defer resp.Body.Close()
+
+	scanner := pktline.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "packfile\n", string(scanner.Bytes()))
+
+	st := memory.NewStorage()
+	require.NoError(t, packfile.UpdateObjectStorage(st, sideband.NewDemuxer(sideband.Sideband64k, resp.Body)))
+
+	return st
+}
+
+func countObjects(t *testing.T, st *memory.Storage, typ plumbing.ObjectType) int {
+	t.Helper()
+
+	iter, err := st.IterEncodedObjects(typ)
+	require.NoError(t, err)
+
+	count := 0
+
+	require.NoError(t, iter.ForEach(func(plumbing.EncodedObject) error {
+		count++
+
+		return nil
+	}))
+
+	return count
+}
@@ -0,0 +1,651 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// The GitHub API answers a subset of the GitHub REST API under
+// /repos/{owner}/{repo}, see https://docs.github.com/en/rest.
+const (
+	githubPrefix      = "repos"
+	githubMediaType   = "application/json; charset=utf-8"
+	githubTimeFormat  = time.RFC3339
+	githubDefaultPage = 30
+	githubMaxPage     = 100
+	githubNotFound    = "Not Found"
+)
+
+// WithGitHubAPI mounts a fake of the GitHub REST API next to the git
+// endpoints, under /repos/{owner}/{repo} for every repository. It
+// serves the repository, its references, commits and contents from
+// the same repository as git, and pull requests kept in memory. The
+// endpoints are authenticated and authorized like the git endpoints,
+// creating and updating pull requests needs write access.
+func WithGitHubAPI() Option {
+	return func(s *Server) {
+		s.github = newGitHubAPI()
+	}
+}
+
+type githubAPI struct {
+	pulls *pullRequests
+}
+
+func newGitHubAPI() *githubAPI {
+	return &githubAPI{pulls: newPullRequests()}
+}
+
+// githubPath returns the GitHub API path of the repository path, e.g.
+// /repos/owner/name for owner/name.git.
+func githubPath(repoPath string) string {
+	return path.Join("/", githubPrefix, strings.TrimSuffix(repoPath, ".git"))
+}
+
+// splitGitHubPath splits an URL path into the repository path and the
+// remainder following /repos/{owner}/{repo}, which may be mounted under
+// a prefix.
+func splitGitHubPath(urlPath string) (string, string, bool) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	for i := 0; i+2 < len(segments); i++ {
+		if segments[i] != githubPrefix {
+			continue
+		}
+
+		repoPath := RepoPath(segments[i+1], segments[i+2])
+		rest := strings.Join(segments[i+3:], "/")
+
+		return repoPath, rest, true
+	}
+
+	return "", "", false
+}
+
+// isGitHubRequest reports whether the request addresses the GitHub API
+// of a registered repository.
+func (s *Server) isGitHubRequest(req *http.Request) bool {
+	if s.github == nil {
+		return false
+	}
+
+	repoPath, _, ok := splitGitHubPath(req.URL.Path)
+	if !ok {
+		return false
+	}
+
+	_, err := s.registry.Lookup(repoPath)
+
+	return err == nil
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubRepository struct {
+	Name          string     `json:"name"`
+	FullName      string     `json:"full_name"`
+	Owner         githubUser `json:"owner"`
+	Private       bool       `json:"private"`
+	DefaultBranch string     `json:"default_branch"`
+	URL           string     `json:"url"`
+	CloneURL      string     `json:"clone_url"`
+}
+
+type githubObject struct {
+	SHA  string `json:"sha"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type githubRef struct {
+	Ref    string       `json:"ref"`
+	URL    string       `json:"url"`
+	Object githubObject `json:"object"`
+}
+
+type githubSignature struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type githubSHA struct {
+	SHA string `json:"sha"`
+}
+
+type githubCommitDetail struct {
+	Message   string          `json:"message"`
+	Author    githubSignature `json:"author"`
+	Committer githubSignature `json:"committer"`
+	Tree      githubSHA       `json:"tree"`
+}
+
+type githubCommit struct {
+	SHA     string             `json:"sha"`
+	URL     string             `json:"url"`
+	Commit  githubCommitDetail `json:"commit"`
+	Parents []githubSHA        `json:"parents"`
+}
+
+type githubContent struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding,omitempty"`
+	Size     int64  `json:"size"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Content  string `json:"content,omitempty"`
+	SHA      string `json:"sha"`
+	URL      string `json:"url"`
+}
+
+type githubError struct {
+	Message string `json:"message"`
+}
+
+// githubRequest is a request to the GitHub API of a repository.
+type githubRequest struct {
+	*http.Request
+
+	repoPath string
+	repo     *git.Repository
+	// root is the URL the GitHub API is mounted at, base the URL of the
+	// repository in the GitHub API.
+	root string
+	base string
+}
+
+// ServeGitHubAPI serves the GitHub API endpoints below
+// /repos/{owner}/{repo}.
+func (s *Server) ServeGitHubAPI(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, githubPrefix)
+	defer done()
+
+	if s.github == nil {
+		http.NotFound(respWriter, req)
+
+		return
+	}
+
+	_, rest, _ := splitGitHubPath(req.URL.Path)
+
+	access := AccessRead
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		access = AccessWrite
+	}
+
+	ghReq, ok := s.githubRequest(respWriter, req, access)
+	if !ok {
+		return
+	}
+
+	switch segments := strings.Split(rest, "/"); {
+	case req.Method == http.MethodGet && rest == "":
+		s.githubGetRepository(respWriter, ghReq)
+	case req.Method == http.MethodGet && len(segments) > 2 && segments[0] == "git" &&
+		(segments[1] == "ref" || segments[1] == "refs"):
+		s.githubGetRef(respWriter, ghReq, strings.Join(segments[2:], "/"))
+	case req.Method == http.MethodGet && rest == "commits":
+		s.githubListCommits(respWriter, ghReq)
+	case req.Method == http.MethodGet && segments[0] == "commits" && len(segments) > 1:
+		s.githubGetCommit(respWriter, ghReq, strings.Join(segments[1:], "/"))
+	case req.Method == http.MethodGet && segments[0] == "contents":
+		s.githubGetContents(respWriter, ghReq, strings.Join(segments[1:], "/"))
+	case segments[0] == "pulls":
+		s.githubPulls(respWriter, ghReq, segments[1:])
+	default:
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+	}
+}
+
+// githubRequest authenticates and authorizes the request for the
+// repository, it responds with the error otherwise.
+func (s *Server) githubRequest(
+	respWriter http.ResponseWriter, req *http.Request, access Access,
+) (*githubRequest, bool) {
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return nil, false
+	}
+
+	repoPath, _, _ := splitGitHubPath(req.URL.Path)
+
+	repo, err := s.registry.Lookup(repoPath)
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+		return nil, false
+	}
+
+	if !s.authorize(respWriter, req, repoPath, access) {
+		return nil, false
+	}
+
+	root := githubRoot(req)
+
+	return &githubRequest{
+		Request:  req,
+		repoPath: repoPath,
+		repo:     repo,
+		root:     root,
+		base:     root + githubPath(repoPath),
+	}, true
+}
+
+// githubRoot returns the URL the GitHub API the request was sent to
+// is mounted at, which may be below a prefix.
+func githubRoot(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	_, rest, _ := splitGitHubPath(req.URL.Path)
+
+	// the path without the remainder ends in repos/{owner}/{repo}.
+	segments := strings.Split(strings.Trim(strings.TrimSuffix(strings.Trim(req.URL.Path, "/"), rest), "/"), "/")
+	prefix := strings.Join(segments[:len(segments)-3], "/")
+
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, req.Host, prefix)
+}
+
+func (s *Server) githubGetRepository(respWriter http.ResponseWriter, req *githubRequest) {
+	owner, name := path.Split(strings.TrimSuffix(req.repoPath, ".git"))
+	owner = strings.TrimSuffix(owner, "/")
+
+	writeGitHubJSON(respWriter, http.StatusOK, githubRepository{
+		Name:          name,
+		FullName:      owner + "/" + name,
+		Owner:         githubUser{Login: owner},
+		Private:       s.authenticator != nil,
+		DefaultBranch: defaultBranch(req.repo),
+		URL:           req.base,
+		CloneURL:      req.root + "/" + req.repoPath,
+	})
+}
+
+// defaultBranch returns the branch HEAD points to.
+func defaultBranch(repo *git.Repository) string {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return ""
+	}
+
+	return head.Target().Short()
+}
+
+func (s *Server) githubGetRef(respWriter http.ResponseWriter, req *githubRequest, name string) {
+	ref, err := req.repo.Reference(plumbing.ReferenceName("refs/"+name), true)
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+		return
+	}
+
+	objType := "commit"
+	if _, err := req.repo.TagObject(ref.Hash()); err == nil {
+		objType = "tag"
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, githubRef{
+		Ref: ref.Name().String(),
+		URL: req.base + "/git/" + ref.Name().String(),
+		Object: githubObject{
+			SHA:  ref.Hash().String(),
+			Type: objType,
+			URL:  fmt.Sprintf("%s/git/%ss/%s", req.base, objType, ref.Hash()),
+		},
+	})
+}
+
+// resolve returns the commit of a branch, tag or commit hash, HEAD if
+// rev is empty.
+func (r *githubRequest) resolve(rev string) (*object.Commit, error) {
+	if rev == "" {
+		rev = plumbing.HEAD.String()
+	}
+
+	return resolveCommit(r.repo, rev)
+}
+
+func (s *Server) githubListCommits(respWriter http.ResponseWriter, req *githubRequest) {
+	query := req.URL.Query()
+
+	page, perPage, ok := githubPagination(respWriter, query)
+	if !ok {
+		return
+	}
+
+	from, err := req.resolve(query.Get("sha"))
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusNotFound, err.Error())
+
+		return
+	}
+
+	opts := &git.LogOptions{From: from.Hash, Order: git.LogOrderCommitterTime}
+
+	if filePath := strings.Trim(query.Get("path"), "/"); filePath != "" {
+		opts.PathFilter = func(p string) bool {
+			return p == filePath || strings.HasPrefix(p, filePath+"/")
+		}
+	}
+
+	iter, err := req.repo.Log(opts)
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	defer iter.Close()
+
+	skip, ok := githubOffset(page, perPage)
+	if !ok {
+		writeGitHubJSON(respWriter, http.StatusOK, []githubCommit{})
+
+		return
+	}
+
+	commits, more := []githubCommit{}, false
+
+	err = iter.ForEach(func(commit *object.Commit) error {
+		switch {
+		case skip > 0:
+			skip--
+		case len(commits) == perPage:
+			more = true
+
+			return storer.ErrStop
+		default:
+			commits = append(commits, req.commit(commit))
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	if more {
+		next := url.Values{}
+		for key, values := range query {
+			next[key] = values
+		}
+
+		next.Set("page", strconv.Itoa(page+1))
+		next.Set("per_page", strconv.Itoa(perPage))
+
+		respWriter.Header().Set("Link", fmt.Sprintf(`<%s/commits?%s>; rel="next"`, req.base, next.Encode()))
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, commits)
+}
+
+// githubPagination parses the page and per_page query parameters.
+func githubPagination(respWriter http.ResponseWriter, query url.Values) (int, int, bool) {
+	page, perPage := 1, githubDefaultPage
+
+	for param, value := range map[string]*int{"page": &page, "per_page": &perPage} {
+		raw := query.Get(param)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			writeGitHubError(respWriter, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", param, raw))
+
+			return 0, 0, false
+		}
+
+		*value = parsed
+	}
+
+	if perPage > githubMaxPage {
+		perPage = githubMaxPage
+	}
+
+	return page, perPage, true
+}
+
+// githubOffset returns the number of items before the page, it is
+// false if the offset overflows, i.e. the page is past the end of any
+// list.
+func githubOffset(page, perPage int) (int, bool) {
+	if page-1 > math.MaxInt/perPage {
+		return 0, false
+	}
+
+	return (page - 1) * perPage, true
+}
+
+func (s *Server) githubGetCommit(respWriter http.ResponseWriter, req *githubRequest, rev string) {
+	commit, err := req.resolve(rev)
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusNotFound, err.Error())
+
+		return
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, req.commit(commit))
+}
+
+func (r *githubRequest) commit(commit *object.Commit) githubCommit {
+	parents := make([]githubSHA, 0, len(commit.ParentHashes))
+	for _, parent := range commit.ParentHashes {
+		parents = append(parents, githubSHA{SHA: parent.String()})
+	}
+
+	return githubCommit{
+		SHA: commit.Hash.String(),
+		URL: r.base + "/commits/" + commit.Hash.String(),
+		Commit: githubCommitDetail{
+			Message:   commit.Message,
+			Author:    githubSignatureOf(commit.Author),
+			Committer: githubSignatureOf(commit.Committer),
+			Tree:      githubSHA{SHA: commit.TreeHash.String()},
+		},
+		Parents: parents,
+	}
+}
+
+func githubSignatureOf(sig object.Signature) githubSignature {
+	return githubSignature{
+		Name:  sig.Name,
+		Email: sig.Email,
+		Date:  sig.When.UTC().Format(githubTimeFormat),
+	}
+}
+
+// githubGetContents responds with the file at filePath, or the entries
+// of the directory, at the ref query parameter.
+func (s *Server) githubGetContents(respWriter http.ResponseWriter, req *githubRequest, filePath string) {
+	commit, err := req.resolve(req.URL.Query().Get("ref"))
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusNotFound, err.Error())
+
+		return
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	filePath = strings.Trim(filePath, "/")
+	if filePath != "" {
+		entry, err := tree.FindEntry(filePath)
+		if err != nil {
+			writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+			return
+		}
+
+		if entry.Mode != filemode.Dir {
+			content, err := req.fileContent(filePath, entry)
+			if err != nil {
+				writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+				return
+			}
+
+			writeGitHubJSON(respWriter, http.StatusOK, content)
+
+			return
+		}
+
+		if tree, err = tree.Tree(filePath); err != nil {
+			writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+			return
+		}
+	}
+
+	entries := make([]githubContent, 0, len(tree.Entries))
+
+	for _, entry := range tree.Entries {
+		entryPath := path.Join(filePath, entry.Name)
+
+		var size int64
+
+		if entry.Mode.IsFile() {
+			if size, err = tree.Size(entry.Name); err != nil {
+				writeGitHubError(respWriter, http.StatusInternalServerError, err.Error())
+
+				return
+			}
+		}
+
+		entries = append(entries, githubContent{
+			Type:     githubContentType(entry.Mode),
+			Encoding: "",
+			Size:     size,
+			Name:     entry.Name,
+			Path:     entryPath,
+			Content:  "",
+			SHA:      entry.Hash.String(),
+			URL:      req.base + "/contents/" + entryPath,
+		})
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, entries)
+}
+
+// fileContent returns the base64 encoded content of the blob, symlinks
+// and submodules are returned without content.
+func (r *githubRequest) fileContent(filePath string, entry *object.TreeEntry) (githubContent, error) {
+	content := githubContent{
+		Type:     githubContentType(entry.Mode),
+		Encoding: "",
+		Size:     0,
+		Name:     path.Base(filePath),
+		Path:     filePath,
+		Content:  "",
+		SHA:      entry.Hash.String(),
+		URL:      r.base + "/contents/" + filePath,
+	}
+
+	if !entry.Mode.IsFile() {
+		return content, nil
+	}
+
+	blob, err := r.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return content, fmt.Errorf("blob %s: %w", entry.Hash, err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return content, fmt.Errorf("read %s: %w", entry.Hash, err)
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return content, fmt.Errorf("read %s: %w", entry.Hash, err)
+	}
+
+	content.Encoding = "base64"
+	content.Size = blob.Size
+	content.Content = base64.StdEncoding.EncodeToString(data)
+
+	return content, nil
+}
+
+func githubContentType(mode filemode.FileMode) string {
+	switch mode {
+	case filemode.Dir:
+		return "dir"
+	case filemode.Symlink:
+		return "symlink"
+	case filemode.Submodule:
+		return "submodule"
+	default:
+		return "file"
+	}
+}
+
+func writeGitHubError(respWriter http.ResponseWriter, status int, message string) {
+	writeGitHubJSON(respWriter, status, githubError{Message: message})
+}
+
+// writeGitHubInvalid responds with the validation error of an invalid
+// field value.
+func writeGitHubInvalid(respWriter http.ResponseWriter, field, value string) {
+	message := fmt.Sprintf("Validation Failed: %s %q is invalid", field, value)
+	writeGitHubError(respWriter, http.StatusUnprocessableEntity, message)
+}
+
+func writeGitHubJSON(respWriter http.ResponseWriter, status int, body interface{}) {
+	respWriter.Header().Set("Content-Type", githubMediaType)
+	respWriter.WriteHeader(status)
+
+	_ = json.NewEncoder(respWriter).Encode(body)
+}
+
+// decodeGitHubJSON decodes the request body into v, it responds with
+// 400 Bad Request if the body is invalid.
+func decodeGitHubJSON(respWriter http.ResponseWriter, req *githubRequest, v interface{}) bool {
+	err := json.NewDecoder(req.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) {
+		writeGitHubError(respWriter, http.StatusBadRequest, "Problems parsing JSON")
+
+		return false
+	}
+
+	writeGitHubError(respWriter, http.StatusBadRequest, err.Error())
+
+	return false
+}
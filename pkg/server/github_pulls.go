@@ -0,0 +1,409 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// The states of a pull request.
+const (
+	PullRequestOpen   = "open"
+	PullRequestClosed = "closed"
+)
+
+// PullRequest is a pull request created through the GitHub API, Head
+// and Base are branch names.
+type PullRequest struct {
+	Number    int
+	Title     string
+	Body      string
+	Head      string
+	Base      string
+	State     string
+	Draft     bool
+	User      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// ClosedAt is the zero time while the pull request is open.
+	ClosedAt time.Time
+}
+
+// PullRequests returns the pull requests of the repository path, e.g.
+// owner/name.git, ordered by number. It returns nil if the GitHub API
+// is not enabled.
+func (s *Server) PullRequests(repoPath string) []PullRequest {
+	if s.github == nil {
+		return nil
+	}
+
+	return s.github.pulls.list(repoPath, func(PullRequest) bool { return true })
+}
+
+// pullRequests holds the pull requests of every repository, numbered
+// per repository starting at 1.
+type pullRequests struct {
+	mu    sync.Mutex
+	pulls map[string][]*PullRequest
+}
+
+func newPullRequests() *pullRequests {
+	return &pullRequests{
+		mu:    sync.Mutex{},
+		pulls: map[string][]*PullRequest{},
+	}
+}
+
+// create adds the pull request unless an open one with the same head
+// and base exists, which is returned instead.
+func (p *pullRequests) create(repoPath string, pull PullRequest) (PullRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, existing := range p.pulls[repoPath] {
+		if existing.State == PullRequestOpen && existing.Head == pull.Head && existing.Base == pull.Base {
+			return *existing, false
+		}
+	}
+
+	pull.Number = len(p.pulls[repoPath]) + 1
+	p.pulls[repoPath] = append(p.pulls[repoPath], &pull)
+
+	return pull, true
+}
+
+func (p *pullRequests) list(repoPath string, match func(PullRequest) bool) []PullRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pulls := []PullRequest{}
+
+	for _, pull := range p.pulls[repoPath] {
+		if match(*pull) {
+			pulls = append(pulls, *pull)
+		}
+	}
+
+	return pulls
+}
+
+func (p *pullRequests) get(repoPath string, number int) (PullRequest, bool) {
+	return p.update(repoPath, number, func(*PullRequest) {})
+}
+
+// update applies fn to the pull request with number, it returns false
+// if there is no such pull request.
+func (p *pullRequests) update(repoPath string, number int, fn func(*PullRequest)) (PullRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pulls := p.pulls[repoPath]
+	if number < 1 || number > len(pulls) {
+		return PullRequest{}, false
+	}
+
+	fn(pulls[number-1])
+
+	return *pulls[number-1], true
+}
+
+type githubBranch struct {
+	Label string `json:"label"`
+	Ref   string `json:"ref"`
+	SHA   string `json:"sha"`
+}
+
+type githubPullRequest struct {
+	URL       string       `json:"url"`
+	HTMLURL   string       `json:"html_url"`
+	Number    int          `json:"number"`
+	State     string       `json:"state"`
+	Title     string       `json:"title"`
+	Body      string       `json:"body"`
+	Draft     bool         `json:"draft"`
+	Merged    bool         `json:"merged"`
+	User      githubUser   `json:"user"`
+	Head      githubBranch `json:"head"`
+	Base      githubBranch `json:"base"`
+	CreatedAt string       `json:"created_at"`
+	UpdatedAt string       `json:"updated_at"`
+	ClosedAt  *string      `json:"closed_at"`
+}
+
+type githubCreatePullRequest struct {
+	Title string `json:"title"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Body  string `json:"body"`
+	Draft bool   `json:"draft"`
+}
+
+type githubUpdatePullRequest struct {
+	Title *string `json:"title"`
+	Body  *string `json:"body"`
+	State *string `json:"state"`
+	Base  *string `json:"base"`
+}
+
+// githubPulls serves the pulls endpoints, segments follow pulls/.
+func (s *Server) githubPulls(respWriter http.ResponseWriter, req *githubRequest, segments []string) {
+	if len(segments) == 0 || segments[0] == "" {
+		switch req.Method {
+		case http.MethodGet:
+			s.githubListPulls(respWriter, req)
+		case http.MethodPost:
+			s.githubCreatePull(respWriter, req)
+		default:
+			writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+		}
+
+		return
+	}
+
+	number, err := strconv.Atoi(segments[0])
+	if err != nil || len(segments) > 1 {
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		s.githubGetPull(respWriter, req, number)
+	case http.MethodPatch:
+		s.githubUpdatePull(respWriter, req, number)
+	default:
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+	}
+}
+
+func (s *Server) githubCreatePull(respWriter http.ResponseWriter, req *githubRequest) {
+	var create githubCreatePullRequest
+
+	if !decodeGitHubJSON(respWriter, req, &create) {
+		return
+	}
+
+	head := req.branch(create.Head)
+
+	switch {
+	case create.Title == "":
+		writeGitHubError(respWriter, http.StatusUnprocessableEntity, "Validation Failed: title is missing")
+
+		return
+	case !req.hasBranch(head):
+		writeGitHubInvalid(respWriter, "head", create.Head)
+
+		return
+	case !req.hasBranch(create.Base):
+		writeGitHubInvalid(respWriter, "base", create.Base)
+
+		return
+	case head == create.Base:
+		writeGitHubError(respWriter, http.StatusUnprocessableEntity, "Validation Failed: no commits between base and head")
+
+		return
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+	now := time.Now().UTC().Truncate(time.Second)
+
+	pull, created := s.github.pulls.create(req.repoPath, PullRequest{
+		Number:    0,
+		Title:     create.Title,
+		Body:      create.Body,
+		Head:      head,
+		Base:      create.Base,
+		State:     PullRequestOpen,
+		Draft:     create.Draft,
+		User:      principalName(principal),
+		CreatedAt: now,
+		UpdatedAt: now,
+		ClosedAt:  time.Time{},
+	})
+	if !created {
+		writeGitHubError(respWriter, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Validation Failed: a pull request already exists for %s (#%d)", req.label(head), pull.Number))
+
+		return
+	}
+
+	writeGitHubJSON(respWriter, http.StatusCreated, req.pullRequest(pull))
+}
+
+func (s *Server) githubListPulls(respWriter http.ResponseWriter, req *githubRequest) {
+	query := req.URL.Query()
+
+	state := query.Get("state")
+	if state == "" {
+		state = PullRequestOpen
+	}
+
+	if state != PullRequestOpen && state != PullRequestClosed && state != "all" {
+		writeGitHubInvalid(respWriter, "state", state)
+
+		return
+	}
+
+	page, perPage, ok := githubPagination(respWriter, query)
+	if !ok {
+		return
+	}
+
+	head := req.branch(query.Get("head"))
+
+	pulls := s.github.pulls.list(req.repoPath, func(pull PullRequest) bool {
+		return (state == "all" || pull.State == state) &&
+			(head == "" || pull.Head == head) &&
+			(query.Get("base") == "" || pull.Base == query.Get("base"))
+	})
+
+	resp := []githubPullRequest{}
+
+	if skip, ok := githubOffset(page, perPage); ok && skip < len(pulls) {
+		end := skip + perPage
+		if end > len(pulls) {
+			end = len(pulls)
+		}
+
+		for _, pull := range pulls[skip:end] {
+			resp = append(resp, req.pullRequest(pull))
+		}
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, resp)
+}
+
+func (s *Server) githubGetPull(respWriter http.ResponseWriter, req *githubRequest, number int) {
+	pull, ok := s.github.pulls.get(req.repoPath, number)
+	if !ok {
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+		return
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, req.pullRequest(pull))
+}
+
+func (s *Server) githubUpdatePull(respWriter http.ResponseWriter, req *githubRequest, number int) {
+	var update githubUpdatePullRequest
+
+	if !decodeGitHubJSON(respWriter, req, &update) {
+		return
+	}
+
+	if update.State != nil && *update.State != PullRequestOpen && *update.State != PullRequestClosed {
+		writeGitHubInvalid(respWriter, "state", *update.State)
+
+		return
+	}
+
+	if update.Base != nil && !req.hasBranch(*update.Base) {
+		writeGitHubInvalid(respWriter, "base", *update.Base)
+
+		return
+	}
+
+	pull, ok := s.github.pulls.update(req.repoPath, number, func(pull *PullRequest) {
+		now := time.Now().UTC().Truncate(time.Second)
+
+		if update.Title != nil {
+			pull.Title = *update.Title
+		}
+
+		if update.Body != nil {
+			pull.Body = *update.Body
+		}
+
+		if update.Base != nil {
+			pull.Base = *update.Base
+		}
+
+		if update.State != nil && *update.State != pull.State {
+			pull.State = *update.State
+			pull.ClosedAt = time.Time{}
+
+			if pull.State == PullRequestClosed {
+				pull.ClosedAt = now
+			}
+		}
+
+		pull.UpdatedAt = now
+	})
+	if !ok {
+		writeGitHubError(respWriter, http.StatusNotFound, githubNotFound)
+
+		return
+	}
+
+	writeGitHubJSON(respWriter, http.StatusOK, req.pullRequest(pull))
+}
+
+// branch returns the branch name of a head, which may be qualified by
+// the owner as in owner:branch.
+func (r *githubRequest) branch(head string) string {
+	if i := strings.Index(head, ":"); i >= 0 {
+		return head[i+1:]
+	}
+
+	return head
+}
+
+func (r *githubRequest) hasBranch(branch string) bool {
+	if branch == "" {
+		return false
+	}
+
+	_, err := r.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+
+	return err == nil
+}
+
+// label returns the owner qualified branch name.
+func (r *githubRequest) label(branch string) string {
+	return strings.SplitN(r.repoPath, "/", 2)[0] + ":" + branch
+}
+
+// pullRequest renders the pull request, the head and base hashes are
+// those of the branches at the time of the request.
+func (r *githubRequest) pullRequest(pull PullRequest) githubPullRequest {
+	branch := func(name string) githubBranch {
+		sha := ""
+
+		ref, err := r.repo.Reference(plumbing.NewBranchReferenceName(name), true)
+		if err == nil {
+			sha = ref.Hash().String()
+		}
+
+		return githubBranch{Label: r.label(name), Ref: name, SHA: sha}
+	}
+
+	var closedAt *string
+
+	if !pull.ClosedAt.IsZero() {
+		closed := pull.ClosedAt.Format(githubTimeFormat)
+		closedAt = &closed
+	}
+
+	return githubPullRequest{
+		URL:       fmt.Sprintf("%s/pulls/%d", r.base, pull.Number),
+		HTMLURL:   fmt.Sprintf("%s/%s/pull/%d", r.root, strings.TrimSuffix(r.repoPath, ".git"), pull.Number),
+		Number:    pull.Number,
+		State:     pull.State,
+		Title:     pull.Title,
+		Body:      pull.Body,
+		Draft:     pull.Draft,
+		Merged:    false,
+		User:      githubUser{Login: pull.User},
+		Head:      branch(pull.Head),
+		Base:      branch(pull.Base),
+		CreatedAt: pull.CreatedAt.Format(githubTimeFormat),
+		UpdatedAt: pull.UpdatedAt.Format(githubTimeFormat),
+		ClosedAt:  closedAt,
+	}
+}
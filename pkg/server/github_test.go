@@ -0,0 +1,353 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+type githubRef struct {
+	Ref    string `json:"ref"`
+	Object struct {
+		SHA  string `json:"sha"`
+		Type string `json:"type"`
+	} `json:"object"`
+}
+
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commit"`
+	Parents []struct {
+		SHA string `json:"sha"`
+	} `json:"parents"`
+}
+
+type githubContent struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	SHA      string `json:"sha"`
+}
+
+type githubPullRequest struct {
+	Number int    `json:"number"`
+	State  string `json:"state"`
+	Title  string `json:"title"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	ClosedAt *string `json:"closed_at"`
+}
+
+func TestGitHubAPIServesRepository(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	first := commitFile(t, testRepo, "docs/readme.md", "read me", "add docs")
+	second := commitFile(t, testRepo, filename, "second content", "second commit")
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithGitHubAPI())
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	api := fmt.Sprintf("%s/repos/%s/%s", srv.TS.URL, owner, repoName)
+
+	var repo struct {
+		FullName      string `json:"full_name"`
+		DefaultBranch string `json:"default_branch"`
+		CloneURL      string `json:"clone_url"`
+	}
+
+	resp := githubDo(t, http.MethodGet, api, nil, noAuth, &repo)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, owner+"/"+repoName, repo.FullName)
+	require.Equal(t, "master", repo.DefaultBranch)
+	require.Equal(t, srv.URL(), repo.CloneURL)
+
+	var ref githubRef
+
+	resp = githubDo(t, http.MethodGet, api+"/git/ref/heads/master", nil, noAuth, &ref)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "refs/heads/master", ref.Ref)
+	require.Equal(t, second.String(), ref.Object.SHA)
+	require.Equal(t, "commit", ref.Object.Type)
+
+	resp = githubDo(t, http.MethodGet, api+"/git/ref/heads/missing", nil, noAuth, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var commits []githubCommit
+
+	resp = githubDo(t, http.MethodGet, api+"/commits?sha=master", nil, noAuth, &commits)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, commits, 3)
+	require.Equal(t, second.String(), commits[0].SHA)
+	require.Equal(t, "second commit", commits[0].Commit.Message)
+	require.Equal(t, "bob the builder", commits[0].Commit.Author.Name)
+	require.Equal(t, first.String(), commits[0].Parents[0].SHA)
+
+	resp = githubDo(t, http.MethodGet, api+"/commits?path=docs", nil, noAuth, &commits)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, commits, 1)
+	require.Equal(t, first.String(), commits[0].SHA)
+
+	resp = githubDo(t, http.MethodGet, api+"/commits?per_page=2", nil, noAuth, &commits)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, commits, 2)
+	require.Contains(t, resp.Header.Get("Link"), `page=2`)
+
+	resp = githubDo(t, http.MethodGet, api+"/commits?per_page=2&page=2", nil, noAuth, &commits)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, commits, 1)
+	require.Empty(t, resp.Header.Get("Link"))
+
+	// the offset of the page overflows.
+	resp = githubDo(t, http.MethodGet, api+"/commits?per_page=30&page=307445734561825862", nil, noAuth, &commits)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, commits)
+
+	var commit githubCommit
+
+	resp = githubDo(t, http.MethodGet, api+"/commits/"+first.String(), nil, noAuth, &commit)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "add docs", commit.Commit.Message)
+
+	var file githubContent
+
+	resp = githubDo(t, http.MethodGet, api+"/contents/"+filename, nil, noAuth, &file)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "file", file.Type)
+	require.Equal(t, "base64", file.Encoding)
+
+	decoded, err := base64.StdEncoding.DecodeString(file.Content)
+	require.NoError(t, err)
+	require.Equal(t, "second content", string(decoded))
+
+	resp = githubDo(t, http.MethodGet, api+"/contents/"+filename+"?ref="+first.String(), nil, noAuth, &file)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	decoded, err = base64.StdEncoding.DecodeString(file.Content)
+	require.NoError(t, err)
+	require.Equal(t, content, string(decoded))
+
+	var dir []githubContent
+
+	resp = githubDo(t, http.MethodGet, api+"/contents/", nil, noAuth, &dir)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, dir, 2)
+	require.Equal(t, "docs", dir[0].Name)
+	require.Equal(t, "dir", dir[0].Type)
+	require.Equal(t, filename, dir[1].Name)
+
+	resp = githubDo(t, http.MethodGet, api+"/contents/docs", nil, noAuth, &dir)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, dir, 1)
+	require.Equal(t, "docs/readme.md", dir[0].Path)
+
+	resp = githubDo(t, http.MethodGet, api+"/contents/missing", nil, noAuth, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGitHubAPIPullRequests(t *testing.T) {
+	t.Parallel()
+
+	users := server.StaticUsers{"alice": "secret", "ci-bot": "read-token"}
+	alice := server.BasicAuth{Username: "alice", Password: "secret"}
+	ciBot := server.BasicAuth{Username: "ci-bot", Password: "read-token"}
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithGitHubAPI(),
+		server.WithAuthenticator(users),
+		server.WithAuthorizer(server.Permissions{
+			"alice":  {server.AnyRepository: server.AccessWrite},
+			"ci-bot": {server.AnyRepository: server.AccessRead},
+		}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), alice)
+	feature := commitFile(t, local, filename, "feature content", "feature commit")
+	require.NoError(t, push(local, alice, "refs/heads/master:refs/heads/feature"))
+
+	pulls := fmt.Sprintf("%s/repos/%s/%s/pulls", srv.TS.URL, owner, repoName)
+	create := map[string]interface{}{"title": "Add feature", "head": owner + ":feature", "base": "master"}
+
+	resp := githubDo(t, http.MethodPost, pulls, create, ciBot, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	var pull githubPullRequest
+
+	resp = githubDo(t, http.MethodPost, pulls, create, alice, &pull)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, 1, pull.Number)
+	require.Equal(t, server.PullRequestOpen, pull.State)
+	require.Equal(t, "alice", pull.User.Login)
+	require.Equal(t, "feature", pull.Head.Ref)
+	require.Equal(t, feature.String(), pull.Head.SHA)
+	require.Equal(t, "master", pull.Base.Ref)
+
+	resp = githubDo(t, http.MethodPost, pulls, create, alice, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "duplicate pull request")
+
+	missing := map[string]interface{}{"title": "Missing", "head": "missing", "base": "master"}
+	resp = githubDo(t, http.MethodPost, pulls, missing, alice, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "missing head")
+
+	// the head follows pushes to the branch.
+	pushed := commitFile(t, local, filename, "more content", "another commit")
+	require.NoError(t, push(local, alice, "refs/heads/master:refs/heads/feature"))
+
+	resp = githubDo(t, http.MethodGet, pulls+"/1", nil, ciBot, &pull)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, pushed.String(), pull.Head.SHA)
+
+	var listed []githubPullRequest
+
+	resp = githubDo(t, http.MethodGet, pulls+"?head="+owner+":feature", nil, ciBot, &listed)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, listed, 1)
+
+	resp = githubDo(t, http.MethodPatch, pulls+"/1", map[string]string{"state": "closed"}, alice, &pull)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, server.PullRequestClosed, pull.State)
+	require.NotNil(t, pull.ClosedAt)
+
+	resp = githubDo(t, http.MethodGet, pulls, nil, ciBot, &listed)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, listed)
+
+	resp = githubDo(t, http.MethodGet, pulls+"?state=all", nil, ciBot, &listed)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, listed, 1)
+
+	resp = githubDo(t, http.MethodGet, pulls+"?state=all&page=2", nil, ciBot, &listed)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, listed)
+
+	// the offset of the page overflows.
+	resp = githubDo(t, http.MethodGet, pulls+"?state=all&page=307445734561825862", nil, ciBot, &listed)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, listed)
+
+	resp = githubDo(t, http.MethodGet, pulls+"/2", nil, ciBot, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	recorded := srv.Server.PullRequests(server.RepoPath(owner, repoName))
+	require.Len(t, recorded, 1)
+	require.Equal(t, "Add feature", recorded[0].Title)
+	require.Equal(t, server.PullRequestClosed, recorded[0].State)
+}
+
+func TestGitHubAPIRoutes(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.New(repoWithInitCommit(t, filename, content), owner, repoName, server.WithGitHubAPI())
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+
+	ginEngine := gin.New()
+	srv.SetupGinRoutes(ginEngine)
+
+	for name, handler := range map[string]http.Handler{"ServeMux": mux, "Gin": ginEngine} {
+		handler := handler
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(handler)
+			t.Cleanup(ts.Close)
+
+			api := fmt.Sprintf("%s/repos/%s/%s", ts.URL, owner, repoName)
+
+			resp := githubDo(t, http.MethodGet, api, nil, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var ref githubRef
+
+			resp = githubDo(t, http.MethodGet, api+"/git/ref/heads/master", nil, noAuth, &ref)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "refs/heads/master", ref.Ref)
+		})
+	}
+}
+
+func TestGitHubAPIIsDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := githubDo(t, http.MethodGet, fmt.Sprintf("%s/repos/%s/%s", srv.TS.URL, owner, repoName), nil, noAuth, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// githubDo sends a GitHub API request with a JSON body, if given, and
+// decodes the JSON response into out if given.
+func githubDo(
+	t *testing.T, method, url string, body interface{}, auth server.BasicAuth, out interface{},
+) *http.Response {
+	t.Helper()
+
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	require.NoError(t, err)
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	if auth != noAuth {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+
+	return resp
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The archive endpoint serves the tree of a revision as
+// archive/<rev>.<format>, like git archive does.
+const archivePath = "archive"
+
+var (
+	ErrInvalidArchivePath = fmt.Errorf("invalid archive path")
+	ErrAmbiguousRevision  = fmt.Errorf("ambiguous revision")
+)
+
+type archiveFormat struct {
+	ext         string
+	contentType string
+	write       func(w io.Writer, commit *object.Commit, entries []archiveEntry) error
+}
+
+var archiveFormats = []archiveFormat{ //nolint:gochecknoglobals
+	{ext: ".tar.gz", contentType: "application/gzip", write: writeTarGz},
+	{ext: ".tgz", contentType: "application/gzip", write: writeTarGz},
+	{ext: ".tar", contentType: "application/x-tar", write: writeTar},
+	{ext: ".zip", contentType: "application/zip", write: writeZip},
+}
+
+// archiveEntry is a file or directory of the archive, name includes
+// the prefix and directories end in a slash.
+type archiveEntry struct {
+	name string
+	mode filemode.FileMode
+	blob *object.Blob
+}
+
+// GetArchive streams the tree of a commit, branch or tag as tar.gz,
+// tar or zip archive. The prefix query parameter is prepended to every
+// path of the archive, e.g. name-1.0/, and the path query parameter
+// selects a subdirectory of the tree.
+func (s *Server) GetArchive(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, archivePath)
+	defer done()
+
+	repo, ok := s.readRequest(respWriter, req)
+	if !ok {
+		return
+	}
+
+	repoPath, rest, _ := splitRepoPath(req.URL.Path)
+
+	rev, format, err := parseArchivePath(strings.TrimPrefix(rest, archivePath+"/"))
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	query := req.URL.Query()
+
+	entries, err := archiveEntries(repo, commit, query.Get("path"), query.Get("prefix"))
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), s.SessionTimeout)
+	defer cancel()
+
+	name := strings.TrimSuffix(path.Base(repoPath), ".git") + "-" + strings.ReplaceAll(rev, "/", "-") + format.ext
+
+	respWriter.Header().Set("Content-Type", format.contentType)
+	respWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	respWriter.Header().Set("ETag", fmt.Sprintf("%q", commit.Hash.String()+format.ext))
+	respWriter.WriteHeader(http.StatusOK)
+
+	// the status is sent already, a failure shows as a broken archive.
+	_ = format.write(&contextWriter{ctx: ctx, w: respWriter}, commit, entries)
+}
+
+// parseArchivePath splits <rev>.<format> into the revision and the
+// format of the archive.
+func parseArchivePath(name string) (string, archiveFormat, error) {
+	for _, format := range archiveFormats {
+		rev := strings.TrimSuffix(name, format.ext)
+		if rev != name && rev != "" {
+			return rev, format, nil
+		}
+	}
+
+	return "", archiveFormat{}, fmt.Errorf("%s: %w", name, ErrInvalidArchivePath)
+}
+
+// resolveCommit resolves a branch, tag or commit hash to its commit,
+// annotated tags are peeled.
+func resolveCommit(repo *git.Repository, rev string) (*object.Commit, error) {
+	if ambiguousHashPrefix(repo, rev) {
+		return nil, fmt.Errorf("%s: %w", rev, ErrAmbiguousRevision)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", rev, err)
+	}
+
+	peeled, _ := peelTag(repo, *hash)
+
+	commit, err := repo.CommitObject(peeled)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", peeled, err)
+	}
+
+	return commit, nil
+}
+
+// ambiguousHashPrefix reports whether rev is a short hash of more than
+// one commit or tag. go-git resolves it to any of them, git refuses to.
+func ambiguousHashPrefix(repo *git.Repository, rev string) bool {
+	prefix := strings.ToLower(rev)
+	if prefix == "" || len(prefix) >= len(plumbing.ZeroHash.String()) || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return false
+	}
+
+	matches := 0
+
+	for _, typ := range []plumbing.ObjectType{plumbing.CommitObject, plumbing.TagObject} {
+		iter, err := repo.Storer.IterEncodedObjects(typ)
+		if err != nil {
+			return false
+		}
+
+		_ = iter.ForEach(func(obj plumbing.EncodedObject) error {
+			if strings.HasPrefix(obj.Hash().String(), prefix) {
+				matches++
+			}
+
+			return nil
+		})
+	}
+
+	return matches > 1
+}
+
+// archiveEntries lists the directories and files of the subdirectory
+// of the commit tree, in tree order.
+func archiveEntries(repo *git.Repository, commit *object.Commit, subdir, prefix string) ([]archiveEntry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("tree of %s: %w", commit.Hash, err)
+	}
+
+	if subdir = strings.Trim(subdir, "/"); subdir != "" {
+		if tree, err = tree.Tree(subdir); err != nil {
+			return nil, fmt.Errorf("path %s: %w", subdir, err)
+		}
+	}
+
+	entries := []archiveEntry{}
+	if prefix != "" && strings.HasSuffix(prefix, "/") {
+		entries = append(entries, archiveEntry{name: prefix, mode: filemode.Dir, blob: nil})
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("walk tree: %w", err)
+		}
+
+		switch entry.Mode {
+		case filemode.Dir:
+			entries = append(entries, archiveEntry{name: prefix + name + "/", mode: entry.Mode, blob: nil})
+		case filemode.Submodule:
+			// git archive leaves submodules as empty directories.
+			entries = append(entries, archiveEntry{name: prefix + name + "/", mode: filemode.Dir, blob: nil})
+		default:
+			blob, err := repo.BlobObject(entry.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("blob %s: %w", name, err)
+			}
+
+			entries = append(entries, archiveEntry{name: prefix + name, mode: entry.Mode, blob: blob})
+		}
+	}
+}
+
+func writeTarGz(w io.Writer, commit *object.Commit, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+
+	if err := writeTar(gz, commit, entries); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("close gzip: %w", err)
+	}
+
+	return nil
+}
+
+// writeTar writes the entries with the commit time as modification
+// time and the commit hash in a global header, as git archive does.
+func writeTar(w io.Writer, commit *object.Commit, entries []archiveEntry) error {
+	tw := tar.NewWriter(w)
+	modTime := commit.Committer.When
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{"comment": commit.Hash.String()},
+	})
+	if err != nil {
+		return fmt.Errorf("write global header: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := writeTarEntry(tw, entry, modTime); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar: %w", err)
+	}
+
+	return nil
+}
+
+func writeTarEntry(tw *tar.Writer, entry archiveEntry, modTime time.Time) error {
+	hdr := &tar.Header{Name: entry.name, Mode: 0o755, ModTime: modTime, Typeflag: tar.TypeDir}
+
+	switch entry.mode {
+	case filemode.Dir:
+		return writeTarHeader(tw, hdr)
+	case filemode.Symlink:
+		target, err := readBlob(entry.blob)
+		if err != nil {
+			return err
+		}
+
+		hdr.Typeflag, hdr.Mode, hdr.Linkname = tar.TypeSymlink, 0o777, string(target)
+
+		return writeTarHeader(tw, hdr)
+	case filemode.Executable:
+		hdr.Typeflag, hdr.Size = tar.TypeReg, entry.blob.Size
+	default:
+		hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeReg, 0o644, entry.blob.Size
+	}
+
+	if err := writeTarHeader(tw, hdr); err != nil {
+		return err
+	}
+
+	return copyBlob(tw, entry.blob)
+}
+
+func writeTarHeader(tw *tar.Writer, hdr *tar.Header) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write header %s: %w", hdr.Name, err)
+	}
+
+	return nil
+}
+
+// writeZip writes the entries with the commit time as modification
+// time and the commit hash as archive comment, as git archive does.
+func writeZip(w io.Writer, commit *object.Commit, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+
+	if err := zw.SetComment(commit.Hash.String()); err != nil {
+		return fmt.Errorf("zip comment: %w", err)
+	}
+
+	for _, entry := range entries {
+		hdr := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: commit.Committer.When}
+
+		switch entry.mode {
+		case filemode.Dir:
+			hdr.Method = zip.Store
+			hdr.SetMode(0o755 | fs.ModeDir)
+		case filemode.Symlink:
+			hdr.SetMode(0o777 | fs.ModeSymlink)
+		case filemode.Executable:
+			hdr.SetMode(0o755)
+		default:
+			hdr.SetMode(0o644)
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("write header %s: %w", entry.name, err)
+		}
+
+		if entry.blob == nil {
+			continue
+		}
+
+		if err := copyBlob(fw, entry.blob); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close zip: %w", err)
+	}
+
+	return nil
+}
+
+func readBlob(blob *object.Blob) ([]byte, error) {
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", blob.Hash, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", blob.Hash, err)
+	}
+
+	return data, nil
+}
+
+func copyBlob(w io.Writer, blob *object.Blob) error {
+	reader, err := blob.Reader()
+	if err != nil {
+		return fmt.Errorf("read blob %s: %w", blob.Hash, err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("copy blob %s: %w", blob.Hash, err)
+	}
+
+	return nil
+}
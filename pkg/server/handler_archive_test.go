@@ -0,0 +1,205 @@
+package server_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	commitFile(t, testRepo, "docs/guide.md", "guide", "add guide")
+	tagged := commitFile(t, testRepo, "docs/api/reference.md", "reference", "add reference")
+
+	_, err := testRepo.CreateTag("v1.0.0", tagged, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "bob the builder", Email: "bob@builder.test", When: time.Now()},
+		Message: "release v1.0.0",
+	})
+	require.NoError(t, err)
+
+	commitFile(t, testRepo, filename, "changed content", "change file")
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	tests := []struct {
+		name  string
+		path  string
+		files map[string]string
+	}{
+		{
+			name: "branch as tar.gz",
+			path: "/archive/master.tar.gz",
+			files: map[string]string{
+				filename:                "changed content",
+				"docs/guide.md":         "guide",
+				"docs/api/reference.md": "reference",
+			},
+		},
+		{
+			name: "annotated tag as zip",
+			path: "/archive/v1.0.0.zip",
+			files: map[string]string{
+				filename:                content,
+				"docs/guide.md":         "guide",
+				"docs/api/reference.md": "reference",
+			},
+		},
+		{
+			name:  "commit as tar",
+			path:  "/archive/" + tagged.String() + ".tar?path=docs/api",
+			files: map[string]string{"reference.md": "reference"},
+		},
+		{
+			name: "prefix and subdirectory",
+			path: "/archive/master.tar.gz?prefix=shed-1.0/&path=docs",
+			files: map[string]string{
+				"shed-1.0/guide.md":         "guide",
+				"shed-1.0/api/reference.md": "reference",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, body := archiveGet(t, srv.URL()+tc.path)
+			require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			require.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+
+			require.Equal(t, tc.files, readArchive(t, resp.Header.Get("Content-Type"), body))
+		})
+	}
+}
+
+func TestArchiveNotFound(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	for _, path := range []string{
+		"/archive/missing.tar.gz",
+		"/archive/master.rar",
+		"/archive/master.zip?path=missing",
+	} {
+		resp, _ := archiveGet(t, srv.URL()+path)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
+
+func TestArchiveAmbiguousRevision(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	prefix := ambiguousCommits(t, testRepo)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp, body := archiveGet(t, srv.URL()+"/archive/"+prefix+".tar")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, string(body), server.ErrAmbiguousRevision.Error())
+}
+
+func archiveGet(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, body
+}
+
+// readArchive returns the content of the regular files of the archive
+// by name.
+func readArchive(t *testing.T, contentType string, body []byte) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+
+	if contentType == "application/zip" {
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		require.NoError(t, err)
+
+		for _, file := range zr.File {
+			if file.Mode().IsDir() {
+				continue
+			}
+
+			rc, err := file.Open()
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+
+			files[file.Name] = string(data)
+		}
+
+		return files
+	}
+
+	var reader io.Reader = bytes.NewReader(body)
+
+	if contentType == "application/gzip" {
+		gz, err := gzip.NewReader(reader)
+		require.NoError(t, err)
+
+		reader = gz
+	}
+
+	tr := tar.NewReader(reader)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+
+		require.NoError(t, err)
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		files[hdr.Name] = string(data)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// The dumb HTTP protocol serves the repository as plain files, see
+// Dumb Clients section in
+// https://github.com/git/git/blob/master/Documentation/technical/http-protocol.txt
+const (
+	dumbHead    = "HEAD"
+	dumbObjects = "objects"
+	dumbPacks   = "objects/info/packs"
+)
+
+var (
+	looseObjectPath = regexp.MustCompile(`^objects/([0-9a-f]{2})/([0-9a-f]{38})$`)
+	packFilePath    = regexp.MustCompile(`^objects/pack/pack-[0-9a-f]{40}\.(pack|idx)$`)
+)
+
+// WithDumbProtocol enables the dumb HTTP protocol for legacy clients.
+// The files of the protocol are generated on the fly from the
+// repository storer, objects are served as loose objects.
+func WithDumbProtocol() Option {
+	return func(s *Server) {
+		s.dumbProtocol = true
+	}
+}
+
+// dumbRoutes are only routed when the dumb protocol is enabled, the
+// dumb info/refs is served by GetInfoRefs.
+func (s *Server) dumbRoutes() []route {
+	if !s.dumbProtocol {
+		return nil
+	}
+
+	return []route{
+		{path: dumbHead, method: http.MethodGet, handler: s.GetHead, prefix: false},
+		{path: dumbObjects, method: http.MethodGet, handler: s.GetObject, prefix: true},
+	}
+}
+
+// GetHead serves the HEAD file of the repository for dumb clients.
+func (s *Server) GetHead(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, dumbHead)
+	defer done()
+
+	repo, ok := s.dumbRequest(respWriter, req)
+	if !ok {
+		return
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	body := fmt.Sprintf("%s\n", head.Hash())
+	if head.Type() == plumbing.SymbolicReference {
+		body = fmt.Sprintf("ref: %s\n", head.Target())
+	}
+
+	writeDumb(respWriter, "text/plain", strings.NewReader(body))
+}
+
+// GetObject serves objects/info/packs, loose objects and pack files
+// for dumb clients.
+func (s *Server) GetObject(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, dumbObjects)
+	defer done()
+
+	repo, ok := s.dumbRequest(respWriter, req)
+	if !ok {
+		return
+	}
+
+	_, rest, _ := splitRepoPath(req.URL.Path)
+
+	switch {
+	case rest == dumbPacks:
+		packs, err := packsFile(repo.Storer)
+		if err != nil {
+			internalErr(respWriter, err)
+
+			return
+		}
+
+		writeDumb(respWriter, "text/plain; charset=utf-8", packs)
+	case looseObjectPath.MatchString(rest):
+		m := looseObjectPath.FindStringSubmatch(rest)
+
+		obj, err := looseObject(repo.Storer, plumbing.NewHash(m[1]+m[2]))
+		if err != nil {
+			http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		writeDumb(respWriter, "application/x-git-loose-object", obj)
+	case packFilePath.MatchString(rest):
+		s.getPackFile(respWriter, repo, rest)
+	default:
+		http.NotFound(respWriter, req)
+	}
+}
+
+func (s *Server) dumbRequest(respWriter http.ResponseWriter, req *http.Request) (*git.Repository, bool) {
+	if req.Method != http.MethodGet {
+		http.Error(respWriter, "invalid method", http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	if !s.dumbProtocol {
+		http.NotFound(respWriter, req)
+
+		return nil, false
+	}
+
+	return s.readRequest(respWriter, req)
+}
+
+func (s *Server) getDumbInfoRefs(respWriter http.ResponseWriter, repo *git.Repository) {
+	refs, err := dumbInfoRefs(repo)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	writeDumb(respWriter, "text/plain; charset=utf-8", refs)
+}
+
+func (s *Server) getPackFile(respWriter http.ResponseWriter, repo *git.Repository, name string) {
+	fsStorer, ok := repo.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		http.Error(respWriter, "no pack files", http.StatusNotFound)
+
+		return
+	}
+
+	file, err := fsStorer.Filesystem().Open(name)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	defer file.Close()
+
+	contentType := "application/x-git-packed-objects"
+	if path.Ext(name) == ".idx" {
+		contentType = "application/x-git-packed-objects-toc"
+	}
+
+	writeDumb(respWriter, contentType, file)
+}
+
+func writeDumb(respWriter http.ResponseWriter, contentType string, body io.Reader) {
+	respWriter.Header().Add("Content-Type", contentType)
+	respWriter.Header().Add("Cache-Control", "no-cache")
+	respWriter.WriteHeader(http.StatusOK)
+
+	_, _ = io.Copy(respWriter, body)
+}
+
+// dumbInfoRefs generates the info/refs file as written by git
+// update-server-info, annotated tags are followed by their peeled
+// object.
+func dumbInfoRefs(repo *git.Repository) (io.Reader, error) {
+	refs, err := sortedReferences(repo.Storer)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			continue
+		}
+
+		resolved, err := storer.ResolveReference(repo.Storer, ref.Name())
+		if err != nil {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s\t%s\n", resolved.Hash(), ref.Name())
+
+		if peeled, ok := peelTag(repo, resolved.Hash()); ok {
+			fmt.Fprintf(&buf, "%s\t%s^{}\n", peeled, ref.Name())
+		}
+	}
+
+	return &buf, nil
+}
+
+// packsFile generates objects/info/packs listing the packs of the
+// storer, storers without packs result in an empty file.
+func packsFile(st storer.Storer) (io.Reader, error) {
+	var buf bytes.Buffer
+
+	packed, ok := st.(storer.PackedObjectStorer)
+	if !ok {
+		return &buf, nil
+	}
+
+	packs, err := packed.ObjectPacks()
+	if err != nil {
+		return nil, fmt.Errorf("object packs: %w", err)
+	}
+
+	for _, pack := range packs {
+		fmt.Fprintf(&buf, "P pack-%s.pack\n", pack)
+	}
+
+	buf.WriteString("\n")
+
+	return &buf, nil
+}
+
+// looseObject returns the zlib compressed loose representation of the
+// object, regardless of how the storer keeps it.
+func looseObject(st storer.EncodedObjectStorer, hash plumbing.Hash) (io.Reader, error) {
+	obj, err := st.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+
+	reader, err := obj.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("object reader: %w", err)
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+
+	writer := objfile.NewWriter(&buf)
+
+	if err := writer.WriteHeader(obj.Type(), obj.Size()); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return nil, fmt.Errorf("write object: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close object: %w", err)
+	}
+
+	return &buf, nil
+}
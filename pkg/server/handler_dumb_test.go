@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func getDumb(t *testing.T, url string) *http.Response {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestDumbInfoRefsAndObjects(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithDumbProtocol())
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := getDumb(t, fmt.Sprintf("%s/info/refs", srv.URL()))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%s\trefs/heads/master\n", head.Hash()), string(body))
+
+	resp = getDumb(t, fmt.Sprintf("%s/HEAD", srv.URL()))
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ref: refs/heads/master\n", string(body))
+
+	hash := head.Hash().String()
+	resp = getDumb(t, fmt.Sprintf("%s/objects/%s/%s", srv.URL(), hash[:2], hash[2:]))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	reader, err := objfile.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	objType, _, err := reader.Header()
+	require.NoError(t, err)
+	require.Equal(t, plumbing.CommitObject, objType)
+
+	resp = getDumb(t, fmt.Sprintf("%s/objects/%s/%s", srv.URL(), "00", hash[2:]))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDumbProtocolIsOptIn(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := getDumb(t, fmt.Sprintf("%s/info/refs", srv.URL()))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = getDumb(t, fmt.Sprintf("%s/HEAD", srv.URL()))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestCloneWithGitCLIDumbProtocol(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName, server.WithDumbProtocol())
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+
+	cmd := gitCommand(t, dir, "", "clone", srv.URL(), ".")
+	cmd.Env = append(cmd.Env, "GIT_SMART_HTTP=0")
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	actual, err := os.ReadFile(filepath.Join(dir, filename))
+	require.NoError(t, err)
+	require.Equal(t, content, string(actual))
+}
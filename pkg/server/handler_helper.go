@@ -1,8 +1,12 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
+
+	"github.com/go-git/go-git/v5"
 )
 
 var ErrInvalidContentType = fmt.Errorf("invalid content type")
@@ -15,6 +19,56 @@ func validateContentType(r *http.Request, service string) error {
 	return nil
 }
 
+// readRequest authenticates the request and authorizes read access to
+// the repository it addresses, it responds with the error otherwise.
+func (s *Server) readRequest(respWriter http.ResponseWriter, req *http.Request) (*git.Repository, bool) {
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return nil, false
+	}
+
+	repoPath, repo, err := s.repository(req)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return nil, false
+	}
+
+	if !s.authorize(respWriter, req, repoPath, AccessRead) {
+		return nil, false
+	}
+
+	return repo, true
+}
+
 func internalErr(w http.ResponseWriter, err error) {
 	http.Error(w, fmt.Sprintf("internal error: %s", err), http.StatusInternalServerError)
 }
+
+// writeResultHeader writes the successful response header of a
+// git-upload-pack or git-receive-pack request.
+func writeResultHeader(respWriter http.ResponseWriter, service string) {
+	respWriter.Header().Add("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+	respWriter.Header().Add("Cache-Control", "no-cache")
+	respWriter.WriteHeader(http.StatusOK)
+}
+
+// contextWriter fails writes once the context is done, it bounds the
+// time spent streaming a response by the session timeout.
+type contextWriter struct {
+	ctx context.Context //nolint:containedctx
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("write: %w", err)
+	}
+
+	return n, nil
+}
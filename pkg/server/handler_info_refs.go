@@ -1,24 +1,36 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 func (s *Server) GetInfoRefs(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, infoRefs)
+	defer done()
+
 	if req.Method != http.MethodGet {
 		http.Error(respWriter, "invalid method", http.StatusBadRequest)
 
 		return
 	}
 
-	if err := s.authenticate(req.BasicAuth()); err != nil {
-		http.Error(respWriter, "invalid auth", http.StatusUnauthorized)
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return
+	}
+
+	repoPath, repo, err := s.repository(req)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
 
 		return
 	}
@@ -26,6 +38,16 @@ func (s *Server) GetInfoRefs(respWriter http.ResponseWriter, req *http.Request)
 	// see Smart Clients section in
 	// https://github.com/git/git/blob/master/Documentation/technical/http-protocol.txt
 	vals := req.URL.Query()
+	if len(vals) == 0 && s.dumbProtocol {
+		if !s.authorize(respWriter, req, repoPath, AccessRead) {
+			return
+		}
+
+		s.getDumbInfoRefs(respWriter, repo)
+
+		return
+	}
+
 	if len(vals) != 1 {
 		http.Error(respWriter, "too many query parameters", http.StatusBadRequest)
 
@@ -44,18 +66,42 @@ func (s *Server) GetInfoRefs(respWriter http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	advRefs, err := buildsAdvertisedRefs(s.repo)
+	if !s.authorize(respWriter, req, repoPath, serviceAccess(name)) {
+		return
+	}
+
+	if name == transport.UploadPackServiceName && isProtocolV2(req) {
+		respWriter.Header().Add("Content-Type", fmt.Sprintf("application/x-%s-advertisement", name))
+		respWriter.Header().Add("Cache-Control", "no-cache")
+		respWriter.WriteHeader(http.StatusOK)
+
+		if err := writeV2Advertisement(respWriter); err != nil {
+			internalErr(respWriter, err)
+		}
+
+		return
+	}
+
+	_, advertise := s.startSpan(req.Context(), spanAdvertise)
+	advRefs, err := buildsAdvertisedRefs(repo, name)
+	advertise.End()
+
 	if err != nil {
 		internalErr(respWriter, err)
 
 		return
 	}
 
-	respWriter.Header().Add("Content-Type", fmt.Sprintf("application/x-%s-advertisement", transport.UploadPackServiceName))
+	setRequestAttributes(req.Context(), attrRefs.Int(len(advRefs.References)))
+
+	respWriter.Header().Add("Content-Type", fmt.Sprintf("application/x-%s-advertisement", name))
 	respWriter.Header().Add("Cache-Control", "no-cache")
 	respWriter.WriteHeader(http.StatusOK)
 
+	_, encode := s.startSpan(req.Context(), spanEncode)
 	err = advRefs.Encode(respWriter)
+	encode.End()
+
 	if err != nil {
 		internalErr(respWriter, err)
 
@@ -63,9 +109,15 @@ func (s *Server) GetInfoRefs(respWriter http.ResponseWriter, req *http.Request)
 	}
 }
 
-func buildsAdvertisedRefs(repo *git.Repository) (*packp.AdvRefs, error) {
-	// can we not use vendor/github.com/go-git/go-git/v5/plumbing/transport/server/server.go somehow?
+// buildsAdvertisedRefs builds the reference advertisement of the
+// service, prefixed by the smart service header.
+func buildsAdvertisedRefs(repo *git.Repository, service string) (*packp.AdvRefs, error) {
 	advRefs := packp.NewAdvRefs()
+	advRefs.Prefix = [][]byte{[]byte(fmt.Sprintf("# service=%s", service)), pktline.Flush}
+
+	if err := setCapabilities(advRefs.Capabilities, service); err != nil {
+		return nil, fmt.Errorf("capabilities: %w", err)
+	}
 
 	iter, err := repo.References()
 	if err != nil {
@@ -79,19 +131,89 @@ func buildsAdvertisedRefs(repo *git.Repository) (*packp.AdvRefs, error) {
 
 		advRefs.References[ref.Name().String()] = ref.Hash()
 
+		if peeled, ok := peelTag(repo, ref.Hash()); ok {
+			advRefs.Peeled[ref.Name().String()] = peeled
+		}
+
 		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("iter foreach: %w", err)
 	}
 
-	ref, err := repo.Reference(plumbing.HEAD, true)
+	head, err := repo.Storer.Reference(plumbing.HEAD)
 	if err != nil {
 		return nil, fmt.Errorf("head reference: %w", err)
 	}
 
+	ref, err := repo.Reference(plumbing.HEAD, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		// unborn HEAD of an empty repository
+		return advRefs, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("resolve head reference: %w", err)
+	}
+
 	h := ref.Hash()
 	advRefs.Head = &h
 
+	if service == transport.UploadPackServiceName && head.Type() == plumbing.SymbolicReference {
+		err := advRefs.Capabilities.Add(capability.SymRef, fmt.Sprintf("%s:%s", plumbing.HEAD, head.Target()))
+		if err != nil {
+			return nil, fmt.Errorf("symref: %w", err)
+		}
+	}
+
 	return advRefs, nil
 }
+
+// setCapabilities sets the capabilities supported by the service.
+func setCapabilities(caps *capability.List, service string) error {
+	supported := []capability.Capability{capability.OFSDelta}
+
+	switch service {
+	case transport.UploadPackServiceName:
+		supported = append(supported,
+			capability.MultiACKDetailed,
+			capability.Sideband64k,
+			capability.Sideband,
+			capability.Shallow,
+			capability.DeepenSince,
+			capability.DeepenNot,
+			capability.DeepenRelative,
+			capability.NoProgress,
+			capability.Filter,
+			// needed by partial clones to lazily fetch objects
+			capability.AllowReachableSHA1InWant,
+		)
+	case transport.ReceivePackServiceName:
+		supported = append(supported,
+			capability.ReportStatus,
+			capability.DeleteRefs,
+			capability.Atomic,
+			capability.PushOptions,
+			capability.Sideband64k,
+			capability.Sideband,
+			capability.Quiet,
+		)
+	}
+
+	for _, c := range supported {
+		if err := caps.Set(c); err != nil {
+			return fmt.Errorf("set %s: %w", c, err)
+		}
+	}
+
+	return caps.Set(capability.Agent, capability.DefaultAgent())
+}
+
+// serviceAccess returns the access a service requires.
+func serviceAccess(service string) Access {
+	if service == transport.ReceivePackServiceName {
+		return AccessWrite
+	}
+
+	return AccessRead
+}
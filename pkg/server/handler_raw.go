@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The raw endpoint serves the content of a file at a revision as
+// raw/<rev>/<path>.
+const rawPath = "raw"
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+var ErrInvalidRawPath = fmt.Errorf("invalid raw path")
+
+// GetRaw serves the content of a file at a commit, branch or tag. The
+// content type is guessed from the file extension or the content, and
+// the blob hash is used as ETag so clients can revalidate cheaply. The
+// content is streamed, only range requests read it into memory.
+// Directories, missing files and ambiguous short hashes result in 404
+// Not Found.
+func (s *Server) GetRaw(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, rawPath)
+	defer done()
+
+	repo, ok := s.readRequest(respWriter, req)
+	if !ok {
+		return
+	}
+
+	_, rest, _ := splitRepoPath(req.URL.Path)
+
+	commit, filePath, err := resolveRawPath(repo, strings.TrimPrefix(rest, rawPath+"/"))
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	entry, err := tree.FindEntry(filePath)
+	if err != nil || entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule {
+		http.Error(respWriter, fmt.Sprintf("%s: file not found", filePath), http.StatusNotFound)
+
+		return
+	}
+
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	etag := fmt.Sprintf("%q", blob.Hash.String())
+
+	respWriter.Header().Set("ETag", etag)
+	respWriter.Header().Set("Cache-Control", "no-cache")
+
+	if req.Header.Get("Range") != "" {
+		serveRawRange(respWriter, req, blob, filePath)
+
+		return
+	}
+
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		respWriter.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	serveRaw(respWriter, req, blob, filePath)
+}
+
+// serveRaw streams the blob.
+func serveRaw(respWriter http.ResponseWriter, req *http.Request, blob *object.Blob, filePath string) {
+	reader, err := blob.Reader()
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+	defer reader.Close()
+
+	contentType, body, err := rawContentType(reader, filePath)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	respWriter.Header().Set("Content-Type", contentType)
+	respWriter.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	respWriter.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		_, _ = io.Copy(respWriter, body)
+	}
+}
+
+// rawContentType guesses the content type of the file like
+// ServeContent does, by extension and otherwise by sniffing the start
+// of the content. The returned reader yields the whole content.
+func rawContentType(r io.Reader, filePath string) (string, io.Reader, error) {
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType, r, nil
+	}
+
+	sniffed := make([]byte, sniffLen)
+
+	n, err := io.ReadFull(r, sniffed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", nil, fmt.Errorf("sniff content: %w", err)
+	}
+
+	return http.DetectContentType(sniffed[:n]), io.MultiReader(bytes.NewReader(sniffed[:n]), r), nil
+}
+
+// serveRawRange answers a range request for the blob, which is read
+// into memory as ServeContent needs to seek in it.
+func serveRawRange(respWriter http.ResponseWriter, req *http.Request, blob *object.Blob, filePath string) {
+	data, err := readBlob(blob)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	http.ServeContent(respWriter, req, path.Base(filePath), time.Time{}, bytes.NewReader(data))
+}
+
+// etagMatches reports whether the If-None-Match header lists etag,
+// weak entity tags match as well.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveRawPath splits <rev>/<path> into the commit of the revision
+// and the file path. Revisions may contain slashes, the shortest
+// leading segments naming a revision are used.
+func resolveRawPath(repo *git.Repository, raw string) (*object.Commit, string, error) {
+	segments := strings.Split(raw, "/")
+
+	for i := 1; i < len(segments); i++ {
+		filePath := strings.Join(segments[i:], "/")
+		if filePath == "" {
+			break
+		}
+
+		commit, err := resolveCommit(repo, strings.Join(segments[:i], "/"))
+		if err == nil {
+			return commit, filePath, nil
+		}
+
+		if errors.Is(err, ErrAmbiguousRevision) {
+			return nil, "", err
+		}
+	}
+
+	return nil, "", fmt.Errorf("%s: %w", raw, ErrInvalidRawPath)
+}
@@ -0,0 +1,237 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaw(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	first := commitFile(t, testRepo, "config/app.json", `{"name":"shed"}`, "add config")
+	commitFile(t, testRepo, "config/app.json", `{"name":"tools"}`, "change config")
+	commitFile(t, testRepo, "docs/index.html", "<html><body>docs</body></html>", "add docs")
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	require.NoError(t, testRepo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/feature/config", first)))
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	tests := []struct {
+		name        string
+		path        string
+		content     string
+		contentType string
+	}{
+		{
+			name:        "branch",
+			path:        "/raw/master/config/app.json",
+			content:     `{"name":"tools"}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "branch with slash",
+			path:        "/raw/feature/config/config/app.json",
+			content:     `{"name":"shed"}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "commit",
+			path:        "/raw/" + first.String() + "/config/app.json",
+			content:     `{"name":"shed"}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "content type by content",
+			path:        "/raw/master/" + filename,
+			content:     content,
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:        "content type by extension",
+			path:        "/raw/" + head.Hash().String() + "/docs/index.html",
+			content:     "<html><body>docs</body></html>",
+			contentType: "text/html; charset=utf-8",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, body := rawGet(t, srv.URL()+tc.path, "")
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			require.Equal(t, tc.content, body)
+			require.Equal(t, tc.contentType, resp.Header.Get("Content-Type"))
+			require.NotEmpty(t, resp.Header.Get("ETag"))
+
+			resp, _ = rawGet(t, srv.URL()+tc.path, resp.Header.Get("ETag"))
+			require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		})
+	}
+}
+
+func TestRawETagFollowsBlob(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	url := srv.URL() + "/raw/master/" + filename
+
+	resp, _ := rawGet(t, url, "")
+	etag := resp.Header.Get("ETag")
+
+	// an unrelated commit keeps the blob and so the ETag.
+	commitFile(t, testRepo, "other", "other content", "add other")
+
+	resp, _ = rawGet(t, url, etag)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	commitFile(t, testRepo, filename, "changed content", "change file")
+
+	resp, body := rawGet(t, url, etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "changed content", body)
+	require.NotEqual(t, etag, resp.Header.Get("ETag"))
+}
+
+func TestRawNotFound(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	commitFile(t, testRepo, "config/app.json", `{"name":"shed"}`, "add config")
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	for _, path := range []string{
+		"/raw/master/missing.yaml",
+		"/raw/master/config",
+		"/raw/missing/config/app.json",
+		"/raw/master",
+	} {
+		resp, _ := rawGet(t, srv.URL()+path, "")
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
+
+func TestRawRange(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/raw/master/"+filename, nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Range", "bytes=1-3")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, content[1:4], string(body))
+}
+
+func TestRawAmbiguousRevision(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+	prefix := ambiguousCommits(t, testRepo)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp, body := rawGet(t, srv.URL()+"/raw/"+prefix+"/"+filename, "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, server.ErrAmbiguousRevision.Error())
+}
+
+func TestRawRoutes(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.New(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+
+	ginEngine := gin.New()
+	srv.SetupGinRoutes(ginEngine)
+
+	for name, handler := range map[string]http.Handler{"ServeMux": mux, "Gin": ginEngine} {
+		handler := handler
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(handler)
+			t.Cleanup(ts.Close)
+
+			resp, body := rawGet(t, fmt.Sprintf("%s/%s/raw/master/%s", ts.URL, srv.RepoPath(), filename), "")
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, content, body)
+		})
+	}
+}
+
+func rawGet(t *testing.T, url, etag string) (*http.Response, string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
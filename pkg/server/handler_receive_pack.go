@@ -3,59 +3,128 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
 func (s *Server) GetReceivePack(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, receivePack)
+	defer done()
+
 	if req.Method != http.MethodPost {
 		http.Error(respWriter, "invalid method", http.StatusBadRequest)
 
 		return
 	}
 
-	if err := s.authenticate(req.BasicAuth()); err != nil {
-		http.Error(respWriter, "invalid auth", http.StatusUnauthorized)
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return
+	}
+
+	repoPath, repo, err := s.repository(req)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
 
 		return
 	}
 
+	if !s.authorize(respWriter, req, repoPath, AccessWrite) {
+		return
+	}
+
 	if err := validateContentType(req, transport.ReceivePackServiceName); err != nil {
 		http.Error(respWriter, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
-	refReq := packp.NewReferenceUpdateRequest()
+	_, decode := s.startSpan(req.Context(), spanDecode)
+	refReq, err := decodeReceivePackRequest(req.Body)
+	decode.End()
 
-	err := refReq.Capabilities.Add(capability.ReportStatus)
 	if err != nil {
-		internalErr(respWriter, err)
-	}
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
 
-	err = refReq.Decode(req.Body)
-	if err != nil {
-		internalErr(respWriter, err)
+		return
 	}
 
+	setRequestAttributes(req.Context(), attrCommands.Int(len(refReq.Commands)))
+
 	ctx, cancel := context.WithTimeout(req.Context(), s.SessionTimeout)
 	defer cancel()
 
-	resp, err := s.rpSession.ReceivePack(ctx, refReq)
-	if err != nil {
-		internalErr(respWriter, err)
+	packIn := &countingReader{r: nil, read: 0}
+	if refReq.Packfile != nil {
+		packIn.r = ioutil.NewContextReadCloser(ctx, refReq.Packfile)
+		refReq.Packfile = packIn
 	}
 
-	respWriter.Header().Add("Content-Type",
-		fmt.Sprintf("application/x-%s-advertisement", transport.ReceivePackServiceName))
-	respWriter.Header().Add("Cache-Control", "no-cache")
-	respWriter.WriteHeader(http.StatusOK)
+	// the result header is written up front so progress messages of
+	// the hooks reach the client while the push is processed.
+	writeResultHeader(respWriter, transport.ReceivePackServiceName)
 
-	err = resp.Encode(respWriter)
-	if err != nil {
-		internalErr(respWriter, err)
+	// without report-status the client does not read the response, so
+	// there is no point in multiplexing it.
+	reporting := refReq.Capabilities.Supports(capability.ReportStatus)
+
+	out, mux := io.Writer(respWriter), (*sideband.Muxer)(nil)
+	if reporting {
+		out, mux = sidebandWriter(respWriter, refReq.Capabilities)
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+	push := &Push{
+		Principal:  principal,
+		RepoPath:   repoPath,
+		Repository: repo,
+		Commands:   refReq.Commands,
+		Options:    refReq.Options,
+		Progress:   newProgressWriter(mux, true),
+	}
+
+	statuses, unpackErr := s.receivePack(ctx, push, refReq)
+	setRequestAttributes(ctx, attrPackSize.Int64(packIn.read))
+	failPush(ctx, refReq.Commands, statuses, unpackErr)
+
+	event := newPushEvent(push, refReq, statuses, unpackErr)
+	s.pushes.record(event)
+	s.webhooks.notify(repo, event)
+
+	if !reporting {
+		return
+	}
+
+	_, encode := s.startSpan(ctx, spanEncode)
+	if err := reportStatus(refReq.Commands, statuses, unpackErr).Encode(out); err != nil {
+		observe(ctx).fail(fmt.Errorf("report status: %w", err))
+		writeFatal(mux, err)
+	}
+
+	encode.End()
+
+	if mux != nil {
+		_ = pktline.NewEncoder(respWriter).Flush()
+	}
+}
+
+// failPush records the failure to unpack and the rejected commands of
+// the push, which are only reported to the client in the response.
+func failPush(ctx context.Context, cmds []*packp.Command, statuses map[*packp.Command]error, unpackErr error) {
+	if unpackErr != nil {
+		observe(ctx).fail(fmt.Errorf("unpack: %w", unpackErr))
+	}
+
+	for _, cmd := range cmds {
+		if err := statuses[cmd]; err != nil {
+			observe(ctx).fail(fmt.Errorf("%s: %w", cmd.Name, err))
+		}
 	}
 }
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+var noAuth = server.BasicAuth{Username: "", Password: ""}
+
+func TestPushHTTP(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	hash := commitFile(t, local, filename, "pushed content", "second commit")
+
+	err = push(local, noAuth, "refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/feature")
+	require.NoError(t, err)
+
+	for _, name := range []plumbing.ReferenceName{"refs/heads/master", "refs/heads/feature"} {
+		ref, err := testRepo.Reference(name, false)
+		require.NoError(t, err)
+		require.Equal(t, hash, ref.Hash())
+	}
+
+	err = push(local, noAuth, ":refs/heads/feature")
+	require.NoError(t, err)
+
+	_, err = testRepo.Reference("refs/heads/feature", false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	a := newCloneAssert(t, srv.URL())
+	a.assert(filename, "pushed content")
+}
+
+func TestAtomicPushRejectsAllCommands(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	refReq := packp.NewReferenceUpdateRequest()
+	require.NoError(t, refReq.Capabilities.Set(capability.ReportStatus))
+	require.NoError(t, refReq.Capabilities.Set(capability.Atomic))
+
+	refReq.Commands = []*packp.Command{
+		{Name: "refs/heads/new", Old: plumbing.ZeroHash, New: head.Hash()},
+		{Name: "refs/heads/master", Old: plumbing.NewHash("0123456789012345678901234567890123456789"), New: head.Hash()},
+	}
+
+	report := postReceivePack(t, srv.URL(), refReq)
+	require.Equal(t, "ok", report.UnpackStatus)
+	require.Len(t, report.CommandStatuses, 2)
+	require.Equal(t, server.ErrAtomicFailed.Error(), report.CommandStatuses[0].Status)
+	require.Equal(t, server.ErrStaleRef.Error(), report.CommandStatuses[1].Status)
+
+	_, err = testRepo.Reference("refs/heads/new", false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestCloneAndPushWithGitCLI(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+	runGit(t, dir, "0", "clone", srv.URL(), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, filename), []byte("cli content"), 0o600))
+	runGit(t, dir, "0", "commit", "-am", "cli commit")
+	runGit(t, dir, "0", "push", "origin", "master", "master:refs/heads/cli")
+
+	a := newCloneAssert(t, srv.URL())
+	a.assert(filename, "cli content")
+
+	_, err = testRepo.Reference("refs/heads/cli", false)
+	require.NoError(t, err)
+}
+
+func postReceivePack(t *testing.T, url string, refReq *packp.ReferenceUpdateRequest) *packp.ReportStatus {
+	t.Helper()
+
+	var body bytes.Buffer
+	require.NoError(t, refReq.Encode(&body))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/git-receive-pack", url), &body)
+	require.NoError(t, err)
+
+	req.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	report := packp.NewReportStatus()
+	require.NoError(t, report.Decode(resp.Body))
+
+	return report
+}
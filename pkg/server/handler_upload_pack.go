@@ -1,75 +1,307 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 func (s *Server) GetUploadPack(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, uploadPack)
+	defer done()
+
 	if req.Method != http.MethodPost {
 		http.Error(respWriter, "invalid method", http.StatusBadRequest)
 
 		return
 	}
 
-	if err := s.authenticate(req.BasicAuth()); err != nil {
-		http.Error(respWriter, "invalid auth", http.StatusUnauthorized)
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return
+	}
+
+	repoPath, repo, err := s.repository(req)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
 
 		return
 	}
 
+	if !s.authorize(respWriter, req, repoPath, AccessRead) {
+		return
+	}
+
 	if err := validateContentType(req, transport.UploadPackServiceName); err != nil {
 		http.Error(respWriter, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
-	packReq := &packp.UploadPackRequest{
-		UploadRequest: packp.UploadRequest{
-			Capabilities: &capability.List{},
-			Wants:        []plumbing.Hash{},
-			Shallows:     []plumbing.Hash{},
-			Depth:        nil,
-		},
-		UploadHaves: packp.UploadHaves{
-			Haves: []plumbing.Hash{},
-		},
+	if isProtocolV2(req) {
+		s.serveUploadPackV2(respWriter, req, repo)
+
+		return
+	}
+
+	_, decode := s.startSpan(req.Context(), spanDecode)
+	upReq, err := decodeUploadPackRequest(req.Body)
+	decode.End()
+
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	setRequestAttributes(req.Context(), attrWants.Int(len(upReq.Wants)), attrHaves.Int(len(upReq.haves)))
+
+	for _, want := range upReq.Wants {
+		if err := repo.Storer.HasEncodedObject(want); err != nil {
+			http.Error(respWriter, fmt.Sprintf("%s: %s", ErrUnknownObject, want), http.StatusBadRequest)
+
+			return
+		}
 	}
 
-	err := packReq.Decode(req.Body)
+	filter, err := parseFilter(repo, upReq.filter)
 	if err != nil {
-		internalErr(respWriter, err)
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
 	}
 
-	// otherwise validate will fail
-	if packReq.Capabilities == nil {
-		packReq.Capabilities = capability.NewList()
+	spec := &packSpec{
+		wants:          upReq.Wants,
+		haves:          upReq.haves,
+		filter:         filter,
+		clientShallows: upReq.Shallows,
+		shallows:       nil,
 	}
 
-	if packReq.Depth == nil {
-		packReq.Depth = packp.DepthCommits(0)
+	relative := upReq.Capabilities.Supports(capability.DeepenRelative)
+
+	_, negotiate := s.startSpan(req.Context(), spanNegotiate)
+	shallowUpdate, err := deepen(repo, spec, newDeepenRequest(upReq.UploadRequest, relative))
+	common := commonObjects(repo.Storer, upReq.haves)
+	negotiate.End()
+
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var objs []plumbing.Hash
+
+	if upReq.done {
+		_, pack := s.startSpan(req.Context(), spanPack)
+		objs, err = objectsToPack(repo.Storer, spec)
+		pack.SetAttributes(attrPackObjects.Int(len(objs)))
+		pack.End()
+
+		if err != nil {
+			internalErr(respWriter, err)
+
+			return
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(req.Context(), s.SessionTimeout)
 	defer cancel()
 
-	resp, err := s.upSession.UploadPack(ctx, packReq)
-	if err != nil {
-		internalErr(respWriter, err)
+	writer := &contextWriter{ctx: ctx, w: respWriter}
+
+	writeResultHeader(respWriter, transport.UploadPackServiceName)
+
+	if shallowUpdate != nil {
+		if err := shallowUpdate.Encode(writer); err != nil {
+			return
+		}
 	}
 
-	respWriter.Header().Add("Content-Type", fmt.Sprintf("application/x-%s-result", transport.UploadPackServiceName))
-	respWriter.Header().Add("Cache-Control", "no-cache")
-	respWriter.WriteHeader(http.StatusOK)
+	if !upReq.flushed && !upReq.done {
+		return
+	}
 
-	err = resp.Encode(respWriter)
+	ready := readyToPack(repo.Storer, upReq.Wants, common)
+
+	err = writeNegotiation(writer, upReq.Capabilities, common, ready, upReq.done)
+	if err != nil || !upReq.done {
+		return
+	}
+
+	packOut, mux := sidebandWriter(writer, upReq.Capabilities)
+	progress := newProgressWriter(mux, !upReq.Capabilities.Supports(capability.NoProgress))
+
+	refDeltas := !upReq.Capabilities.Supports(capability.OFSDelta)
+
+	s.sendPack(req.Context(), packOut, mux, progress, repo.Storer, objs, refDeltas)
+
+	if mux != nil {
+		_ = pktline.NewEncoder(writer).Flush()
+	}
+}
+
+// uploadPackRequest is a stateless protocol version 0 and 1
+// git-upload-pack request, the haves of the negotiation follow the
+// upload request.
+type uploadPackRequest struct {
+	*packp.UploadRequest
+
+	filter string
+	haves  []plumbing.Hash
+	// flushed is set once a flush packet ended a round of haves, a
+	// request without haves section is not acknowledged, like the
+	// first request of a shallow fetch.
+	flushed bool
+	done    bool
+}
+
+func decodeUploadPackRequest(r io.Reader) (*uploadPackRequest, error) {
+	upReqSection, filter, err := extractFilter(r)
 	if err != nil {
-		internalErr(respWriter, err)
+		return nil, err
+	}
+
+	upReq := packp.NewUploadRequest()
+	if err := upReq.Decode(upReqSection); err != nil {
+		return nil, fmt.Errorf("decode upload request: %w", err)
+	}
+
+	req := &uploadPackRequest{
+		UploadRequest: upReq,
+		filter:        filter,
+		haves:         []plumbing.Hash{},
+		flushed:       false,
+		done:          false,
+	}
+
+	for {
+		kind, payload, err := readPktLine(r)
+		if errors.Is(err, io.EOF) {
+			return req, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if kind == pktFlush {
+			req.flushed = true
+		}
+
+		if kind != pktData {
+			continue
+		}
+
+		line := string(payload)
+
+		switch {
+		case strings.HasPrefix(line, "have "):
+			req.haves = append(req.haves, plumbing.NewHash(strings.TrimPrefix(line, "have ")))
+		case line == "done":
+			req.done = true
+
+			return req, nil
+		default:
+			return nil, fmt.Errorf("%q: %w", line, ErrInvalidArgument)
+		}
+	}
+}
+
+// extractFilter reads the upload request up to its flush packet and
+// takes out the filter line, which the upload request decoder does not
+// know.
+func extractFilter(r io.Reader) (io.Reader, string, error) {
+	var (
+		section bytes.Buffer
+		filter  string
+	)
+
+	enc := pktline.NewEncoder(&section)
+
+	for {
+		kind, payload, err := readPktLine(r)
+		if err != nil {
+			return nil, "", err
+		}
+
+		line := string(payload)
+
+		switch {
+		case kind == pktFlush:
+			if err := enc.Flush(); err != nil {
+				return nil, "", fmt.Errorf("encode flush: %w", err)
+			}
+
+			return &section, filter, nil
+		case kind == pktData && strings.HasPrefix(line, "filter "):
+			filter = strings.TrimPrefix(line, "filter ")
+		case kind == pktData:
+			if err := enc.EncodeString(line + "\n"); err != nil {
+				return nil, "", fmt.Errorf("encode %q: %w", line, err)
+			}
+		default:
+			return nil, "", fmt.Errorf("upload request: %w", ErrInvalidPktLine)
+		}
+	}
+}
+
+// writeNegotiation acknowledges the common objects in the way the
+// client asked for, following git-upload-pack in stateless mode. With
+// multi_ack_detailed the client is told once the common objects are
+// enough to pack against. The packfile follows once the client is done.
+func writeNegotiation(w io.Writer, caps *capability.List, common []plumbing.Hash, ready, done bool) error {
+	enc := pktline.NewEncoder(w)
+	detailed := caps.Supports(capability.MultiACKDetailed)
+	multiAck := detailed || caps.Supports(capability.MultiACK)
+
+	for i, hash := range common {
+		var err error
+
+		switch {
+		case detailed:
+			err = enc.Encodef("ACK %s common\n", hash)
+		case multiAck:
+			err = enc.Encodef("ACK %s continue\n", hash)
+		case i == 0:
+			err = enc.Encodef("ACK %s\n", hash)
+		}
+
+		if err != nil {
+			return fmt.Errorf("encode ACK: %w", err)
+		}
+	}
+
+	last := len(common) - 1
+
+	switch {
+	case !done && detailed && ready:
+		if err := enc.Encodef("ACK %s ready\n", common[last]); err != nil {
+			return fmt.Errorf("encode ACK ready: %w", err)
+		}
+
+		return enc.EncodeString("NAK\n")
+	case !done && (multiAck || last < 0):
+		return enc.EncodeString("NAK\n")
+	case !done:
+		return nil
+	case last < 0:
+		return enc.EncodeString("NAK\n")
+	case multiAck:
+		return enc.Encodef("ACK %s\n", common[last])
+	default:
+		return nil
 	}
 }
@@ -2,13 +2,17 @@ package server_test
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"os/exec"
 	"testing"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -46,33 +50,164 @@ func repoWithInitCommit(t *testing.T, name, content string) *git.Repository {
 	repo, err := git.Init(memory.NewStorage(), memfs.New())
 	require.NoError(t, err)
 
+	commitFile(t, repo, name, content, "initial commit")
+
+	return repo
+}
+
+// commitFile writes content to name in the worktree of repo and
+// commits it, it returns the hash of the commit.
+func commitFile(t *testing.T, repo *git.Repository, name, content, msg string) plumbing.Hash {
+	t.Helper()
+
+	return commitFiles(t, repo, map[string]string{name: content}, msg, time.Now())
+}
+
+// commitFiles writes the files, keyed by their path, to the worktree of
+// repo and commits them at when, it returns the hash of the commit.
+func commitFiles(
+	t *testing.T, repo *git.Repository, files map[string]string, msg string, when time.Time,
+) plumbing.Hash {
+	t.Helper()
+
 	worktree, err := repo.Worktree()
 	require.NoError(t, err)
 
 	fs := worktree.Filesystem
-	file, err := fs.Create(name)
-	require.NoError(t, err)
 
-	_, err = file.Write([]byte(content))
+	for name, content := range files {
+		file, err := fs.Create(name)
+		require.NoError(t, err)
+
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+
+		err = file.Close()
+		require.NoError(t, err)
+	}
+
+	err = worktree.AddGlob("*")
 	require.NoError(t, err)
 
-	err = file.Close()
+	signature := &object.Signature{
+		Name:  "bob the builder",
+		Email: "bob@builder.test",
+		When:  when,
+	}
+
+	hash, err := worktree.Commit(msg, &git.CommitOptions{
+		All:       true,
+		Author:    signature,
+		Committer: signature,
+	})
 	require.NoError(t, err)
 
-	err = worktree.AddGlob("*")
+	return hash
+}
+
+// gitCommand returns the command running the git binary with args in
+// dir, speaking the protocol version unless it is empty. The test is
+// skipped without a git binary.
+func gitCommand(t *testing.T, dir, version string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git binary not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	opts := []string{"-c", "user.name=bob", "-c", "user.email=bob@builder.test"}
+	if version != "" {
+		opts = append(opts, "-c", "protocol.version="+version)
+	}
+
+	cmd := exec.CommandContext(ctx, gitBin, append(opts, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	return cmd
+}
+
+// runGit runs the git command of gitCommand and returns its output, it
+// fails the test if git does.
+func runGit(t *testing.T, dir, version string, args ...string) string {
+	t.Helper()
+
+	out, err := gitCommand(t, dir, version, args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return string(out)
+}
+
+// cloneRepository clones the repository at url into memory.
+func cloneRepository(t *testing.T, url string, auth server.BasicAuth) *git.Repository {
+	t.Helper()
+
+	opts := &git.CloneOptions{
+		Auth: &http.BasicAuth{
+			Username: auth.Username,
+			Password: auth.Password,
+		},
+		URL:        url,
+		RemoteName: "origin",
+	}
+
+	repo, err := git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), opts)
 	require.NoError(t, err)
 
-	_, err = worktree.Commit("initial commit", &git.CommitOptions{
-		All: true,
-		Author: &object.Signature{
-			Name:  "bob the builder",
-			Email: "bob@builder.test",
-			When:  time.Now(),
+	return repo
+}
+
+// push pushes the refspecs of repo to origin.
+func push(repo *git.Repository, auth server.BasicAuth, refSpecs ...config.RefSpec) error {
+	return repo.PushContext(context.Background(), &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecs,
+		Auth: &http.BasicAuth{
+			Username: auth.Username,
+			Password: auth.Password,
 		},
 	})
+}
+
+// ambiguousCommits adds commits to repo until two of them share the
+// first four digits of their hash, it returns those digits.
+func ambiguousCommits(t *testing.T, repo *git.Repository) string {
+	t.Helper()
+
+	head, err := repo.Head()
 	require.NoError(t, err)
 
-	return repo
+	parent, err := repo.CommitObject(head.Hash())
+	require.NoError(t, err)
+
+	seen := map[string]bool{}
+
+	for i := 0; ; i++ {
+		commit := &object.Commit{ //nolint:exhaustivestruct
+			Author:       parent.Author,
+			Committer:    parent.Committer,
+			Message:      fmt.Sprintf("commit %d", i),
+			TreeHash:     parent.TreeHash,
+			ParentHashes: []plumbing.Hash{parent.Hash},
+		}
+
+		obj := repo.Storer.NewEncodedObject()
+		require.NoError(t, commit.Encode(obj))
+
+		hash, err := repo.Storer.SetEncodedObject(obj)
+		require.NoError(t, err)
+
+		prefix := hash.String()[:4]
+		if seen[prefix] {
+			return prefix
+		}
+
+		seen[prefix] = true
+	}
 }
 
 type cloneAssert struct {
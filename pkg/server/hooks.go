@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+)
+
+// Push describes a push received by git-receive-pack, as passed to
+// the Hooks.
+type Push struct {
+	// Principal is the identity the push was authenticated as.
+	Principal Principal
+	// RepoPath is the path of the repository, e.g. owner/name.git.
+	RepoPath string
+	// Repository is the repository pushed to, it holds the received
+	// objects when the hooks run.
+	Repository *git.Repository
+	// Commands are the reference updates of the push.
+	Commands []*packp.Command
+	// Options are the push options sent by the client.
+	Options []*packp.Option
+	// Progress sends messages to the pushing client, which displays
+	// them prefixed with remote:. As with git, quiet clients still
+	// receive these messages, they are only discarded if the client did
+	// not negotiate side-band.
+	Progress io.Writer
+}
+
+// Hooks are invoked while receiving a push, mirroring the server side
+// hooks of git, see githooks(5). The errors returned are reported to
+// the client as the reason the reference update was rejected. Any of
+// the hooks may be nil.
+//
+// Unlike git, received objects are not quarantined, they stay in the
+// repository even if the push is rejected.
+type Hooks struct {
+	// PreReceive is invoked once with every command which passed
+	// validation, an error rejects the whole push.
+	PreReceive func(ctx context.Context, push *Push) error
+	// Update is invoked for every command which passed PreReceive, an
+	// error rejects the command only.
+	Update func(ctx context.Context, push *Push, cmd *packp.Command) error
+	// PostReceive is invoked after the references were updated, with
+	// the commands which were applied successfully.
+	PostReceive func(ctx context.Context, push *Push)
+}
+
+// WithHooks adds hooks invoked on every push, hooks added by multiple
+// options are invoked in the order they were added.
+func WithHooks(hooks Hooks) Option {
+	return func(s *Server) {
+		s.hooks = append(s.hooks, hooks)
+	}
+}
+
+func (s *Server) preReceive(ctx context.Context, push *Push) error {
+	for _, hooks := range s.hooks {
+		if hooks.PreReceive == nil {
+			continue
+		}
+
+		if err := hooks.PreReceive(ctx, push); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) update(ctx context.Context, push *Push, cmd *packp.Command) error {
+	for _, hooks := range s.hooks {
+		if hooks.Update == nil {
+			continue
+		}
+
+		if err := hooks.Update(ctx, push, cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) postReceive(ctx context.Context, push *Push) {
+	for _, hooks := range s.hooks {
+		if hooks.PostReceive != nil {
+			hooks.PostReceive(ctx, push)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+var errDeclined = fmt.Errorf("declined by CI gate")
+
+func TestPreReceiveHookRejectsPush(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithHooks(server.Hooks{
+		PreReceive: func(ctx context.Context, push *server.Push) error {
+			return errDeclined
+		},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	err = push(local, noAuth, "refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/feature")
+	require.ErrorContains(t, err, errDeclined.Error())
+
+	ref, err := testRepo.Reference("refs/heads/master", false)
+	require.NoError(t, err)
+	require.Equal(t, head.Hash(), ref.Hash())
+
+	_, err = testRepo.Reference("refs/heads/feature", false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestUpdateHookRejectsSingleReference(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	var (
+		mu       sync.Mutex
+		received *server.Push
+	)
+
+	auth := server.BasicAuth{Username: "bob", Password: "builder"}
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName,
+		server.WithBasicAuth(auth),
+		server.WithHooks(server.Hooks{
+			Update: func(ctx context.Context, push *server.Push, cmd *packp.Command) error {
+				if cmd.Name == "refs/heads/feature" {
+					return errDeclined
+				}
+
+				return nil
+			},
+			PostReceive: func(ctx context.Context, push *server.Push) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				received = push
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), auth)
+	hash := commitFile(t, local, filename, "pushed content", "second commit")
+
+	err = local.PushContext(context.Background(), &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/feature"},
+		Auth:       &githttp.BasicAuth{Username: auth.Username, Password: auth.Password},
+		Options:    map[string]string{"ci.skip": "true"},
+	})
+	require.ErrorContains(t, err, errDeclined.Error())
+
+	ref, err := testRepo.Reference("refs/heads/master", false)
+	require.NoError(t, err)
+	require.Equal(t, hash, ref.Hash())
+
+	_, err = testRepo.Reference("refs/heads/feature", false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.NotNil(t, received)
+	require.Equal(t, "bob", received.Principal.Name)
+	require.Equal(t, srv.Server.RepoPath(), received.RepoPath)
+	require.Len(t, received.Commands, 1)
+	require.Equal(t, plumbing.ReferenceName("refs/heads/master"), received.Commands[0].Name)
+	require.Equal(t, []*packp.Option{{Key: "ci.skip", Value: "true"}}, received.Options)
+}
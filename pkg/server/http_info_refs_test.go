@@ -8,6 +8,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/sata-form3/go-git-http-backend/pkg/server"
 	"github.com/stretchr/testify/require"
@@ -41,6 +44,8 @@ func TestInfoRefsWhenIncorrect(t *testing.T) {
 	}
 
 	for name, test := range tests {
+		test := test
+
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
@@ -70,3 +75,64 @@ func TestInfoRefsWhenIncorrect(t *testing.T) {
 		})
 	}
 }
+
+//nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
+func TestInfoRefsServiceAdvertisement(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		service string
+		caps    []capability.Capability
+	}{
+		"upload-pack": {
+			service: transport.UploadPackServiceName,
+			caps: []capability.Capability{
+				capability.MultiACKDetailed, capability.Sideband64k, capability.Shallow,
+				capability.SymRef, capability.OFSDelta,
+			},
+		},
+		"receive-pack": {
+			service: transport.ReceivePackServiceName,
+			caps: []capability.Capability{
+				capability.ReportStatus, capability.DeleteRefs, capability.OFSDelta,
+				capability.Atomic, capability.PushOptions,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+				fmt.Sprintf("%s/info/refs?service=%s", srv.URL(), test.service), nil)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, fmt.Sprintf("application/x-%s-advertisement", test.service), resp.Header.Get("Content-Type"))
+
+			advRefs := packp.NewAdvRefs()
+			require.NoError(t, advRefs.Decode(resp.Body))
+			require.Equal(t, [][]byte{[]byte("# service=" + test.service), pktline.Flush}, advRefs.Prefix)
+
+			for _, c := range test.caps {
+				require.True(t, advRefs.Capabilities.Supports(c), "capability %s", c)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"fmt"
-	"net/http"
 	"net/http/httptest"
 
 	"github.com/go-git/go-git/v5"
@@ -11,6 +10,9 @@ import (
 type HTTPTestServer struct {
 	Server *Server
 	TS     *httptest.Server
+
+	faults  *faultInjector
+	initial *Snapshot
 }
 
 // NewHTTPTest initialises a new Git Server as well as a HTTP test
@@ -21,17 +23,43 @@ func NewHTTPTest(repo *git.Repository, owner, repoName string, opts ...Option) (
 		return nil, err
 	}
 
-	mux := http.NewServeMux()
-	server.SetupRoutes(mux)
+	return newHTTPTest(server)
+}
+
+// NewHTTPTestWithRegistry initialises a new Git Server serving every
+// repository of the registry as well as a HTTP test server which is
+// started. Repositories added to the registry later on are served as
+// well.
+func NewHTTPTestWithRegistry(registry *Registry, opts ...Option) (*HTTPTestServer, error) {
+	server, err := NewWithRegistry(registry, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPTest(server)
+}
 
-	ts := httptest.NewServer(mux)
+func newHTTPTest(server *Server) (*HTTPTestServer, error) {
+	faults := newFaultInjector(server)
 
-	h := &HTTPTestServer{
+	srv := &HTTPTestServer{
 		Server: server,
-		TS:     ts,
+		TS:     nil,
+
+		faults:  faults,
+		initial: nil,
+	}
+
+	// the state at construction is kept for Reset.
+	initial, err := srv.Snapshot()
+	if err != nil {
+		return nil, err
 	}
 
-	return h, nil
+	srv.initial = initial
+	srv.TS = httptest.NewServer(faults)
+
+	return srv, nil
 }
 
 // URL returns the full path to the repository, it is the $GIT_URL
@@ -41,6 +69,27 @@ func (h *HTTPTestServer) URL() string {
 	return fmt.Sprintf("%s/%s", h.TS.URL, h.Server.RepoPath())
 }
 
+// RepoURL returns the full path to the repository of owner and
+// repoName, it is meant for servers hosting many repositories.
+func (h *HTTPTestServer) RepoURL(owner, repoName string) string {
+	return fmt.Sprintf("%s/%s", h.TS.URL, RepoPath(owner, repoName))
+}
+
+// Pushes returns the history of pushes received by the server.
+func (h *HTTPTestServer) Pushes() *PushRecorder {
+	return h.Server.Pushes()
+}
+
+// WebhookDeliveries returns the webhook delivery log of the server.
+func (h *HTTPTestServer) WebhookDeliveries() []WebhookDelivery {
+	return h.Server.WebhookDeliveries()
+}
+
+// Stop closes the HTTP test server and the Server, webhook deliveries
+// in progress are canceled and waited for.
 func (h *HTTPTestServer) Stop() {
+	// canceled first, requests delivering synchronously return early.
+	h.Server.webhooks.cancel()
 	h.TS.Close()
+	h.Server.Close()
 }
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// instrument starts the observation of a request to the service, i.e.
+// its span, metrics and log record, the returned function ends it once
+// the handler is done. The request context carries the span and the
+// observation.
+func (s *Server) instrument(
+	respWriter http.ResponseWriter, req *http.Request, service string,
+) (http.ResponseWriter, *http.Request, func()) {
+	repo := s.requestRepo(req)
+
+	ctx, span := s.startRequestSpan(req, repo, service)
+
+	obs := &observation{
+		start:           time.Now(),
+		objectsSent:     0,
+		objectsReceived: 0,
+		refsUpdated:     0,
+		authFailed:      0,
+		principal:       "",
+		mu:              sync.Mutex{},
+		failures:        []error{},
+	}
+	writer := &statusWriter{ResponseWriter: respWriter, status: 0, written: 0, body: bytes.Buffer{}}
+	body := &countingReader{r: req.Body, read: 0}
+
+	req = req.WithContext(context.WithValue(ctx, observationKey{}, obs))
+	if req.Body != nil {
+		req.Body = body
+	}
+
+	return writer, req, func() {
+		status := writer.responseStatus()
+		read := atomic.LoadInt64(&body.read)
+
+		endRequestSpan(span, status)
+
+		if s.metrics != nil {
+			s.metrics.record(repo, service, obs, status, writer.written, read)
+		}
+
+		s.logRequest(req, repo, service, obs, writer, read)
+	}
+}
+
+// requestRepo returns the path of the served repository the request is
+// for, or unknownRepo.
+func (s *Server) requestRepo(req *http.Request) string {
+	repoPath, _, ok := splitRepoPath(req.URL.Path)
+	if !ok {
+		repoPath, _, ok = splitGitHubPath(req.URL.Path)
+	}
+
+	if !ok {
+		return unknownRepo
+	}
+
+	if _, err := s.registry.Lookup(repoPath); err != nil {
+		return unknownRepo
+	}
+
+	return repoPath
+}
+
+type observationKey struct{}
+
+// observation collects the counts and failures of a request which only
+// the handler knows about, it is carried by the request context.
+type observation struct {
+	start           time.Time
+	objectsSent     int64
+	objectsReceived int64
+	refsUpdated     int64
+	authFailed      int64
+	principal       string
+
+	mu       sync.Mutex
+	failures []error
+}
+
+// observe returns the observation of the request context, it is nil
+// outside of an instrumented handler and every method is a no-op then.
+func observe(ctx context.Context) *observation {
+	obs, _ := ctx.Value(observationKey{}).(*observation)
+
+	return obs
+}
+
+func (o *observation) sentObjects(n int) {
+	if o != nil {
+		atomic.AddInt64(&o.objectsSent, int64(n))
+	}
+}
+
+func (o *observation) receivedObjects(n int) {
+	if o != nil {
+		atomic.AddInt64(&o.objectsReceived, int64(n))
+	}
+}
+
+func (o *observation) updatedRefs(n int) {
+	if o != nil {
+		atomic.AddInt64(&o.refsUpdated, int64(n))
+	}
+}
+
+// failedAuth counts the authentication or authorization failure, err
+// is logged as its cause.
+func (o *observation) failedAuth(err error) {
+	if o != nil {
+		atomic.AddInt64(&o.authFailed, 1)
+		o.fail(err)
+	}
+}
+
+func (o *observation) authenticated(principal Principal) {
+	if o != nil {
+		o.principal = principal.Name
+	}
+}
+
+// fail records the cause of a failure, notably of those which happen
+// after the response status was sent.
+func (o *observation) fail(err error) {
+	if o == nil || err == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.failures = append(o.failures, err)
+}
+
+// statusWriter records the status code and the number of bytes of the
+// response, and the start of the body of error responses.
+type statusWriter struct {
+	http.ResponseWriter
+
+	status  int
+	written int64
+	body    bytes.Buffer
+}
+
+// responseStatus returns the status code sent, which is 200 OK if the
+// handler did not write anything.
+func (w *statusWriter) responseStatus() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxLoggedBody {
+		w.body.Write(p[:min(n, maxLoggedBody-w.body.Len())])
+	}
+
+	return n, err //nolint:wrapcheck
+}
+
+// Flush implements http.Flusher if the wrapped writer does.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	r    io.ReadCloser
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+
+	return n, err //nolint:wrapcheck
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close() //nolint:wrapcheck
+}
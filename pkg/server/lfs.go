@@ -0,0 +1,414 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The Git LFS server implements the batch API, the basic transfer
+// adapter and the locks API, see
+// https://github.com/git-lfs/git-lfs/tree/main/docs/api
+const (
+	lfsPath      = "info/lfs"
+	lfsMediaType = "application/vnd.git-lfs+json"
+
+	lfsOperationDownload = "download"
+	lfsOperationUpload   = "upload"
+	lfsTransferBasic     = "basic"
+	lfsHashAlgo          = "sha256"
+)
+
+var (
+	ErrLFSHashMismatch = fmt.Errorf("lfs object content does not match oid")
+
+	lfsObjectPath = regexp.MustCompile(`^objects/([0-9a-f]{64})$`)
+	lfsUnlockPath = regexp.MustCompile(`^locks/([^/]+)/unlock$`)
+	lfsOid        = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// WithLFS mounts a Git LFS server under <RepoPath>/info/lfs, storing
+// the objects in storage. Locks are kept in memory. The endpoints are
+// authenticated and authorized like the git endpoints, downloads need
+// read access while uploads and locks need write access.
+func WithLFS(storage LFSStorage) Option {
+	return func(s *Server) {
+		s.lfs = &lfsServer{storage: storage, locks: newLFSLocks()}
+	}
+}
+
+type lfsServer struct {
+	storage LFSStorage
+	locks   *lfsLocks
+}
+
+// lfsRoutes are only routed when LFS is enabled, the methods are
+// checked by ServeLFS.
+func (s *Server) lfsRoutes() []route {
+	if s.lfs == nil {
+		return nil
+	}
+
+	return []route{
+		{path: lfsPath, method: "", handler: s.ServeLFS, prefix: true},
+	}
+}
+
+type lfsObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsRef struct {
+	Name string `json:"name"`
+}
+
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers,omitempty"`
+	Ref       *lfsRef     `json:"ref,omitempty"`
+	Objects   []lfsObject `json:"objects"`
+	HashAlgo  string      `json:"hash_algo,omitempty"`
+}
+
+type lfsBatchResponse struct {
+	Transfer string              `json:"transfer"`
+	Objects  []lfsObjectResponse `json:"objects"`
+	HashAlgo string              `json:"hash_algo"`
+}
+
+type lfsObjectResponse struct {
+	lfsObject
+
+	Authenticated bool                 `json:"authenticated,omitempty"`
+	Actions       map[string]lfsAction `json:"actions,omitempty"`
+	Error         *lfsObjectError      `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// ServeLFS serves the Git LFS endpoints below <RepoPath>/info/lfs.
+func (s *Server) ServeLFS(respWriter http.ResponseWriter, req *http.Request) {
+	respWriter, req, done := s.instrument(respWriter, req, lfsPath)
+	defer done()
+
+	if s.lfs == nil {
+		http.NotFound(respWriter, req)
+
+		return
+	}
+
+	_, rest, _ := splitRepoPath(req.URL.Path)
+	rest = strings.TrimPrefix(rest, lfsPath+"/")
+
+	switch {
+	case req.Method == http.MethodPost && rest == "objects/batch":
+		s.lfsBatch(respWriter, req)
+	case req.Method == http.MethodGet && lfsObjectPath.MatchString(rest):
+		s.lfsDownload(respWriter, req, lfsObjectPath.FindStringSubmatch(rest)[1])
+	case req.Method == http.MethodPut && lfsObjectPath.MatchString(rest):
+		s.lfsUpload(respWriter, req, lfsObjectPath.FindStringSubmatch(rest)[1])
+	case req.Method == http.MethodPost && rest == "verify":
+		s.lfsVerify(respWriter, req)
+	case req.Method == http.MethodGet && rest == "locks":
+		s.lfsListLocks(respWriter, req)
+	case req.Method == http.MethodPost && rest == "locks":
+		s.lfsCreateLock(respWriter, req)
+	case req.Method == http.MethodPost && rest == "locks/verify":
+		s.lfsVerifyLocks(respWriter, req)
+	case req.Method == http.MethodPost && lfsUnlockPath.MatchString(rest):
+		s.lfsUnlock(respWriter, req, lfsUnlockPath.FindStringSubmatch(rest)[1])
+	default:
+		writeLFSError(respWriter, http.StatusNotFound, "not found")
+	}
+}
+
+// lfsRequest authenticates and authorizes the request for the
+// repository, it responds with the error otherwise.
+func (s *Server) lfsRequest(
+	respWriter http.ResponseWriter, req *http.Request, access Access,
+) (*http.Request, string, bool) {
+	req, ok := s.authenticate(respWriter, req)
+	if !ok {
+		return nil, "", false
+	}
+
+	repoPath, _, err := s.repository(req)
+	if err != nil {
+		writeLFSError(respWriter, http.StatusNotFound, err.Error())
+
+		return nil, "", false
+	}
+
+	if !s.authorize(respWriter, req, repoPath, access) {
+		return nil, "", false
+	}
+
+	return req, repoPath, true
+}
+
+func (s *Server) lfsBatch(respWriter http.ResponseWriter, req *http.Request) {
+	req, repoPath, ok := s.lfsRequest(respWriter, req, AccessRead)
+	if !ok {
+		return
+	}
+
+	var batch lfsBatchRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, err.Error())
+
+		return
+	}
+
+	switch batch.Operation {
+	case lfsOperationDownload:
+	case lfsOperationUpload:
+		if !s.authorize(respWriter, req, repoPath, AccessWrite) {
+			return
+		}
+	default:
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, fmt.Sprintf("unknown operation %q", batch.Operation))
+
+		return
+	}
+
+	if batch.HashAlgo != "" && batch.HashAlgo != lfsHashAlgo {
+		writeLFSError(respWriter, http.StatusConflict, fmt.Sprintf("unsupported hash algorithm %q", batch.HashAlgo))
+
+		return
+	}
+
+	if !supportsBasicTransfer(batch.Transfers) {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "only the basic transfer is supported")
+
+		return
+	}
+
+	resp := lfsBatchResponse{
+		Transfer: lfsTransferBasic,
+		Objects:  make([]lfsObjectResponse, 0, len(batch.Objects)),
+		HashAlgo: lfsHashAlgo,
+	}
+
+	for _, obj := range batch.Objects {
+		resp.Objects = append(resp.Objects, s.lfsBatchObject(req, repoPath, batch.Operation, obj))
+	}
+
+	writeLFSJSON(respWriter, http.StatusOK, resp)
+}
+
+func supportsBasicTransfer(transfers []string) bool {
+	if len(transfers) == 0 {
+		return true
+	}
+
+	for _, transfer := range transfers {
+		if transfer == lfsTransferBasic {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lfsBatchObject returns the actions of a single object of a batch
+// request.
+func (s *Server) lfsBatchObject(req *http.Request, repoPath, operation string, obj lfsObject) lfsObjectResponse {
+	resp := lfsObjectResponse{lfsObject: obj, Authenticated: true, Actions: nil, Error: nil}
+
+	if !lfsOid.MatchString(obj.Oid) || obj.Size < 0 {
+		resp.Error = &lfsObjectError{Code: http.StatusUnprocessableEntity, Message: "invalid object"}
+
+		return resp
+	}
+
+	size, err := s.lfs.storage.Size(repoPath, obj.Oid)
+	exists := err == nil && size == obj.Size
+
+	if err != nil && !errors.Is(err, ErrLFSObjectNotFound) {
+		resp.Error = &lfsObjectError{Code: http.StatusInternalServerError, Message: err.Error()}
+
+		return resp
+	}
+
+	base := lfsBaseURL(req)
+	header := map[string]string{}
+
+	// the actions are authenticated like the batch request itself.
+	if authz := req.Header.Get("Authorization"); authz != "" {
+		header["Authorization"] = authz
+	}
+
+	switch {
+	case operation == lfsOperationDownload && !exists:
+		resp.Error = &lfsObjectError{Code: http.StatusNotFound, Message: "object does not exist"}
+	case operation == lfsOperationDownload:
+		resp.Actions = map[string]lfsAction{
+			"download": {Href: base + "/objects/" + obj.Oid, Header: header},
+		}
+	case !exists:
+		resp.Actions = map[string]lfsAction{
+			"upload": {Href: base + "/objects/" + obj.Oid, Header: header},
+			"verify": {Href: base + "/verify", Header: header},
+		}
+	}
+
+	return resp
+}
+
+// lfsBaseURL returns the URL of the LFS server the request was sent
+// to, which may be mounted under a prefix.
+func lfsBaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	urlPath := req.URL.Path
+	if i := strings.Index(urlPath, "/"+lfsPath+"/"); i >= 0 {
+		urlPath = urlPath[:i+len(lfsPath)+1]
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, req.Host, urlPath)
+}
+
+func (s *Server) lfsDownload(respWriter http.ResponseWriter, req *http.Request, oid string) {
+	_, repoPath, ok := s.lfsRequest(respWriter, req, AccessRead)
+	if !ok {
+		return
+	}
+
+	size, err := s.lfs.storage.Size(repoPath, oid)
+	if err != nil {
+		writeLFSStorageError(respWriter, err)
+
+		return
+	}
+
+	content, err := s.lfs.storage.Open(repoPath, oid)
+	if err != nil {
+		writeLFSStorageError(respWriter, err)
+
+		return
+	}
+	defer content.Close()
+
+	respWriter.Header().Set("Content-Type", "application/octet-stream")
+	respWriter.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	respWriter.WriteHeader(http.StatusOK)
+
+	_, _ = io.Copy(respWriter, content)
+}
+
+func (s *Server) lfsUpload(respWriter http.ResponseWriter, req *http.Request, oid string) {
+	_, repoPath, ok := s.lfsRequest(respWriter, req, AccessWrite)
+	if !ok {
+		return
+	}
+
+	content := &oidVerifier{r: req.Body, hash: sha256.New(), oid: oid}
+
+	if err := s.lfs.storage.Store(repoPath, oid, content); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrLFSHashMismatch) {
+			status = http.StatusUnprocessableEntity
+		}
+
+		writeLFSError(respWriter, status, err.Error())
+
+		return
+	}
+
+	respWriter.WriteHeader(http.StatusOK)
+}
+
+// oidVerifier fails the last read if the content read does not match
+// the oid.
+type oidVerifier struct {
+	r    io.Reader
+	hash hash.Hash
+	oid  string
+}
+
+func (v *oidVerifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) && hex.EncodeToString(v.hash.Sum(nil)) != v.oid {
+		return n, ErrLFSHashMismatch
+	}
+
+	return n, err //nolint:wrapcheck // io.EOF must not be wrapped
+}
+
+func (s *Server) lfsVerify(respWriter http.ResponseWriter, req *http.Request) {
+	_, repoPath, ok := s.lfsRequest(respWriter, req, AccessWrite)
+	if !ok {
+		return
+	}
+
+	var obj lfsObject
+
+	if err := json.NewDecoder(req.Body).Decode(&obj); err != nil || !lfsOid.MatchString(obj.Oid) {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "invalid object")
+
+		return
+	}
+
+	size, err := s.lfs.storage.Size(repoPath, obj.Oid)
+	if err != nil {
+		writeLFSStorageError(respWriter, err)
+
+		return
+	}
+
+	if size != obj.Size {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, fmt.Sprintf("object size is %d, not %d", size, obj.Size))
+
+		return
+	}
+
+	writeLFSJSON(respWriter, http.StatusOK, obj)
+}
+
+func writeLFSStorageError(respWriter http.ResponseWriter, err error) {
+	if errors.Is(err, ErrLFSObjectNotFound) {
+		writeLFSError(respWriter, http.StatusNotFound, err.Error())
+
+		return
+	}
+
+	writeLFSError(respWriter, http.StatusInternalServerError, err.Error())
+}
+
+func writeLFSError(respWriter http.ResponseWriter, status int, message string) {
+	writeLFSJSON(respWriter, status, lfsErrorResponse{Message: message})
+}
+
+func writeLFSJSON(respWriter http.ResponseWriter, status int, body interface{}) {
+	respWriter.Header().Set("Content-Type", lfsMediaType)
+	respWriter.WriteHeader(status)
+
+	_ = json.NewEncoder(respWriter).Encode(body)
+}
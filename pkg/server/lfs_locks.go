@@ -0,0 +1,271 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lfsDefaultLockLimit is the number of locks listed per page if the
+// client does not ask for a limit.
+const lfsDefaultLockLimit = 100
+
+type lfsLockOwner struct {
+	Name string `json:"name"`
+}
+
+type lfsLock struct {
+	ID       string       `json:"id"`
+	Path     string       `json:"path"`
+	LockedAt time.Time    `json:"locked_at"`
+	Owner    lfsLockOwner `json:"owner"`
+}
+
+type lfsCreateLockRequest struct {
+	Path string  `json:"path"`
+	Ref  *lfsRef `json:"ref,omitempty"`
+}
+
+type lfsVerifyLocksRequest struct {
+	Cursor string  `json:"cursor,omitempty"`
+	Limit  int     `json:"limit,omitempty"`
+	Ref    *lfsRef `json:"ref,omitempty"`
+}
+
+type lfsUnlockRequest struct {
+	Force bool    `json:"force,omitempty"`
+	Ref   *lfsRef `json:"ref,omitempty"`
+}
+
+type lfsLockResponse struct {
+	Lock    *lfsLock `json:"lock"`
+	Message string   `json:"message,omitempty"`
+}
+
+type lfsListLocksResponse struct {
+	Locks      []*lfsLock `json:"locks"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type lfsVerifyLocksResponse struct {
+	Ours       []*lfsLock `json:"ours"`
+	Theirs     []*lfsLock `json:"theirs"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// lfsLocks holds the file locks of every repository, the locks of a
+// repository are ordered by their increasing id.
+type lfsLocks struct {
+	mu     sync.Mutex
+	nextID int
+	locks  map[string][]*lfsLock
+}
+
+func newLFSLocks() *lfsLocks {
+	return &lfsLocks{
+		mu:     sync.Mutex{},
+		nextID: 1,
+		locks:  map[string][]*lfsLock{},
+	}
+}
+
+// create locks path for owner, it returns the existing lock if path is
+// locked already.
+func (l *lfsLocks) create(repoPath, path, owner string) (*lfsLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, lock := range l.locks[repoPath] {
+		if lock.Path == path {
+			return lock, false
+		}
+	}
+
+	lock := &lfsLock{
+		ID:       strconv.Itoa(l.nextID),
+		Path:     path,
+		LockedAt: time.Now().UTC().Truncate(time.Second),
+		Owner:    lfsLockOwner{Name: owner},
+	}
+
+	l.nextID++
+	l.locks[repoPath] = append(l.locks[repoPath], lock)
+
+	return lock, true
+}
+
+// list returns the locks of the repository matching the filter,
+// starting at the lock with the cursor id.
+func (l *lfsLocks) list(repoPath, cursor string, match func(*lfsLock) bool) []*lfsLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks := []*lfsLock{}
+	started := cursor == ""
+
+	for _, lock := range l.locks[repoPath] {
+		started = started || lock.ID == cursor
+
+		if started && match(lock) {
+			locks = append(locks, lock)
+		}
+	}
+
+	return locks
+}
+
+// remove removes the lock with id, only its owner may remove it
+// unless forced. The lock is nil if there is no lock with id.
+func (l *lfsLocks) remove(repoPath, id, owner string, force bool) (*lfsLock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locks := l.locks[repoPath]
+
+	for i, lock := range locks {
+		if lock.ID != id {
+			continue
+		}
+
+		if lock.Owner.Name != owner && !force {
+			return lock, false
+		}
+
+		l.locks[repoPath] = append(locks[:i:i], locks[i+1:]...)
+
+		return lock, true
+	}
+
+	return nil, false
+}
+
+// paginate splits the locks into a page of limit locks and the cursor
+// of the next page.
+func paginate(locks []*lfsLock, limit int) ([]*lfsLock, string) {
+	if limit <= 0 {
+		limit = lfsDefaultLockLimit
+	}
+
+	if len(locks) <= limit {
+		return locks, ""
+	}
+
+	return locks[:limit], locks[limit].ID
+}
+
+func (s *Server) lfsCreateLock(respWriter http.ResponseWriter, req *http.Request) {
+	req, repoPath, ok := s.lfsRequest(respWriter, req, AccessWrite)
+	if !ok {
+		return
+	}
+
+	var create lfsCreateLockRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&create); err != nil || create.Path == "" {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "invalid lock request")
+
+		return
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+
+	lock, created := s.lfs.locks.create(repoPath, create.Path, principal.Name)
+	if !created {
+		writeLFSJSON(respWriter, http.StatusConflict, lfsLockResponse{Lock: lock, Message: "already created lock"})
+
+		return
+	}
+
+	writeLFSJSON(respWriter, http.StatusCreated, lfsLockResponse{Lock: lock, Message: ""})
+}
+
+func (s *Server) lfsListLocks(respWriter http.ResponseWriter, req *http.Request) {
+	req, repoPath, ok := s.lfsRequest(respWriter, req, AccessRead)
+	if !ok {
+		return
+	}
+
+	query := req.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil && query.Get("limit") != "" {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "invalid limit")
+
+		return
+	}
+
+	locks := s.lfs.locks.list(repoPath, query.Get("cursor"), func(lock *lfsLock) bool {
+		return (query.Get("path") == "" || lock.Path == query.Get("path")) &&
+			(query.Get("id") == "" || lock.ID == query.Get("id"))
+	})
+
+	page, next := paginate(locks, limit)
+
+	writeLFSJSON(respWriter, http.StatusOK, lfsListLocksResponse{Locks: page, NextCursor: next})
+}
+
+func (s *Server) lfsVerifyLocks(respWriter http.ResponseWriter, req *http.Request) {
+	req, repoPath, ok := s.lfsRequest(respWriter, req, AccessWrite)
+	if !ok {
+		return
+	}
+
+	var verify lfsVerifyLocksRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&verify); err != nil {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "invalid verify request")
+
+		return
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+
+	locks := s.lfs.locks.list(repoPath, verify.Cursor, func(*lfsLock) bool { return true })
+	page, next := paginate(locks, verify.Limit)
+
+	resp := lfsVerifyLocksResponse{Ours: []*lfsLock{}, Theirs: []*lfsLock{}, NextCursor: next}
+
+	for _, lock := range page {
+		if lock.Owner.Name == principal.Name {
+			resp.Ours = append(resp.Ours, lock)
+		} else {
+			resp.Theirs = append(resp.Theirs, lock)
+		}
+	}
+
+	writeLFSJSON(respWriter, http.StatusOK, resp)
+}
+
+func (s *Server) lfsUnlock(respWriter http.ResponseWriter, req *http.Request, id string) {
+	req, repoPath, ok := s.lfsRequest(respWriter, req, AccessWrite)
+	if !ok {
+		return
+	}
+
+	var unlock lfsUnlockRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&unlock); err != nil {
+		writeLFSError(respWriter, http.StatusUnprocessableEntity, "invalid unlock request")
+
+		return
+	}
+
+	principal, _ := PrincipalFromContext(req.Context())
+
+	lock, removed := s.lfs.locks.remove(repoPath, id, principal.Name, unlock.Force)
+
+	switch {
+	case lock == nil:
+		writeLFSError(respWriter, http.StatusNotFound, "lock not found")
+
+		return
+	case !removed:
+		writeLFSJSON(respWriter, http.StatusForbidden, lfsLockResponse{Lock: lock, Message: "lock is owned by another user"})
+
+		return
+	}
+
+	writeLFSJSON(respWriter, http.StatusOK, lfsLockResponse{Lock: lock, Message: ""})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrLFSObjectNotFound = fmt.Errorf("lfs object not found")
+
+// LFSStorage stores the Git LFS objects of the served repositories,
+// keyed by the repository path and the SHA-256 oid of their content.
+// Oids are validated before they are passed to the storage.
+type LFSStorage interface {
+	// Size returns the size of the object, or ErrLFSObjectNotFound.
+	Size(repoPath, oid string) (int64, error)
+	// Open returns the content of the object, or ErrLFSObjectNotFound.
+	Open(repoPath, oid string) (io.ReadCloser, error)
+	// Store stores the content read from r. Nothing is stored if
+	// reading fails, which is how content not matching the oid is
+	// rejected.
+	Store(repoPath, oid string, r io.Reader) error
+}
+
+// MemoryLFSStorage keeps the Git LFS objects in memory, it is safe for
+// concurrent use.
+type MemoryLFSStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemoryLFSStorage returns an empty in-memory Git LFS storage.
+func NewMemoryLFSStorage() *MemoryLFSStorage {
+	return &MemoryLFSStorage{
+		mu:      sync.RWMutex{},
+		objects: map[string][]byte{},
+	}
+}
+
+func (m *MemoryLFSStorage) Size(repoPath, oid string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.objects[lfsKey(repoPath, oid)]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", oid, ErrLFSObjectNotFound)
+	}
+
+	return int64(len(data)), nil
+}
+
+func (m *MemoryLFSStorage) Open(repoPath, oid string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, ok := m.objects[lfsKey(repoPath, oid)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", oid, ErrLFSObjectNotFound)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemoryLFSStorage) Store(repoPath, oid string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", oid, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[lfsKey(repoPath, oid)] = data
+
+	return nil
+}
+
+func lfsKey(repoPath, oid string) string {
+	return repoPath + "/" + oid
+}
+
+// FileLFSStorage keeps the Git LFS objects on disk below a directory,
+// in the layout used by git-lfs:
+// <repoPath>/<oid[0:2]>/<oid[2:4]>/<oid>.
+type FileLFSStorage struct {
+	dir string
+}
+
+// NewFileLFSStorage returns a Git LFS storage below dir, which is
+// created on demand.
+func NewFileLFSStorage(dir string) *FileLFSStorage {
+	return &FileLFSStorage{dir: dir}
+}
+
+func (f *FileLFSStorage) path(repoPath, oid string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(repoPath), oid[0:2], oid[2:4], oid)
+}
+
+func (f *FileLFSStorage) Size(repoPath, oid string) (int64, error) {
+	info, err := os.Stat(f.path(repoPath, oid))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("%s: %w", oid, ErrLFSObjectNotFound)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("stat %s: %w", oid, err)
+	}
+
+	return info.Size(), nil
+}
+
+func (f *FileLFSStorage) Open(repoPath, oid string) (io.ReadCloser, error) {
+	file, err := os.Open(f.path(repoPath, oid))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", oid, ErrLFSObjectNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", oid, err)
+	}
+
+	return file, nil
+}
+
+// Store writes the object to a temporary file first, which is renamed
+// once the content was read completely.
+func (f *FileLFSStorage) Store(repoPath, oid string, r io.Reader) error {
+	objPath := f.path(repoPath, oid)
+
+	if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(objPath), oid+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("write %s: %w", oid, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", oid, err)
+	}
+
+	if err := os.Rename(tmp.Name(), objPath); err != nil {
+		return fmt.Errorf("rename %s: %w", oid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+type lfsObject struct {
+	Oid     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions map[string]struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header"`
+	} `json:"actions"`
+	Error *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+type lfsBatch struct {
+	Transfer string      `json:"transfer"`
+	Objects  []lfsObject `json:"objects"`
+}
+
+type lfsLock struct {
+	ID    string `json:"id"`
+	Path  string `json:"path"`
+	Owner struct {
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+func TestLFSUploadAndDownload(t *testing.T) {
+	t.Parallel()
+
+	storages := map[string]server.LFSStorage{
+		"Memory": server.NewMemoryLFSStorage(),
+		"File":   server.NewFileLFSStorage(t.TempDir()),
+	}
+
+	for name, storage := range storages {
+		storage := storage
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName, server.WithLFS(storage))
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			data := []byte("large binary asset")
+			oid := lfsOid(data)
+			object := map[string]interface{}{"oid": oid, "size": len(data)}
+
+			var batch lfsBatch
+
+			resp := lfsDo(t, http.MethodPost, srv.URL()+"/info/lfs/objects/batch", map[string]interface{}{
+				"operation": "upload", "transfers": []string{"basic"}, "objects": []interface{}{object},
+			}, noAuth, &batch)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "basic", batch.Transfer)
+			require.Contains(t, batch.Objects[0].Actions, "upload")
+			require.Contains(t, batch.Objects[0].Actions, "verify")
+
+			resp = lfsDo(t, http.MethodPut, batch.Objects[0].Actions["upload"].Href, data, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp = lfsDo(t, http.MethodPost, batch.Objects[0].Actions["verify"].Href, object, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// uploaded objects need no further upload.
+			var uploaded lfsBatch
+
+			resp = lfsDo(t, http.MethodPost, srv.URL()+"/info/lfs/objects/batch", map[string]interface{}{
+				"operation": "upload", "objects": []interface{}{object},
+			}, noAuth, &uploaded)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Empty(t, uploaded.Objects[0].Actions)
+
+			var download lfsBatch
+
+			resp = lfsDo(t, http.MethodPost, srv.URL()+"/info/lfs/objects/batch", map[string]interface{}{
+				"operation": "download", "objects": []interface{}{object},
+			}, noAuth, &download)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp = lfsDo(t, http.MethodGet, download.Objects[0].Actions["download"].Href, nil, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			downloaded, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, data, downloaded)
+		})
+	}
+}
+
+func TestLFSRejectsContentNotMatchingOid(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithLFS(server.NewMemoryLFSStorage()))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	oid := lfsOid([]byte("expected content"))
+
+	resp := lfsDo(t, http.MethodPut, srv.URL()+"/info/lfs/objects/"+oid, []byte("other content"), noAuth, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	var batch lfsBatch
+
+	resp = lfsDo(t, http.MethodPost, srv.URL()+"/info/lfs/objects/batch", map[string]interface{}{
+		"operation": "download", "objects": []interface{}{map[string]interface{}{"oid": oid, "size": 16}},
+	}, noAuth, &batch)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, http.StatusNotFound, batch.Objects[0].Error.Code)
+
+	resp = lfsDo(t, http.MethodGet, srv.URL()+"/info/lfs/objects/"+oid, nil, noAuth, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLFSAuthorization(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithLFS(server.NewMemoryLFSStorage()),
+		server.WithAuthenticator(server.StaticUsers{"reader": "read", "writer": "write"}),
+		server.WithAuthorizer(server.Permissions{
+			"reader": {server.AnyRepository: server.AccessRead},
+			"writer": {server.AnyRepository: server.AccessWrite},
+		}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	reader := server.BasicAuth{Username: "reader", Password: "read"}
+	writer := server.BasicAuth{Username: "writer", Password: "write"}
+	upload := map[string]interface{}{
+		"operation": "upload", "objects": []interface{}{map[string]interface{}{"oid": lfsOid(nil), "size": 0}},
+	}
+	lock := map[string]string{"path": "a.bin"}
+
+	tests := map[string]struct {
+		method string
+		path   string
+		body   interface{}
+		auth   server.BasicAuth
+		status int
+	}{
+		"AnonymousBatch":  {http.MethodPost, "/info/lfs/objects/batch", upload, noAuth, http.StatusUnauthorized},
+		"ReaderUpload":    {http.MethodPost, "/info/lfs/objects/batch", upload, reader, http.StatusForbidden},
+		"WriterUpload":    {http.MethodPost, "/info/lfs/objects/batch", upload, writer, http.StatusOK},
+		"ReaderListLocks": {http.MethodGet, "/info/lfs/locks", nil, reader, http.StatusOK},
+		"ReaderLock":      {http.MethodPost, "/info/lfs/locks", lock, reader, http.StatusForbidden},
+		"WriterPut":       {http.MethodPut, "/info/lfs/objects/" + lfsOid(nil), []byte{}, writer, http.StatusOK},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := lfsDo(t, test.method, srv.URL()+test.path, test.body, test.auth, nil)
+			require.Equal(t, test.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestLFSLocks(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithLFS(server.NewMemoryLFSStorage()),
+		server.WithAuthenticator(server.StaticUsers{"alice": "a", "bob": "b"}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	alice := server.BasicAuth{Username: "alice", Password: "a"}
+	bob := server.BasicAuth{Username: "bob", Password: "b"}
+	locks := srv.URL() + "/info/lfs/locks"
+
+	var created struct {
+		Lock lfsLock `json:"lock"`
+	}
+
+	resp := lfsDo(t, http.MethodPost, locks, map[string]string{"path": "assets/logo.psd"}, alice, &created)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "alice", created.Lock.Owner.Name)
+
+	resp = lfsDo(t, http.MethodPost, locks, map[string]string{"path": "assets/logo.psd"}, bob, nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = lfsDo(t, http.MethodPost, locks, map[string]string{"path": "assets/font.ttf"}, bob, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var list struct {
+		Locks []lfsLock `json:"locks"`
+	}
+
+	resp = lfsDo(t, http.MethodGet, locks+"?path=assets/logo.psd", nil, bob, &list)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, list.Locks, 1)
+	require.Equal(t, created.Lock.ID, list.Locks[0].ID)
+
+	type verifyResponse struct {
+		Ours       []lfsLock `json:"ours"`
+		Theirs     []lfsLock `json:"theirs"`
+		NextCursor string    `json:"next_cursor"`
+	}
+
+	var firstPage, secondPage verifyResponse
+
+	resp = lfsDo(t, http.MethodPost, locks+"/verify", map[string]int{"limit": 1}, bob, &firstPage)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, firstPage.Theirs, 1)
+	require.Empty(t, firstPage.Ours)
+	require.NotEmpty(t, firstPage.NextCursor)
+
+	cursor := map[string]interface{}{"cursor": firstPage.NextCursor}
+	resp = lfsDo(t, http.MethodPost, locks+"/verify", cursor, bob, &secondPage)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, secondPage.Ours, 1)
+	require.Empty(t, secondPage.NextCursor)
+
+	unlock := fmt.Sprintf("%s/%s/unlock", locks, created.Lock.ID)
+
+	resp = lfsDo(t, http.MethodPost, unlock, map[string]bool{}, bob, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = lfsDo(t, http.MethodPost, unlock, map[string]bool{"force": true}, bob, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var remaining struct {
+		Locks []lfsLock `json:"locks"`
+	}
+
+	resp = lfsDo(t, http.MethodGet, locks, nil, alice, &remaining)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, remaining.Locks, 1)
+	require.Equal(t, "assets/font.ttf", remaining.Locks[0].Path)
+}
+
+func TestLFSRoutes(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.New(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithLFS(server.NewMemoryLFSStorage()))
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+
+	ginEngine := gin.New()
+	srv.SetupGinRoutes(ginEngine)
+
+	data := []byte("routed content")
+
+	for name, handler := range map[string]http.Handler{"ServeMux": mux, "Gin": ginEngine} {
+		handler := handler
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(handler)
+			t.Cleanup(ts.Close)
+
+			url := fmt.Sprintf("%s/%s/info/lfs/objects/%s", ts.URL, srv.RepoPath(), lfsOid(data))
+
+			resp := lfsDo(t, http.MethodPut, url, data, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp = lfsDo(t, http.MethodGet, url, nil, noAuth, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestLFSIsDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	download := map[string]string{"operation": "download"}
+	resp := lfsDo(t, http.MethodPost, srv.URL()+"/info/lfs/objects/batch", download, noAuth, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func lfsOid(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// lfsDo sends a Git LFS request, bodies other than raw bytes are sent
+// as JSON and JSON responses are decoded into out if given.
+func lfsDo(t *testing.T, method, url string, body interface{}, auth server.BasicAuth, out interface{}) *http.Response {
+	t.Helper()
+
+	var reqBody io.Reader
+
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reqBody = bytes.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	require.NoError(t, err)
+
+	req.Header.Set("Accept", "application/vnd.git-lfs+json")
+	req.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+
+	if auth != noAuth {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+
+	return resp
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxLoggedBody bounds the part of an error response body which is
+// logged as the cause of the failure.
+const maxLoggedBody = 512
+
+// WithLogger logs a structured record for every request, with the
+// causes of failures, and for every failed webhook delivery. By default
+// nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// discardHandler drops every record, it is the handler of the default
+// logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// logRequest logs the finished request, at error level if it failed
+// on the server side and at warning level if it failed otherwise, e.g.
+// a ref update was rejected after the response status was sent.
+func (s *Server) logRequest(
+	req *http.Request, repo, service string, obs *observation, writer *statusWriter, read int64,
+) {
+	status := writer.responseStatus()
+	err := obs.err(writer)
+
+	level := slog.LevelInfo
+
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest || err != nil:
+		level = slog.LevelWarn
+	}
+
+	if !s.logger.Enabled(req.Context(), level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.String("repo", repo),
+		slog.String("service", requestService(req, service)),
+		slog.String("principal", obs.principal),
+		slog.Int("status", status),
+		slog.Duration("duration", time.Since(obs.start)),
+		slog.Int64("bytes_in", read),
+		slog.Int64("bytes_out", writer.written),
+	}
+
+	if refs := atomic.LoadInt64(&obs.refsUpdated); refs > 0 {
+		attrs = append(attrs, slog.Int64("refs_updated", refs))
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	s.logger.LogAttrs(req.Context(), level, "request", attrs...)
+}
+
+// requestService returns the Git service of the request, which the
+// service query parameter names for info/refs requests.
+func requestService(req *http.Request, service string) string {
+	if name := req.URL.Query().Get("service"); service == infoRefs && name != "" {
+		return name
+	}
+
+	return service
+}
+
+// err returns the failures of the request, or the message of the error
+// response if none was recorded.
+func (o *observation) err(writer *statusWriter) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.failures) > 0 {
+		return errors.Join(o.failures...)
+	}
+
+	if writer.responseStatus() < http.StatusBadRequest || writer.body.Len() == 0 {
+		return nil
+	}
+
+	return errors.New(strings.TrimSpace(writer.body.String())) //nolint:goerr113
+}
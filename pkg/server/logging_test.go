@@ -0,0 +1,224 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	t.Parallel()
+
+	users := server.StaticUsers{"bob": "secret"}
+	auth := server.BasicAuth{Username: "bob", Password: "secret"}
+	logs := &logBuffer{mu: sync.Mutex{}, buf: bytes.Buffer{}}
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithAuthenticator(users),
+		server.WithLogger(logs.logger()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), auth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+	require.NoError(t, push(local, auth, "refs/heads/master:refs/heads/master"))
+
+	wrongAuth := server.BasicAuth{Username: "bob", Password: "wrong"}
+	require.Equal(t, http.StatusUnauthorized, getInfoRefs(t, srv.URL(), wrongAuth))
+
+	records := logs.records(t)
+	repo := server.RepoPath(owner, repoName)
+
+	tests := []struct {
+		name    string
+		path    string
+		service string
+		status  float64
+		fields  map[string]any
+	}{
+		{
+			name:    "clone",
+			path:    "/" + repo + "/git-upload-pack",
+			service: "git-upload-pack",
+			status:  http.StatusOK,
+			fields: map[string]any{
+				"level":     "INFO",
+				"method":    http.MethodPost,
+				"repo":      repo,
+				"principal": "bob",
+			},
+		},
+		{
+			name:    "push",
+			path:    "/" + repo + "/git-receive-pack",
+			service: "git-receive-pack",
+			status:  http.StatusOK,
+			fields: map[string]any{
+				"level":        "INFO",
+				"principal":    "bob",
+				"refs_updated": float64(1),
+			},
+		},
+		{
+			name:    "failed authentication",
+			path:    "/" + repo + "/info/refs",
+			service: "git-upload-pack",
+			status:  http.StatusUnauthorized,
+			fields: map[string]any{
+				"level":     "WARN",
+				"principal": "",
+				"error":     "authenticate: " + server.ErrInvalidAuth.Error(),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			record := findRecord(t, records, tc.path, tc.service, tc.status)
+			for key, value := range tc.fields {
+				require.Equal(t, value, record[key], key)
+			}
+
+			require.Contains(t, record, "duration")
+			require.Positive(t, record["bytes_out"])
+		})
+	}
+
+	pushed := findRecord(t, records, "/"+repo+"/git-receive-pack", "git-receive-pack", http.StatusOK)
+	require.Positive(t, pushed["bytes_in"])
+	require.NotContains(t, pushed, "error")
+}
+
+func TestLoggingFailures(t *testing.T) {
+	t.Parallel()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(respWriter http.ResponseWriter, _ *http.Request) {
+		respWriter.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(receiver.Close)
+
+	logs := &logBuffer{mu: sync.Mutex{}, buf: bytes.Buffer{}}
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithBranchProtection(server.BranchProtections{
+			server.AnyRepository: {{Pattern: "master", DenyDeletions: true}},
+		}),
+		server.WithWebhook(server.Webhook{URL: receiver.URL, MaxAttempts: 1}),
+		server.WithSynchronousWebhooks(),
+		server.WithLogger(logs.logger()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp, _ := rawGet(t, srv.URL()+"/raw/master/missing", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	// the feature branch is created while the deletion of master is
+	// rejected.
+	require.ErrorContains(t, push(local, noAuth, ":refs/heads/master", "refs/heads/master:refs/heads/feature"),
+		server.ErrProtectedBranch.Error())
+
+	records := logs.records(t)
+	repo := server.RepoPath(owner, repoName)
+
+	rejected := findRecord(t, records, "/"+repo+"/git-receive-pack", "git-receive-pack", http.StatusOK)
+	require.Equal(t, "WARN", rejected["level"])
+	require.Equal(t, float64(1), rejected["refs_updated"])
+	require.Contains(t, rejected["error"], "refs/heads/master: "+server.ErrProtectedBranch.Error())
+
+	notFound := findRecord(t, records, "/"+repo+"/raw/master/missing", "raw", http.StatusNotFound)
+	require.Equal(t, "WARN", notFound["level"])
+	require.NotEmpty(t, notFound["error"])
+
+	webhook := findMessage(t, records, "webhook delivery failed")
+	require.Equal(t, "ERROR", webhook["level"])
+	require.Equal(t, receiver.URL, webhook["url"])
+	require.Equal(t, "refs/heads/feature", webhook["ref"])
+	require.Contains(t, webhook["error"], server.ErrWebhookStatus.Error())
+}
+
+// logBuffer collects the JSON records of a logger, which the handlers
+// of concurrent requests write to.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.buf.Write(p) //nolint:wrapcheck
+}
+
+func (l *logBuffer) logger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(l, nil))
+}
+
+func (l *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := []map[string]any{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(l.buf.Bytes()))
+	for scanner.Scan() {
+		record := map[string]any{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+
+		records = append(records, record)
+	}
+
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func findRecord(t *testing.T, records []map[string]any, path, service string, status float64) map[string]any {
+	t.Helper()
+
+	for _, record := range records {
+		if record["msg"] == "request" && record["path"] == path &&
+			record["service"] == service && record["status"] == status {
+			return record
+		}
+	}
+
+	require.Failf(t, "request record not found", "%s %s %v", path, service, status)
+
+	return nil
+}
+
+func findMessage(t *testing.T, records []map[string]any, msg string) map[string]any {
+	t.Helper()
+
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+
+	require.Failf(t, "record not found", msg)
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "git_http_backend"
+	// unknownRepo labels requests for repositories which are not
+	// served, so made up paths do not create new series.
+	unknownRepo = "unknown"
+)
+
+var ErrMetricConflict = fmt.Errorf("metric registered with another type")
+
+// metrics holds the Prometheus collectors of the Git HTTP endpoints,
+// labelled by repository path and service, i.e. the endpoint such as
+// info/refs, git-upload-pack, git-receive-pack or archive.
+type metrics struct {
+	gatherer prometheus.Gatherer
+
+	requests        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	bytesReceived   *prometheus.CounterVec
+	bytesSent       *prometheus.CounterVec
+	objectsSent     *prometheus.CounterVec
+	objectsReceived *prometheus.CounterVec
+	refsUpdated     *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+}
+
+// WithMetrics registers the metrics of the Git HTTP, LFS and GitHub API
+// endpoints with the registry, they are served by MetricsHandler.
+// Servers sharing a registry share the metrics. The Server is not
+// created if the registry holds a conflicting collector.
+func WithMetrics(registry *prometheus.Registry) Option {
+	return func(s *Server) {
+		m, err := newMetrics(registry)
+		if err != nil {
+			if s.optionErr == nil {
+				s.optionErr = err
+			}
+
+			return
+		}
+
+		s.metrics = m
+	}
+}
+
+// MetricsHandler returns the handler serving the metrics registry of
+// WithMetrics in the Prometheus exposition format, it responds with
+// 404 Not Found if metrics are disabled.
+func (s *Server) MetricsHandler() http.Handler { //nolint:ireturn
+	if s.metrics == nil {
+		return http.NotFoundHandler()
+	}
+
+	return promhttp.HandlerFor(s.metrics.gatherer, promhttp.HandlerOpts{}) //nolint:exhaustivestruct
+}
+
+func newMetrics(registry *prometheus.Registry) (*metrics, error) {
+	labels := []string{"repo", "service"}
+
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustivestruct
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	m := &metrics{
+		gatherer: registry,
+
+		requests: counter("requests_total",
+			"Git HTTP requests by status code.", "repo", "service", "code"),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:exhaustivestruct
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of Git HTTP requests.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8), //nolint:gomnd
+		}, labels),
+		bytesReceived: counter("request_bytes_total",
+			"Bytes received in Git HTTP request bodies.", labels...),
+		bytesSent: counter("response_bytes_total",
+			"Bytes sent in Git HTTP response bodies.", labels...),
+		objectsSent: counter("objects_sent_total",
+			"Objects sent in packfiles to fetching clients.", labels...),
+		objectsReceived: counter("objects_received_total",
+			"Objects received in packfiles from pushing clients.", labels...),
+		refsUpdated: counter("refs_updated_total",
+			"References created, updated or deleted by pushes.", labels...),
+		authFailures: counter("auth_failures_total",
+			"Requests failing authentication or authorization.", labels...),
+	}
+
+	counters := []**prometheus.CounterVec{
+		&m.requests, &m.bytesReceived, &m.bytesSent, &m.objectsSent,
+		&m.objectsReceived, &m.refsUpdated, &m.authFailures,
+	}
+
+	for _, vec := range counters {
+		collector, err := register(registry, *vec)
+		if err != nil {
+			return nil, err
+		}
+
+		registered, ok := collector.(*prometheus.CounterVec)
+		if !ok {
+			return nil, fmt.Errorf("register: %w", ErrMetricConflict)
+		}
+
+		*vec = registered
+	}
+
+	collector, err := register(registry, m.duration)
+	if err != nil {
+		return nil, err
+	}
+
+	registered, ok := collector.(*prometheus.HistogramVec)
+	if !ok {
+		return nil, fmt.Errorf("register: %w", ErrMetricConflict)
+	}
+
+	m.duration = registered
+
+	return m, nil
+}
+
+// register registers the collector, or returns the collector already
+// registered by another Server.
+func register(
+	registry *prometheus.Registry, collector prometheus.Collector,
+) (prometheus.Collector, error) { //nolint:ireturn
+	err := registry.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		return registered.ExistingCollector, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+
+	return collector, nil
+}
+
+// record adds the observation of a finished request to the metrics.
+func (m *metrics) record(repo, service string, obs *observation, status int, written, read int64) {
+	m.requests.WithLabelValues(repo, service, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(repo, service).Observe(time.Since(obs.start).Seconds())
+	m.bytesReceived.WithLabelValues(repo, service).Add(float64(read))
+	m.bytesSent.WithLabelValues(repo, service).Add(float64(written))
+	m.objectsSent.WithLabelValues(repo, service).Add(float64(atomic.LoadInt64(&obs.objectsSent)))
+	m.objectsReceived.WithLabelValues(repo, service).Add(float64(atomic.LoadInt64(&obs.objectsReceived)))
+	m.refsUpdated.WithLabelValues(repo, service).Add(float64(atomic.LoadInt64(&obs.refsUpdated)))
+	m.authFailures.WithLabelValues(repo, service).Add(float64(atomic.LoadInt64(&obs.authFailed)))
+}
@@ -0,0 +1,248 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	auth := server.BasicAuth{Username: "bob", Password: "secret"}
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithBasicAuth(auth),
+		server.WithMetrics(registry),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), auth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+	require.NoError(t, push(local, auth, "refs/heads/master:refs/heads/master"))
+
+	wrong := server.BasicAuth{Username: "bob", Password: "wrong"}
+	require.Equal(t, http.StatusUnauthorized, getInfoRefs(t, srv.URL(), wrong))
+
+	repo := server.RepoPath(owner, repoName)
+
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		value  float64
+	}{
+		{
+			name:   "successful info/refs",
+			metric: "git_http_backend_requests_total",
+			labels: map[string]string{"repo": repo, "service": "info/refs", "code": "200"},
+			value:  2,
+		},
+		{
+			name:   "failed info/refs",
+			metric: "git_http_backend_requests_total",
+			labels: map[string]string{"repo": repo, "service": "info/refs", "code": "401"},
+			value:  1,
+		},
+		{
+			name:   "upload-pack",
+			metric: "git_http_backend_requests_total",
+			labels: map[string]string{"repo": repo, "service": "git-upload-pack", "code": "200"},
+			value:  1,
+		},
+		{
+			name:   "objects sent",
+			metric: "git_http_backend_objects_sent_total",
+			labels: map[string]string{"repo": repo, "service": "git-upload-pack"},
+			value:  3,
+		},
+		{
+			name:   "objects received",
+			metric: "git_http_backend_objects_received_total",
+			labels: map[string]string{"repo": repo, "service": "git-receive-pack"},
+			value:  3,
+		},
+		{
+			name:   "refs updated",
+			metric: "git_http_backend_refs_updated_total",
+			labels: map[string]string{"repo": repo, "service": "git-receive-pack"},
+			value:  1,
+		},
+		{
+			name:   "auth failures",
+			metric: "git_http_backend_auth_failures_total",
+			labels: map[string]string{"repo": repo, "service": "info/refs"},
+			value:  1,
+		},
+		{
+			name:   "durations",
+			metric: "git_http_backend_request_duration_seconds",
+			labels: map[string]string{"repo": repo, "service": "git-receive-pack"},
+			value:  1,
+		},
+	}
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.value, metricValue(t, families, tc.metric, tc.labels))
+		})
+	}
+
+	for _, metric := range []string{"git_http_backend_request_bytes_total", "git_http_backend_response_bytes_total"} {
+		labels := map[string]string{"repo": repo, "service": "git-receive-pack"}
+		require.Positive(t, metricValue(t, families, metric, labels), metric)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+
+	// servers sharing the registry share the metrics.
+	other, err := server.New(repoWithInitCommit(t, filename, content), owner, "tools", server.WithMetrics(registry))
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName, server.WithMetrics(registry))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	cloneRepository(t, srv.URL(), noAuth)
+
+	for name, handler := range map[string]http.Handler{
+		"server":       srv.Server.MetricsHandler(),
+		"other server": other.MetricsHandler(),
+	} {
+		body := metricsGet(t, handler, http.StatusOK)
+		require.Contains(t, body,
+			`git_http_backend_requests_total{code="200",repo="bob/shed.git",service="git-upload-pack"} 1`, name)
+	}
+
+	disabled, err := server.New(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	metricsGet(t, disabled.MetricsHandler(), http.StatusNotFound)
+}
+
+func TestMetricsRegistrationConflict(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "git_http_backend_requests_total",
+		Help: "Unrelated counter.",
+	}))
+
+	srv, err := server.New(repoWithInitCommit(t, filename, content), owner, repoName, server.WithMetrics(registry))
+	require.Error(t, err)
+	require.Nil(t, srv)
+}
+
+func getInfoRefs(t *testing.T, url string, auth server.BasicAuth) int {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/info/refs?service=git-upload-pack", nil)
+	require.NoError(t, err)
+
+	req.SetBasicAuth(auth.Username, auth.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp.StatusCode
+}
+
+func metricsGet(t *testing.T, handler http.Handler, status int) string {
+	t.Helper()
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	require.Equal(t, status, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+// metricValue returns the value of the counter, or the sample count of
+// the histogram, with the labels.
+func metricValue(t *testing.T, families []*dto.MetricFamily, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if !hasLabels(metric, labels) {
+				continue
+			}
+
+			if histogram := metric.GetHistogram(); histogram != nil {
+				return float64(histogram.GetSampleCount())
+			}
+
+			return metric.GetCounter().GetValue()
+		}
+	}
+
+	require.Failf(t, "metric not found", "%s %v", name, labels)
+
+	return 0
+}
+
+func hasLabels(metric *dto.Metric, labels map[string]string) bool {
+	found := 0
+
+	for _, pair := range metric.GetLabel() {
+		value, ok := labels[pair.GetName()]
+		if !ok {
+			continue
+		}
+
+		if value != pair.GetValue() {
+			return false
+		}
+
+		found++
+	}
+
+	return found == len(labels)
+}
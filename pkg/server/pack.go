@@ -0,0 +1,312 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// packWindow is the number of objects considered for delta
+// compression when encoding a pack.
+const packWindow = 10
+
+// packSpec selects the objects sent to the client.
+type packSpec struct {
+	wants []plumbing.Hash
+	haves []plumbing.Hash
+	// filter omits objects for a partial clone, it is nil otherwise.
+	filter *objectFilter
+	// clientShallows are the commits the client has without their
+	// parents before the fetch.
+	clientShallows []plumbing.Hash
+	// shallows are the commits the client has without their parents
+	// after the fetch, their parents are not sent.
+	shallows []plumbing.Hash
+}
+
+// commonObjects returns the haves of the client which are known to
+// the storer.
+func commonObjects(st storer.EncodedObjectStorer, haves []plumbing.Hash) []plumbing.Hash {
+	common := make([]plumbing.Hash, 0, len(haves))
+
+	for _, have := range haves {
+		if st.HasEncodedObject(have) == nil {
+			common = append(common, have)
+		}
+	}
+
+	return common
+}
+
+// objectsToPack returns the objects reachable from the wants which are
+// not reachable from any of the haves, omitting those excluded by the
+// filter or hidden behind shallow commits. Haves unknown to the storer
+// are ignored, as the client may have objects the server does not.
+func objectsToPack(st storer.EncodedObjectStorer, spec *packSpec) ([]plumbing.Hash, error) {
+	common := commonObjects(st, spec.haves)
+
+	if spec.filter == nil && len(spec.clientShallows) == 0 && len(spec.shallows) == 0 {
+		ignore, err := revlist.Objects(st, common, nil)
+		if err != nil {
+			return nil, fmt.Errorf("revlist haves: %w", err)
+		}
+
+		objs, err := revlist.Objects(st, spec.wants, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("revlist wants: %w", err)
+		}
+
+		return objs, nil
+	}
+
+	// the history of the client ends at its shallow commits.
+	ignore, err := newObjectWalker(st, nil, spec.clientShallows).walk(common)
+	if err != nil {
+		return nil, err
+	}
+
+	walker := newObjectWalker(st, spec.filter, spec.shallows)
+
+	for _, hash := range ignore {
+		walker.added[hash] = true
+	}
+
+	return walker.walk(spec.wants)
+}
+
+// objectWalker collects the objects reachable from commits, trees,
+// blobs and tags, like revlist but honouring filters and shallow
+// commits. Objects wanted explicitly are never omitted by the filter.
+type objectWalker struct {
+	st     storer.EncodedObjectStorer
+	filter *objectFilter
+	// shallows are the commits whose parents are not walked.
+	shallows map[plumbing.Hash]bool
+	// added holds the objects collected and those to be ignored.
+	added map[plumbing.Hash]bool
+	// trees holds the smallest depth the trees were walked at.
+	trees map[string]int
+	objs  []plumbing.Hash
+}
+
+func newObjectWalker(st storer.EncodedObjectStorer, filter *objectFilter, shallows []plumbing.Hash) *objectWalker {
+	walker := &objectWalker{
+		st:       st,
+		filter:   filter,
+		shallows: map[plumbing.Hash]bool{},
+		added:    map[plumbing.Hash]bool{},
+		trees:    map[string]int{},
+		objs:     []plumbing.Hash{},
+	}
+
+	for _, hash := range shallows {
+		walker.shallows[hash] = true
+	}
+
+	return walker
+}
+
+// walk returns the objects collected walking from starts.
+func (w *objectWalker) walk(starts []plumbing.Hash) ([]plumbing.Hash, error) {
+	for _, start := range starts {
+		if err := w.walkWant(start); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.objs, nil
+}
+
+func (w *objectWalker) add(hash plumbing.Hash) bool {
+	if w.added[hash] {
+		return false
+	}
+
+	w.added[hash] = true
+	w.objs = append(w.objs, hash)
+
+	return true
+}
+
+// walkWant walks an object wanted by the client, which is included
+// whatever its type.
+func (w *objectWalker) walkWant(hash plumbing.Hash) error {
+	obj, err := w.st.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return fmt.Errorf("object %s: %w", hash, err)
+	}
+
+	switch obj.Type() {
+	case plumbing.CommitObject:
+		return w.walkCommits(hash)
+	case plumbing.TagObject:
+		tag, err := object.DecodeTag(w.st, obj)
+		if err != nil {
+			return fmt.Errorf("decode tag %s: %w", hash, err)
+		}
+
+		w.add(hash)
+
+		return w.walkWant(tag.Target)
+	case plumbing.TreeObject:
+		w.add(hash)
+
+		return w.walkTree(hash, 0, "")
+	case plumbing.BlobObject, plumbing.OFSDeltaObject, plumbing.REFDeltaObject,
+		plumbing.InvalidObject, plumbing.AnyObject:
+		w.add(hash)
+	}
+
+	return nil
+}
+
+func (w *objectWalker) walkCommits(start plumbing.Hash) error {
+	pending := []plumbing.Hash{start}
+
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if !w.add(hash) {
+			continue
+		}
+
+		commit, err := object.GetCommit(w.st, hash)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", hash, err)
+		}
+
+		if w.filter.includesTree(0) {
+			w.add(commit.TreeHash)
+
+			if err := w.walkTree(commit.TreeHash, 0, ""); err != nil {
+				return err
+			}
+		}
+
+		if !w.shallows[hash] {
+			pending = append(pending, commit.ParentHashes...)
+		}
+	}
+
+	return nil
+}
+
+// walkTree walks the entries of the tree at depth below the root tree
+// and dir.
+func (w *objectWalker) walkTree(hash plumbing.Hash, depth int, dir string) error {
+	// the same tree is walked again at a smaller depth as more of its
+	// entries may pass a tree depth filter, and again at every path
+	// for a path dependent filter.
+	key := hash.String()
+	if w.filter.pathDependent() {
+		key += ":" + dir
+	}
+
+	if walked, ok := w.trees[key]; ok && walked <= depth {
+		return nil
+	}
+
+	w.trees[key] = depth
+
+	tree, err := object.GetTree(w.st, hash)
+	if err != nil {
+		return fmt.Errorf("tree %s: %w", hash, err)
+	}
+
+	for _, entry := range tree.Entries {
+		entryPath := entry.Name
+		if dir != "" {
+			entryPath = dir + "/" + entry.Name
+		}
+
+		switch entry.Mode {
+		case filemode.Submodule:
+			continue
+		case filemode.Dir:
+			if !w.filter.includesTree(depth + 1) {
+				continue
+			}
+
+			w.add(entry.Hash)
+
+			if err := w.walkTree(entry.Hash, depth+1, entryPath); err != nil {
+				return err
+			}
+		case filemode.Empty, filemode.Regular, filemode.Deprecated, filemode.Executable, filemode.Symlink:
+			if w.added[entry.Hash] {
+				continue
+			}
+
+			include, err := w.filter.includesBlob(w.st, entry.Hash, depth+1, entryPath)
+			if err != nil {
+				return err
+			}
+
+			if include {
+				w.add(entry.Hash)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readyToPack reports whether the common objects are a base for every
+// wanted commit, i.e. each of them has a common ancestor. Like
+// git-upload-pack, the negotiation only ends before the client is done
+// once it is ready.
+func readyToPack(st storer.EncodedObjectStorer, wants, common []plumbing.Hash) bool {
+	if len(common) == 0 {
+		return false
+	}
+
+	known := make(map[plumbing.Hash]bool, len(common))
+	for _, hash := range common {
+		known[hash] = true
+	}
+
+	for _, want := range wants {
+		commit, err := object.GetCommit(st, want)
+		if err != nil {
+			// only commits take part in the negotiation
+			continue
+		}
+
+		found := false
+
+		err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+			if known[c.Hash] {
+				found = true
+
+				return storer.ErrStop
+			}
+
+			return nil
+		})
+		if err != nil || !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// encodePack writes a packfile holding objs to w. Deltas refer to
+// their base by hash rather than by offset if refDeltas is set, as
+// clients which did not ask for ofs-delta expect.
+func encodePack(w io.Writer, st storer.EncodedObjectStorer, objs []plumbing.Hash, refDeltas bool) error {
+	enc := packfile.NewEncoder(w, st, refDeltas)
+
+	if _, err := enc.Encode(objs, packWindow); err != nil {
+		return fmt.Errorf("encode pack: %w", err)
+	}
+
+	return nil
+}
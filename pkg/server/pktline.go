@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// pktKind is the kind of a pkt-line, protocol version 2 adds the
+// delimiter and response end packets to the flush packet of earlier
+// versions, which the go-git pktline scanner does not understand.
+type pktKind int
+
+const (
+	pktData pktKind = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+const (
+	pktLenSize = 4
+	pktMaxSize = 65520
+	delimPkt   = "0001"
+)
+
+var ErrInvalidPktLine = fmt.Errorf("invalid pkt-line")
+
+// readPktLine reads a single pkt-line from r. The payload of data
+// packets is returned without a trailing line feed.
+func readPktLine(r io.Reader) (pktKind, []byte, error) {
+	var lenBuf [pktLenSize]byte
+
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return pktData, nil, fmt.Errorf("read pkt-len: %w", err)
+	}
+
+	size, err := strconv.ParseUint(string(lenBuf[:]), 16, 16)
+	if err != nil {
+		return pktData, nil, fmt.Errorf("%q: %w", lenBuf, ErrInvalidPktLine)
+	}
+
+	switch {
+	case size == 0:
+		return pktFlush, nil, nil
+	case size == 1:
+		return pktDelim, nil, nil
+	case size == 2: //nolint:gomnd
+		return pktResponseEnd, nil, nil
+	case size <= pktLenSize || size > pktMaxSize:
+		return pktData, nil, fmt.Errorf("length %d: %w", size, ErrInvalidPktLine)
+	}
+
+	payload := make([]byte, size-pktLenSize)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return pktData, nil, fmt.Errorf("read pkt payload: %w", err)
+	}
+
+	if l := len(payload); l > 0 && payload[l-1] == '\n' {
+		payload = payload[:l-1]
+	}
+
+	return pktData, payload, nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+)
+
+var (
+	ErrProtectedBranch = fmt.Errorf("protected branch")
+
+	errForcePush       = fmt.Errorf("force pushes are not allowed")
+	errDeletion        = fmt.Errorf("deletions are not allowed")
+	errPrincipalDenied = fmt.Errorf("not allowed to push")
+	errMissingTrailer  = fmt.Errorf("missing required trailer")
+)
+
+// BranchProtection is a rule protecting the references matching
+// Pattern, with the syntax of path.Match. Patterns not starting with
+// refs/ match branches, e.g. main or release/*.
+type BranchProtection struct {
+	Pattern string
+	// DenyForcePushes rejects updates which are not fast-forwards.
+	DenyForcePushes bool
+	// DenyDeletions rejects deleting the references.
+	DenyDeletions bool
+	// AllowedPrincipals are the names of the principals allowed to
+	// push to the references, every principal is allowed if empty.
+	AllowedPrincipals []string
+	// RequiredTrailers are the trailer keys, e.g. Signed-off-by, every
+	// pushed commit must carry. Keys are compared case insensitively.
+	RequiredTrailers []string
+}
+
+// BranchProtections holds the branch protection rules by repository
+// path, e.g. owner/name.git. Paths are matched like Registry.Lookup
+// does, ignoring case and a leading slash. AnyRepository applies to
+// repositories without rules of their own.
+type BranchProtections map[string][]BranchProtection
+
+// WithBranchProtection enforces the rules on every push. Commands
+// violating a rule are rejected with ErrProtectedBranch before any
+// hook runs, the client is sent the violated rule as a remote message.
+func WithBranchProtection(protections BranchProtections) Option {
+	return func(s *Server) {
+		s.protections = protections
+	}
+}
+
+// rules returns the rules of the repository matching the reference.
+func (p BranchProtections) rules(repoPath string, name plumbing.ReferenceName) []BranchProtection {
+	rules := p[AnyRepository]
+
+	for key, repoRules := range p {
+		if normaliseRepoPath(key) == normaliseRepoPath(repoPath) {
+			rules = repoRules
+
+			break
+		}
+	}
+
+	matching := []BranchProtection{}
+
+	for _, rule := range rules {
+		if rule.matches(name) {
+			matching = append(matching, rule)
+		}
+	}
+
+	return matching
+}
+
+func (r BranchProtection) matches(name plumbing.ReferenceName) bool {
+	pattern := r.Pattern
+	if !strings.HasPrefix(pattern, "refs/") {
+		pattern = "refs/heads/" + pattern
+	}
+
+	matched, err := path.Match(pattern, name.String())
+
+	return err == nil && matched
+}
+
+// protect checks the command against the branch protection rules of
+// the repository, a violation is reported to the client through the
+// progress of the push.
+func (s *Server) protect(push *Push, cmd *packp.Command) error {
+	for _, rule := range s.protections.rules(push.RepoPath, cmd.Name) {
+		if err := rule.check(push, cmd); err != nil {
+			fmt.Fprintf(push.Progress, "error: %s: %s\n", cmd.Name, err)
+
+			return ErrProtectedBranch
+		}
+	}
+
+	return nil
+}
+
+func (r BranchProtection) check(push *Push, cmd *packp.Command) error {
+	if len(r.AllowedPrincipals) > 0 && !contains(r.AllowedPrincipals, push.Principal.Name) {
+		return fmt.Errorf("%s: %w", principalName(push.Principal), errPrincipalDenied)
+	}
+
+	if cmd.Action() == packp.Delete {
+		if r.DenyDeletions {
+			return errDeletion
+		}
+
+		return nil
+	}
+
+	if r.DenyForcePushes && cmd.Action() == packp.Update {
+		fastForward, err := isFastForward(push.Repository, cmd.Old, cmd.New)
+		if err != nil {
+			return err
+		}
+
+		if !fastForward {
+			return errForcePush
+		}
+	}
+
+	if len(r.RequiredTrailers) == 0 {
+		return nil
+	}
+
+	commits, err := newCommits(push.Repository, cmd.New)
+	if err != nil {
+		return err
+	}
+
+	for _, commit := range commits {
+		for _, key := range r.RequiredTrailers {
+			if !hasTrailer(commit.Message, key) {
+				return fmt.Errorf("commit %s: %w %s", commit.Hash, errMissingTrailer, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func principalName(principal Principal) string {
+	if principal.IsAnonymous() {
+		return "anonymous"
+	}
+
+	return principal.Name
+}
+
+// isFastForward reports whether the history of the new commit contains
+// the old one. Updates of references not pointing to commits are never
+// fast-forwards.
+func isFastForward(repo *git.Repository, oldHash, newHash plumbing.Hash) (bool, error) {
+	oldCommit, err := repo.CommitObject(oldHash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("commit %s: %w", oldHash, err)
+	}
+
+	newCommit, err := repo.CommitObject(newHash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("commit %s: %w", newHash, err)
+	}
+
+	fastForward, err := oldCommit.IsAncestor(newCommit)
+	if err != nil {
+		return false, fmt.Errorf("ancestry of %s: %w", newHash, err)
+	}
+
+	return fastForward, nil
+}
+
+// newCommits returns the commits reachable from hash which are not
+// reachable from any reference yet, i.e. the commits brought in by the
+// push.
+func newCommits(repo *git.Repository, hash plumbing.Hash) ([]*object.Commit, error) {
+	hash, _ = peelTag(repo, hash)
+
+	commit, err := repo.CommitObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", hash, err)
+	}
+
+	known := map[plumbing.Hash]bool{}
+
+	refs, err := repo.Storer.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("references: %w", err)
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		refHash, _ := peelTag(repo, ref.Hash())
+
+		refCommit, err := repo.CommitObject(refHash)
+		if err != nil {
+			// references to trees and blobs have no history.
+			return nil //nolint:nilerr
+		}
+
+		return object.NewCommitPreorderIter(refCommit, known, nil).ForEach(func(c *object.Commit) error {
+			known[c.Hash] = true
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk references: %w", err)
+	}
+
+	commits := []*object.Commit{}
+
+	err = object.NewCommitPreorderIter(commit, known, nil).ForEach(func(c *object.Commit) error {
+		commits = append(commits, c)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", hash, err)
+	}
+
+	return commits, nil
+}
+
+// hasTrailer reports whether the last paragraph of the commit message
+// holds a trailer with the key, as git interpret-trailers parses them.
+// A message made of the subject alone has no trailers.
+func hasTrailer(message, key string) bool {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		return false
+	}
+
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), key) && strings.TrimSpace(parts[1]) != "" {
+			return true
+		}
+	}
+
+	return false
+}
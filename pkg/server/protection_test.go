@@ -0,0 +1,256 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchProtection(t *testing.T) {
+	t.Parallel()
+
+	users := server.StaticUsers{"alice": "secret", "mallory": "secret"}
+	alice := server.BasicAuth{Username: "alice", Password: "secret"}
+	mallory := server.BasicAuth{Username: "mallory", Password: "secret"}
+
+	// diverge commits on the server, so pushing the local commit is no
+	// longer a fast-forward.
+	diverge := func(t *testing.T, remote, local *git.Repository) {
+		t.Helper()
+
+		commitFile(t, remote, filename, "remote content", "remote commit")
+		commitFile(t, local, filename, "local content", "local commit")
+	}
+
+	fastForward := func(t *testing.T, remote, local *git.Repository) {
+		t.Helper()
+
+		commitFile(t, local, filename, "local content", "local commit")
+	}
+
+	signedOff := func(t *testing.T, remote, local *git.Repository) {
+		t.Helper()
+
+		commitFile(t, local, filename, "signed content", "signed commit\n\nSigned-off-by: alice <alice@builder.test>")
+		commitFile(t, local, filename, "local content", "local commit\n\nsigned-off-by: alice <alice@builder.test>")
+	}
+
+	notSignedOff := func(t *testing.T, remote, local *git.Repository) {
+		t.Helper()
+
+		commitFile(t, local, filename, "signed content", "signed commit\n\nSigned-off-by: alice <alice@builder.test>")
+		commitFile(t, local, filename, "local content", "Signed-off-by: alice <alice@builder.test>")
+	}
+
+	tests := []struct {
+		name     string
+		rule     server.BranchProtection
+		auth     server.BasicAuth
+		setup    func(t *testing.T, remote, local *git.Repository)
+		refSpec  config.RefSpec
+		rejected bool
+	}{
+		{
+			name:     "force push denied",
+			rule:     server.BranchProtection{Pattern: "master", DenyForcePushes: true},
+			auth:     alice,
+			setup:    diverge,
+			refSpec:  "+refs/heads/master:refs/heads/master",
+			rejected: true,
+		},
+		{
+			name:     "fast-forward with force push denied",
+			rule:     server.BranchProtection{Pattern: "master", DenyForcePushes: true},
+			auth:     alice,
+			setup:    fastForward,
+			refSpec:  "refs/heads/master:refs/heads/master",
+			rejected: false,
+		},
+		{
+			name:     "force push to unprotected branch",
+			rule:     server.BranchProtection{Pattern: "release/*", DenyForcePushes: true},
+			auth:     alice,
+			setup:    diverge,
+			refSpec:  "+refs/heads/master:refs/heads/master",
+			rejected: false,
+		},
+		{
+			name:     "deletion denied",
+			rule:     server.BranchProtection{Pattern: "refs/heads/*", DenyDeletions: true},
+			auth:     alice,
+			setup:    fastForward,
+			refSpec:  ":refs/heads/master",
+			rejected: true,
+		},
+		{
+			name:     "allowed principal",
+			rule:     server.BranchProtection{Pattern: "master", AllowedPrincipals: []string{"alice"}},
+			auth:     alice,
+			setup:    fastForward,
+			refSpec:  "refs/heads/master:refs/heads/master",
+			rejected: false,
+		},
+		{
+			name:     "principal not allowed",
+			rule:     server.BranchProtection{Pattern: "master", AllowedPrincipals: []string{"alice"}},
+			auth:     mallory,
+			setup:    fastForward,
+			refSpec:  "refs/heads/master:refs/heads/master",
+			rejected: true,
+		},
+		{
+			name:     "required trailers",
+			rule:     server.BranchProtection{Pattern: "master", RequiredTrailers: []string{"Signed-off-by"}},
+			auth:     alice,
+			setup:    signedOff,
+			refSpec:  "refs/heads/master:refs/heads/master",
+			rejected: false,
+		},
+		{
+			name:     "missing required trailer",
+			rule:     server.BranchProtection{Pattern: "master", RequiredTrailers: []string{"Signed-off-by"}},
+			auth:     alice,
+			setup:    notSignedOff,
+			refSpec:  "refs/heads/master:refs/heads/master",
+			rejected: true,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testRepo := repoWithInitCommit(t, filename, content)
+
+			srv, err := server.NewHTTPTest(testRepo, owner, repoName,
+				server.WithAuthenticator(users),
+				server.WithBranchProtection(server.BranchProtections{
+					server.RepoPath(owner, repoName): {tc.rule},
+				}),
+			)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			local := cloneRepository(t, srv.URL(), tc.auth)
+			tc.setup(t, testRepo, local)
+
+			before, err := testRepo.Reference("refs/heads/master", false)
+			require.NoError(t, err)
+
+			err = push(local, tc.auth, tc.refSpec, "refs/heads/master:refs/heads/feature")
+
+			_, featureErr := testRepo.Reference("refs/heads/feature", false)
+
+			after, afterErr := testRepo.Reference("refs/heads/master", false)
+			if !tc.rejected {
+				require.NoError(t, err)
+				require.NoError(t, featureErr)
+
+				return
+			}
+
+			require.ErrorContains(t, err, server.ErrProtectedBranch.Error())
+			require.NoError(t, afterErr)
+			require.Equal(t, before.Hash(), after.Hash())
+
+			// the other reference of the push is not protected.
+			require.NoError(t, featureErr)
+		})
+	}
+}
+
+func TestAnyRepositoryBranchProtection(t *testing.T) {
+	t.Parallel()
+
+	registry := server.NewRegistry()
+
+	protected := repoWithInitCommit(t, filename, content)
+	_, err := registry.Add(owner, repoName, protected)
+	require.NoError(t, err)
+
+	unprotected := repoWithInitCommit(t, filename, content)
+	_, err = registry.Add(owner, "tools", unprotected)
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTestWithRegistry(registry, server.WithBranchProtection(server.BranchProtections{
+		server.AnyRepository:            {{Pattern: "master", DenyDeletions: true}},
+		server.RepoPath(owner, "tools"): {},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	for name, rejected := range map[string]bool{repoName: true, "tools": false} {
+		local := cloneRepository(t, srv.RepoURL(owner, name), noAuth)
+		commitFile(t, local, filename, "feature content", "feature commit")
+		require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/feature"))
+
+		err := push(local, noAuth, ":refs/heads/master")
+		if rejected {
+			require.ErrorContains(t, err, server.ErrProtectedBranch.Error(), name)
+		} else {
+			require.NoError(t, err, name)
+		}
+	}
+
+	_, err = protected.Reference("refs/heads/master", false)
+	require.NoError(t, err)
+
+	_, err = unprotected.Reference("refs/heads/master", false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestBranchProtectionIgnoresPathCase(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithBranchProtection(server.BranchProtections{
+		"/" + strings.ToUpper(server.RepoPath(owner, repoName)): {{Pattern: "master", DenyDeletions: true}},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/feature"))
+
+	err = push(local, noAuth, ":refs/heads/master")
+	require.ErrorContains(t, err, server.ErrProtectedBranch.Error())
+}
+
+func TestGitCLIShowsProtectedBranch(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithBranchProtection(server.BranchProtections{
+		server.AnyRepository: {{Pattern: "master", DenyForcePushes: true}},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+	runGit(t, dir, "", "clone", srv.URL(), ".")
+
+	commitFile(t, testRepo, filename, "remote content", "remote commit")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, filename), []byte("cli content"), 0o600))
+	runGit(t, dir, "", "commit", "-am", "cli commit")
+
+	out, err := gitCommand(t, dir, "", "push", "--force", "origin", "master").CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(out), "! [remote rejected] master -> master (protected branch)")
+	require.Contains(t, string(out), "remote: error: refs/heads/master: force pushes are not allowed")
+}
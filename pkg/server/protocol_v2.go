@@ -0,0 +1,505 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Protocol version 2 is requested by clients through the Git-Protocol
+// header, see
+// https://github.com/git/git/blob/master/Documentation/technical/protocol-v2.txt
+const (
+	gitProtocolHeader = "Git-Protocol"
+	protocolVersion2  = "version=2"
+
+	commandLsRefs = "ls-refs"
+	commandFetch  = "fetch"
+)
+
+var (
+	ErrUnknownCommand  = fmt.Errorf("unknown command")
+	ErrInvalidArgument = fmt.Errorf("invalid argument")
+	ErrUnknownObject   = fmt.Errorf("unknown object")
+)
+
+// isProtocolV2 reports whether the client asked for protocol version
+// 2, the header holds colon separated parameters.
+func isProtocolV2(req *http.Request) bool {
+	for _, param := range strings.Split(req.Header.Get(gitProtocolHeader), ":") {
+		if param == protocolVersion2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeV2Advertisement writes the capability advertisement which
+// replaces the reference advertisement in protocol version 2.
+func writeV2Advertisement(w io.Writer) error {
+	enc := pktline.NewEncoder(w)
+
+	err := enc.EncodeString(
+		"version 2\n",
+		fmt.Sprintf("%s=%s\n", capability.Agent, capability.DefaultAgent()),
+		commandLsRefs+"\n",
+		fmt.Sprintf("%s=%s %s\n", commandFetch, capability.Shallow, capability.Filter),
+		"object-format=sha1\n",
+	)
+	if err != nil {
+		return fmt.Errorf("encode capabilities: %w", err)
+	}
+
+	return enc.Flush()
+}
+
+// commandRequest is a protocol version 2 command request, the
+// capabilities precede a delimiter packet followed by the command
+// arguments.
+type commandRequest struct {
+	command      string
+	capabilities []string
+	args         []string
+}
+
+func decodeCommandRequest(r io.Reader) (*commandRequest, error) {
+	cmdReq := &commandRequest{
+		command:      "",
+		capabilities: []string{},
+		args:         []string{},
+	}
+
+	inArgs := false
+
+	for {
+		kind, payload, err := readPktLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case pktFlush:
+			if cmdReq.command == "" {
+				return nil, ErrUnknownCommand
+			}
+
+			return cmdReq, nil
+		case pktDelim:
+			inArgs = true
+
+			continue
+		case pktResponseEnd:
+			return nil, fmt.Errorf("unexpected response end: %w", ErrInvalidPktLine)
+		case pktData:
+		}
+
+		line := string(payload)
+
+		switch {
+		case inArgs:
+			cmdReq.args = append(cmdReq.args, line)
+		case strings.HasPrefix(line, "command="):
+			cmdReq.command = strings.TrimPrefix(line, "command=")
+		default:
+			cmdReq.capabilities = append(cmdReq.capabilities, line)
+		}
+	}
+}
+
+// serveUploadPackV2 answers a protocol version 2 command sent to the
+// git-upload-pack end point.
+func (s *Server) serveUploadPackV2(respWriter http.ResponseWriter, req *http.Request, repo *git.Repository) {
+	_, decode := s.startSpan(req.Context(), spanDecode)
+	cmdReq, err := decodeCommandRequest(req.Body)
+	decode.End()
+
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	switch cmdReq.command {
+	case commandLsRefs:
+		s.lsRefs(respWriter, repo, cmdReq.args)
+	case commandFetch:
+		s.fetch(req.Context(), respWriter, repo, cmdReq.args)
+	default:
+		http.Error(respWriter, fmt.Sprintf("%s: %s", ErrUnknownCommand, cmdReq.command), http.StatusBadRequest)
+	}
+}
+
+type lsRefsArgs struct {
+	symrefs  bool
+	peel     bool
+	prefixes []string
+}
+
+func parseLsRefsArgs(args []string) lsRefsArgs {
+	parsed := lsRefsArgs{
+		symrefs:  false,
+		peel:     false,
+		prefixes: []string{},
+	}
+
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			parsed.symrefs = true
+		case arg == "peel":
+			parsed.peel = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			parsed.prefixes = append(parsed.prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+
+	return parsed
+}
+
+func (a lsRefsArgs) matches(name string) bool {
+	if len(a.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lsRefs lists the references of the repository, HEAD first.
+func (s *Server) lsRefs(respWriter http.ResponseWriter, repo *git.Repository, rawArgs []string) {
+	args := parseLsRefsArgs(rawArgs)
+
+	refs, err := sortedReferences(repo.Storer)
+	if err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	var buf bytes.Buffer
+
+	enc := pktline.NewEncoder(&buf)
+
+	for _, ref := range refs {
+		if !args.matches(ref.Name().String()) {
+			continue
+		}
+
+		line, err := lsRefsLine(repo, ref, args)
+		if err != nil {
+			internalErr(respWriter, err)
+
+			return
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if err := enc.EncodeString(line + "\n"); err != nil {
+			internalErr(respWriter, err)
+
+			return
+		}
+	}
+
+	if err := enc.Flush(); err != nil {
+		internalErr(respWriter, err)
+
+		return
+	}
+
+	writeResultHeader(respWriter, transport.UploadPackServiceName)
+
+	_, _ = buf.WriteTo(respWriter)
+}
+
+// sortedReferences returns all references of the storer sorted by
+// name, with HEAD first.
+func sortedReferences(st storer.ReferenceStorer) ([]*plumbing.Reference, error) {
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("iter references: %w", err)
+	}
+
+	refs := []*plumbing.Reference{}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		refs = append(refs, ref)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iter foreach: %w", err)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Name() == plumbing.HEAD {
+			return true
+		}
+
+		if refs[j].Name() == plumbing.HEAD {
+			return false
+		}
+
+		return refs[i].Name() < refs[j].Name()
+	})
+
+	return refs, nil
+}
+
+// lsRefsLine formats a single ls-refs reference line, it returns an
+// empty line for symbolic references which cannot be resolved.
+func lsRefsLine(repo *git.Repository, ref *plumbing.Reference, args lsRefsArgs) (string, error) {
+	resolved, err := storer.ResolveReference(repo.Storer, ref.Name())
+	if err == plumbing.ErrReferenceNotFound { //nolint:errorlint // go-git returns the sentinel as is
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", ref.Name(), err)
+	}
+
+	line := fmt.Sprintf("%s %s", resolved.Hash(), ref.Name())
+
+	if args.symrefs && ref.Type() == plumbing.SymbolicReference {
+		line += fmt.Sprintf(" symref-target:%s", ref.Target())
+	}
+
+	if args.peel {
+		if peeled, ok := peelTag(repo, resolved.Hash()); ok {
+			line += fmt.Sprintf(" peeled:%s", peeled)
+		}
+	}
+
+	return line, nil
+}
+
+// peelTag returns the object an annotated tag eventually points to.
+func peelTag(repo *git.Repository, hash plumbing.Hash) (plumbing.Hash, bool) {
+	peeled := false
+
+	for {
+		tag, err := repo.TagObject(hash)
+		if err != nil {
+			return hash, peeled
+		}
+
+		hash = tag.Target
+		peeled = true
+	}
+}
+
+type fetchArgs struct {
+	wants      []plumbing.Hash
+	haves      []plumbing.Hash
+	done       bool
+	noProgress bool
+	ofsDelta   bool
+	filter     string
+	shallows   []plumbing.Hash
+	deepen     *deepenRequest
+}
+
+func parseFetchArgs(args []string) (*fetchArgs, error) {
+	parsed := &fetchArgs{
+		wants:      []plumbing.Hash{},
+		haves:      []plumbing.Hash{},
+		done:       false,
+		noProgress: false,
+		ofsDelta:   false,
+		filter:     "",
+		shallows:   []plumbing.Hash{},
+		deepen:     &deepenRequest{depth: 0, since: time.Time{}, not: nil, relative: false},
+	}
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			parsed.wants = append(parsed.wants, plumbing.NewHash(strings.TrimPrefix(arg, "want ")))
+		case strings.HasPrefix(arg, "have "):
+			parsed.haves = append(parsed.haves, plumbing.NewHash(strings.TrimPrefix(arg, "have ")))
+		case arg == "done":
+			parsed.done = true
+		case strings.HasPrefix(arg, "filter "):
+			parsed.filter = strings.TrimPrefix(arg, "filter ")
+		case arg == "no-progress":
+			parsed.noProgress = true
+		case strings.HasPrefix(arg, "shallow "):
+			parsed.shallows = append(parsed.shallows, plumbing.NewHash(strings.TrimPrefix(arg, "shallow ")))
+		case strings.HasPrefix(arg, "deepen "), strings.HasPrefix(arg, "deepen-since "):
+			name, value, _ := strings.Cut(arg, " ")
+
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%q: %w", arg, ErrInvalidArgument)
+			}
+
+			if name == "deepen" {
+				parsed.deepen.depth = int(n)
+			} else {
+				parsed.deepen.since = time.Unix(n, 0)
+			}
+		case strings.HasPrefix(arg, "deepen-not "):
+			parsed.deepen.not = append(parsed.deepen.not, strings.TrimPrefix(arg, "deepen-not "))
+		case arg == "deepen-relative":
+			parsed.deepen.relative = true
+		case arg == "ofs-delta":
+			parsed.ofsDelta = true
+		case arg == "thin-pack", arg == "include-tag":
+			// the pack never contains deltas against objects
+			// outside of it, hence these are accepted but have no
+			// effect.
+		default:
+			return nil, fmt.Errorf("%q: %w", arg, ErrInvalidArgument)
+		}
+	}
+
+	return parsed, nil
+}
+
+// fetch negotiates the common objects with the client and sends the
+// packfile once the client is done or a common base was found.
+func (s *Server) fetch(ctx context.Context, respWriter http.ResponseWriter, repo *git.Repository, rawArgs []string) {
+	args, err := parseFetchArgs(rawArgs)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	setRequestAttributes(ctx, attrWants.Int(len(args.wants)), attrHaves.Int(len(args.haves)))
+
+	for _, want := range args.wants {
+		if err := repo.Storer.HasEncodedObject(want); err != nil {
+			http.Error(respWriter, fmt.Sprintf("%s: %s", ErrUnknownObject, want), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	filter, err := parseFilter(repo, args.filter)
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	spec := &packSpec{
+		wants:          args.wants,
+		haves:          args.haves,
+		filter:         filter,
+		clientShallows: args.shallows,
+		shallows:       nil,
+	}
+
+	_, negotiate := s.startSpan(ctx, spanNegotiate)
+	shallowUpdate, err := deepen(repo, spec, args.deepen)
+	common := commonObjects(repo.Storer, args.haves)
+	ready := readyToPack(repo.Storer, args.wants, common)
+	negotiate.End()
+
+	if err != nil {
+		http.Error(respWriter, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	writeResultHeader(respWriter, transport.UploadPackServiceName)
+
+	enc := pktline.NewEncoder(respWriter)
+
+	if !args.done {
+		if err := writeAcknowledgments(respWriter, common, ready); err != nil {
+			return
+		}
+
+		if !ready {
+			// keep negotiating until the client sends done
+			_ = enc.Flush()
+
+			return
+		}
+	}
+
+	_, pack := s.startSpan(ctx, spanPack)
+	objs, err := objectsToPack(repo.Storer, spec)
+	pack.SetAttributes(attrPackObjects.Int(len(objs)))
+	pack.End()
+
+	if err != nil {
+		_ = enc.EncodeString(fmt.Sprintf("ERR %s\n", err))
+
+		return
+	}
+
+	if shallowUpdate != nil {
+		if err := writeShallowInfo(respWriter, shallowUpdate); err != nil {
+			return
+		}
+	}
+
+	if err := enc.EncodeString("packfile\n"); err != nil {
+		return
+	}
+
+	mux := sideband.NewMuxer(sideband.Sideband64k, respWriter)
+
+	s.sendPack(ctx, mux, mux, newProgressWriter(mux, !args.noProgress), repo.Storer, objs, !args.ofsDelta)
+
+	_ = enc.Flush()
+}
+
+// writeAcknowledgments writes the acknowledgments section, it is
+// followed by the packfile section once the negotiation is ready.
+func writeAcknowledgments(w io.Writer, common []plumbing.Hash, ready bool) error {
+	enc := pktline.NewEncoder(w)
+
+	if err := enc.EncodeString("acknowledgments\n"); err != nil {
+		return fmt.Errorf("encode acknowledgments: %w", err)
+	}
+
+	if len(common) == 0 {
+		return enc.EncodeString("NAK\n")
+	}
+
+	for _, hash := range common {
+		if err := enc.Encodef("ACK %s\n", hash); err != nil {
+			return fmt.Errorf("encode ACK: %w", err)
+		}
+	}
+
+	if !ready {
+		return nil
+	}
+
+	if err := enc.EncodeString("ready\n"); err != nil {
+		return fmt.Errorf("encode ready: %w", err)
+	}
+
+	if _, err := io.WriteString(w, delimPkt); err != nil {
+		return fmt.Errorf("write delim: %w", err)
+	}
+
+	return nil
+}
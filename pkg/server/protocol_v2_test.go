@@ -0,0 +1,277 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfoRefsProtocolV2Advertisement(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/info/refs?service=git-upload-pack", srv.URL()), nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Git-Protocol", "version=2")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	lines := readPktLines(t, resp.Body)
+	require.Equal(t, "version 2", lines[0])
+	require.Contains(t, lines, "ls-refs")
+	require.Contains(t, lines, "fetch=shallow filter")
+}
+
+func TestLsRefsWithRefPrefix(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	err = testRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", head.Hash()))
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := postCommandV2(t, srv.URL(), "ls-refs", "symrefs")
+	defer resp.Body.Close()
+
+	require.Equal(t, []string{
+		fmt.Sprintf("%s HEAD symref-target:refs/heads/master", head.Hash()),
+		fmt.Sprintf("%s refs/heads/feature", head.Hash()),
+		fmt.Sprintf("%s refs/heads/master", head.Hash()),
+	}, readPktLines(t, resp.Body))
+
+	resp = postCommandV2(t, srv.URL(), "ls-refs", "ref-prefix refs/heads/feat")
+	defer resp.Body.Close()
+
+	require.Equal(t, []string{
+		fmt.Sprintf("%s refs/heads/feature", head.Hash()),
+	}, readPktLines(t, resp.Body))
+}
+
+func TestFetchProtocolV2(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := postCommandV2(t, srv.URL(), "fetch", "want "+head.Hash().String(), "done")
+	defer resp.Body.Close()
+
+	scanner := pktline.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "packfile\n", string(scanner.Bytes()))
+
+	demuxer := sideband.NewDemuxer(sideband.Sideband64k, resp.Body)
+
+	var pack bytes.Buffer
+
+	_, err = io.Copy(&pack, demuxer)
+	require.NoError(t, err)
+
+	// commit, tree and blob
+	_, objects, err := packfile.NewScanner(&pack).Header()
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), objects)
+}
+
+//nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
+func TestFetchDeltaEncoding(t *testing.T) {
+	t.Parallel()
+
+	// the second version of the file is stored as a delta of the first.
+	text := strings.Repeat("all work and no play makes jack a dull boy\n", 100)
+
+	testRepo := repoWithInitCommit(t, filename, text)
+	commitFile(t, testRepo, filename, text+"the end\n", "second commit")
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	tests := map[string]struct {
+		args  []string
+		delta plumbing.ObjectType
+	}{
+		"ofs-delta":    {args: []string{"ofs-delta"}, delta: plumbing.OFSDeltaObject},
+		"no ofs-delta": {args: []string{}, delta: plumbing.REFDeltaObject},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			args := append([]string{"want " + head.Hash().String(), "done"}, test.args...)
+
+			resp := postCommandV2(t, srv.URL(), "fetch", args...)
+			defer resp.Body.Close()
+
+			scanner := pktline.NewScanner(resp.Body)
+			require.True(t, scanner.Scan())
+			require.Equal(t, "packfile\n", string(scanner.Bytes()))
+
+			packScanner := packfile.NewScanner(sideband.NewDemuxer(sideband.Sideband64k, resp.Body))
+
+			_, objects, err := packScanner.Header()
+			require.NoError(t, err)
+
+			deltas := map[plumbing.ObjectType]int{}
+
+			for i := uint32(0); i < objects; i++ {
+				header, err := packScanner.NextObjectHeader()
+				require.NoError(t, err)
+
+				if header.Type.IsDelta() {
+					deltas[header.Type]++
+				}
+
+				_, _, err = packScanner.NextObject(io.Discard)
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, map[plumbing.ObjectType]int{test.delta: 1}, deltas)
+		})
+	}
+}
+
+func TestFetchNegotiationNotReady(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	first, err := testRepo.Head()
+	require.NoError(t, err)
+
+	// the have is a child of the want, not a base to pack it against.
+	second := commitFile(t, testRepo, filename, content+"again\n", "second commit")
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := postCommandV2(t, srv.URL(), "fetch", "want "+first.Hash().String(), "have "+second.String())
+	defer resp.Body.Close()
+
+	require.Equal(t, []string{
+		"acknowledgments",
+		"ACK " + second.String(),
+	}, readPktLines(t, resp.Body))
+}
+
+func TestCloneWithGitCLIProtocolV2(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+	runGit(t, dir, "2", "clone", srv.URL(), ".")
+
+	actual, err := os.ReadFile(filepath.Join(dir, filename))
+	require.NoError(t, err)
+	require.Equal(t, content, string(actual))
+}
+
+func postCommandV2(t *testing.T, url, command string, args ...string) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	enc := pktline.NewEncoder(&body)
+	require.NoError(t, enc.EncodeString(fmt.Sprintf("command=%s\n", command), "object-format=sha1\n"))
+
+	body.WriteString("0001")
+
+	for _, arg := range args {
+		require.NoError(t, enc.EncodeString(arg+"\n"))
+	}
+
+	require.NoError(t, enc.Flush())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/git-upload-pack", url), &body)
+	require.NoError(t, err)
+
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Git-Protocol", "version=2")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	return resp
+}
+
+// readPktLines reads pkt-lines up to the first flush packet, trailing
+// line feeds are removed.
+func readPktLines(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	lines := []string{}
+	scanner := pktline.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			break
+		}
+
+		lines = append(lines, strings.TrimSuffix(string(line), "\n"))
+	}
+
+	require.NoError(t, scanner.Err())
+
+	return lines
+}
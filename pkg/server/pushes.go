@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// PushCommand is a reference update of a recorded push.
+type PushCommand struct {
+	Name plumbing.ReferenceName
+	Old  plumbing.Hash
+	New  plumbing.Hash
+	// Err is the reason the update was rejected, nil if it was
+	// applied.
+	Err error
+}
+
+// PushEvent is a push received by the Server.
+type PushEvent struct {
+	Principal Principal
+	RepoPath  string
+	Time      time.Time
+	Commands  []PushCommand
+	// Commits are the commits the push introduced to the repository,
+	// newest first.
+	Commits []*object.Commit
+	Options []*packp.Option
+	// Err is the error unpacking the received objects, if any.
+	Err error
+}
+
+// Succeeded reports whether every reference update of the push was
+// applied.
+func (e PushEvent) Succeeded() bool {
+	if e.Err != nil {
+		return false
+	}
+
+	for _, cmd := range e.Commands {
+		if cmd.Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Command returns the command updating the reference, if any.
+func (e PushEvent) Command(name plumbing.ReferenceName) (PushCommand, bool) {
+	for _, cmd := range e.Commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+
+	return PushCommand{}, false
+}
+
+// PushFilter selects push events.
+type PushFilter func(PushEvent) bool
+
+// PushedTo selects pushes updating the reference.
+func PushedTo(name plumbing.ReferenceName) PushFilter {
+	return func(e PushEvent) bool {
+		_, ok := e.Command(name)
+
+		return ok
+	}
+}
+
+// PushedBy selects pushes of the principal.
+func PushedBy(principal string) PushFilter {
+	return func(e PushEvent) bool {
+		return e.Principal.Name == principal
+	}
+}
+
+// PushedToRepo selects pushes to the repository path, e.g.
+// owner/name.git.
+func PushedToRepo(repoPath string) PushFilter {
+	return func(e PushEvent) bool {
+		return e.RepoPath == repoPath
+	}
+}
+
+// PushRecorder holds the history of pushes received by a Server. It
+// is safe for concurrent use.
+type PushRecorder struct {
+	mu      sync.Mutex
+	events  []PushEvent
+	limit   int
+	changed chan struct{}
+}
+
+// NewPushRecorder returns a recorder keeping the last limit events, a
+// limit of 0 keeps every event.
+func NewPushRecorder(limit int) *PushRecorder {
+	return &PushRecorder{
+		mu:      sync.Mutex{},
+		events:  []PushEvent{},
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// WithPushHistoryLimit bounds the number of push events the Server
+// keeps, by default every push is kept.
+func WithPushHistoryLimit(limit int) Option {
+	return func(s *Server) {
+		s.pushes = NewPushRecorder(limit)
+	}
+}
+
+// Pushes returns the history of pushes received by the Server.
+func (s *Server) Pushes() *PushRecorder {
+	return s.pushes
+}
+
+// Events returns every recorded push, oldest first.
+func (r *PushRecorder) Events() []PushEvent {
+	return r.Filter()
+}
+
+// Filter returns the recorded pushes selected by every filter, oldest
+// first.
+func (r *PushRecorder) Filter(filters ...PushFilter) []PushEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return filterEvents(r.events, filters)
+}
+
+// WaitFor blocks until a push selected by every filter was recorded,
+// including those recorded before the call, and returns the first one.
+func (r *PushRecorder) WaitFor(ctx context.Context, filters ...PushFilter) (PushEvent, error) {
+	for {
+		r.mu.Lock()
+		events := filterEvents(r.events, filters)
+		changed := r.changed
+		r.mu.Unlock()
+
+		if len(events) > 0 {
+			return events[0], nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return PushEvent{}, fmt.Errorf("wait for push: %w", ctx.Err())
+		}
+	}
+}
+
+// Clear removes every recorded push.
+func (r *PushRecorder) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = []PushEvent{}
+}
+
+func (r *PushRecorder) record(event PushEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+	if r.limit > 0 && len(r.events) > r.limit {
+		r.events = r.events[len(r.events)-r.limit:]
+	}
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func filterEvents(events []PushEvent, filters []PushFilter) []PushEvent {
+	selected := []PushEvent{}
+
+next:
+	for _, event := range events {
+		for _, filter := range filters {
+			if !filter(event) {
+				continue next
+			}
+		}
+
+		selected = append(selected, event)
+	}
+
+	return selected
+}
+
+// newPushEvent builds the event of a push once the reference updates
+// were applied.
+func newPushEvent(
+	push *Push, refReq *packp.ReferenceUpdateRequest, statuses map[*packp.Command]error, unpackErr error,
+) PushEvent {
+	event := PushEvent{
+		Principal: push.Principal,
+		RepoPath:  push.RepoPath,
+		Time:      time.Now(),
+		Commands:  make([]PushCommand, 0, len(refReq.Commands)),
+		Commits:   []*object.Commit{},
+		Options:   refReq.Options,
+		Err:       unpackErr,
+	}
+
+	for _, cmd := range refReq.Commands {
+		err, ok := statuses[cmd]
+		if !ok {
+			err = unpackErr
+		}
+
+		event.Commands = append(event.Commands, PushCommand{Name: cmd.Name, Old: cmd.Old, New: cmd.New, Err: err})
+	}
+
+	if unpackErr == nil {
+		// a failure to list the commits leaves them empty, the push
+		// itself is recorded regardless.
+		event.Commits, _ = pushedCommits(push.Repository, event.Commands)
+	}
+
+	return event
+}
+
+// pushedCommits returns the commits reachable from the applied updates
+// which were neither reachable from the previous values of the updated
+// references nor from any other reference.
+func pushedCommits(repo *git.Repository, cmds []PushCommand) ([]*object.Commit, error) {
+	updated := map[plumbing.ReferenceName]bool{}
+	known := []plumbing.Hash{}
+
+	for _, cmd := range cmds {
+		if cmd.Err != nil {
+			continue
+		}
+
+		updated[cmd.Name] = true
+
+		if !cmd.Old.IsZero() {
+			known = append(known, cmd.Old)
+		}
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("references: %w", err)
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && !updated[ref.Name()] {
+			known = append(known, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iter references: %w", err)
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	for _, hash := range known {
+		if err := walkCommits(repo, hash, seen, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	commits := []*object.Commit{}
+
+	for _, cmd := range cmds {
+		if cmd.Err != nil || cmd.New.IsZero() {
+			continue
+		}
+
+		err := walkCommits(repo, cmd.New, seen, func(c *object.Commit) {
+			commits = append(commits, c)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return commits, nil
+}
+
+// walkCommits visits the commits reachable from hash which were not
+// seen before, marking them as seen. Non commit objects are ignored.
+func walkCommits(
+	repo *git.Repository, hash plumbing.Hash, seen map[plumbing.Hash]bool, visit func(*object.Commit),
+) error {
+	if seen[hash] {
+		return nil
+	}
+
+	start, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil //nolint:nilerr // tags of trees and blobs have no history
+	}
+
+	iter := object.NewCommitPreorderIter(start, seen, nil)
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+
+		if visit != nil {
+			visit(c)
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return fmt.Errorf("walk commits: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushesAreRecorded(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithHooks(server.Hooks{
+		PreReceive: func(ctx context.Context, push *server.Push) error {
+			if push.Commands[0].Name == "refs/heads/protected" {
+				return errDeclined
+			}
+
+			return nil
+		},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "release 1", "first release commit")
+	second := commitFile(t, local, filename, "release 2", "second release commit")
+
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/release"))
+	require.Error(t, push(local, noAuth, "refs/heads/master:refs/heads/protected"))
+
+	events := srv.Pushes().Events()
+	require.Len(t, events, 2)
+
+	release := events[0]
+	require.True(t, release.Succeeded())
+	require.Equal(t, srv.Server.RepoPath(), release.RepoPath)
+	require.Equal(t, []server.PushCommand{
+		{Name: "refs/heads/release", Old: plumbing.ZeroHash, New: second, Err: nil},
+	}, release.Commands)
+	require.Len(t, release.Commits, 2)
+	require.Equal(t, "second release commit", release.Commits[0].Message)
+	require.Equal(t, "first release commit", release.Commits[1].Message)
+	require.NotEqual(t, head.Hash(), release.Commits[1].Hash)
+
+	protected := events[1]
+	require.False(t, protected.Succeeded())
+	require.Empty(t, protected.Commits)
+
+	require.Len(t, srv.Pushes().Filter(server.PushedTo("refs/heads/release")), 1)
+	require.Empty(t, srv.Pushes().Filter(server.PushedTo("refs/heads/release"), server.PushedBy("mallory")))
+
+	srv.Pushes().Clear()
+	require.Empty(t, srv.Pushes().Events())
+}
+
+func TestWaitForPush(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = srv.Pushes().WaitFor(ctx, server.PushedTo("refs/heads/master"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	hash := commitFile(t, local, filename, "pushed content", "second commit")
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- push(local, noAuth, "refs/heads/master:refs/heads/master")
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event, err := srv.Pushes().WaitFor(ctx, server.PushedTo("refs/heads/master"))
+	require.NoError(t, err)
+	require.NoError(t, <-errs)
+
+	cmd, ok := event.Command("refs/heads/master")
+	require.True(t, ok)
+	require.Equal(t, hash, cmd.New)
+	require.Len(t, event.Commits, 1)
+}
+
+func TestPushHistoryLimit(t *testing.T) {
+	t.Parallel()
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithPushHistoryLimit(1))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/one"))
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/two"))
+
+	events := srv.Pushes().Events()
+	require.Len(t, events, 1)
+
+	_, ok := events[0].Command("refs/heads/two")
+	require.True(t, ok)
+}
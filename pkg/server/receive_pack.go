@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const packHeaderSize = 12
+
+var (
+	ErrRefExists      = fmt.Errorf("reference already exists")
+	ErrStaleRef       = fmt.Errorf("stale info")
+	ErrMissingObjects = fmt.Errorf("missing necessary objects")
+	ErrAtomicFailed   = fmt.Errorf("atomic transaction failed")
+)
+
+// decodeReceivePackRequest decodes the reference update request, the
+// push options precede the packfile when the client asked for them.
+func decodeReceivePackRequest(r io.Reader) (*packp.ReferenceUpdateRequest, error) {
+	refReq := packp.NewReferenceUpdateRequest()
+
+	if err := refReq.Decode(r); err != nil {
+		return nil, fmt.Errorf("decode reference update request: %w", err)
+	}
+
+	if !refReq.Capabilities.Supports(capability.PushOptions) {
+		return refReq, nil
+	}
+
+	for {
+		kind, payload, err := readPktLine(refReq.Packfile)
+		if err != nil {
+			return nil, fmt.Errorf("decode push options: %w", err)
+		}
+
+		if kind == pktFlush {
+			return refReq, nil
+		}
+
+		refReq.Options = append(refReq.Options, parsePushOption(string(payload)))
+	}
+}
+
+func parsePushOption(raw string) *packp.Option {
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '=' {
+			return &packp.Option{Key: raw[:i], Value: raw[i+1:]}
+		}
+	}
+
+	return &packp.Option{Key: raw, Value: ""}
+}
+
+// receivePack applies the reference update request to the repository
+// of the push, running the hooks along the way. It returns the status
+// of every command, the returned error is the unpack error, if any.
+func (s *Server) receivePack(
+	ctx context.Context, push *Push, refReq *packp.ReferenceUpdateRequest,
+) (map[*packp.Command]error, error) {
+	st := push.Repository.Storer
+
+	_, span := s.startSpan(ctx, spanUnpack)
+	objects, err := unpack(st, refReq)
+	span.SetAttributes(attrPackObjects.Int(objects))
+	span.End()
+
+	if err != nil {
+		return nil, err
+	}
+
+	observe(ctx).receivedObjects(objects)
+
+	statuses := make(map[*packp.Command]error, len(refReq.Commands))
+
+	for _, cmd := range refReq.Commands {
+		statuses[cmd] = validateCommand(st, cmd)
+		if statuses[cmd] == nil {
+			statuses[cmd] = s.protect(push, cmd)
+		}
+	}
+
+	push.Commands = succeeded(refReq.Commands, statuses)
+
+	if err := s.preReceive(ctx, push); err != nil {
+		for _, cmd := range push.Commands {
+			statuses[cmd] = err
+		}
+	}
+
+	for _, cmd := range succeeded(refReq.Commands, statuses) {
+		statuses[cmd] = s.update(ctx, push, cmd)
+	}
+
+	if refReq.Capabilities.Supports(capability.Atomic) && anyFailed(statuses) {
+		for cmd, err := range statuses {
+			if err == nil {
+				statuses[cmd] = ErrAtomicFailed
+			}
+		}
+
+		return statuses, nil
+	}
+
+	for _, cmd := range succeeded(refReq.Commands, statuses) {
+		statuses[cmd] = applyCommand(st, cmd)
+	}
+
+	push.Commands = succeeded(refReq.Commands, statuses)
+	if len(push.Commands) > 0 {
+		s.postReceive(ctx, push)
+	}
+
+	observe(ctx).updatedRefs(len(push.Commands))
+
+	return statuses, nil
+}
+
+// succeeded returns the commands without a failed status, in request
+// order.
+func succeeded(cmds []*packp.Command, statuses map[*packp.Command]error) []*packp.Command {
+	ok := make([]*packp.Command, 0, len(cmds))
+
+	for _, cmd := range cmds {
+		if statuses[cmd] == nil {
+			ok = append(ok, cmd)
+		}
+	}
+
+	return ok
+}
+
+// unpack writes the received packfile to the storer, deletions are
+// sent without a packfile.
+func unpack(st storer.Storer, refReq *packp.ReferenceUpdateRequest) (int, error) {
+	if refReq.Packfile == nil {
+		return 0, nil
+	}
+
+	packReader := bufio.NewReader(refReq.Packfile)
+	if _, err := packReader.Peek(1); errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+
+	// the pack header is the signature, the version and the number of
+	// objects, 4 bytes each.
+	objects := 0
+	if header, err := packReader.Peek(packHeaderSize); err == nil {
+		objects = int(binary.BigEndian.Uint32(header[8:]))
+	}
+
+	if err := packfile.UpdateObjectStorage(st, packReader); err != nil {
+		return 0, fmt.Errorf("unpack: %w", err)
+	}
+
+	return objects, nil
+}
+
+func anyFailed(statuses map[*packp.Command]error) bool {
+	for _, err := range statuses {
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateCommand checks the command against the current state of the
+// reference and the objects received.
+func validateCommand(st storer.Storer, cmd *packp.Command) error {
+	current, err := st.Reference(cmd.Name)
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return fmt.Errorf("reference: %w", err)
+	}
+
+	exists := err == nil
+
+	switch cmd.Action() {
+	case packp.Create:
+		if exists {
+			return ErrRefExists
+		}
+	case packp.Delete, packp.Update:
+		if !exists || current.Hash() != cmd.Old {
+			return ErrStaleRef
+		}
+	}
+
+	if cmd.Action() != packp.Delete && st.HasEncodedObject(cmd.New) != nil {
+		return ErrMissingObjects
+	}
+
+	return nil
+}
+
+func applyCommand(st storer.Storer, cmd *packp.Command) error {
+	switch cmd.Action() {
+	case packp.Create:
+		return st.SetReference(plumbing.NewHashReference(cmd.Name, cmd.New))
+	case packp.Delete:
+		return st.RemoveReference(cmd.Name)
+	case packp.Update:
+		return st.CheckAndSetReference(
+			plumbing.NewHashReference(cmd.Name, cmd.New),
+			plumbing.NewHashReference(cmd.Name, cmd.Old),
+		)
+	}
+
+	return nil
+}
+
+// reportStatus builds the report-status response in request order.
+func reportStatus(cmds []*packp.Command, statuses map[*packp.Command]error, unpackErr error) *packp.ReportStatus {
+	report := packp.NewReportStatus()
+	report.UnpackStatus = "ok"
+
+	if unpackErr != nil {
+		report.UnpackStatus = unpackErr.Error()
+	}
+
+	for _, cmd := range cmds {
+		status := "ok"
+
+		switch err, ok := statuses[cmd]; {
+		case !ok:
+			status = "unpacker error"
+		case err != nil:
+			status = err.Error()
+		}
+
+		report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        status,
+		})
+	}
+
+	return report
+}
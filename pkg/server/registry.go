@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	ErrRepoNotFound = fmt.Errorf("repository not found")
+	ErrRepoExists   = fmt.Errorf("repository already registered")
+)
+
+// Registry maps repository paths, in the form of owner/name.git, to
+// Git repositories. It allows a single Server to host many
+// repositories and is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	repos map[string]*git.Repository
+}
+
+// NewRegistry returns an empty repository registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:    sync.RWMutex{},
+		repos: map[string]*git.Repository{},
+	}
+}
+
+// Add registers repo under owner and repoName and returns the
+// repository path it is served under. Owner and repoName are
+// normalised to lower case.
+func (r *Registry) Add(owner, repoName string, repo *git.Repository) (string, error) {
+	if repo == nil {
+		return "", ErrRepoUninitialized
+	}
+
+	if owner == "" {
+		return "", ErrOwnerMissing
+	}
+
+	if repoName == "" {
+		return "", ErrRepoNameMissing
+	}
+
+	_, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", fmt.Errorf("git reference: %w", err)
+	}
+
+	repoPath := RepoPath(owner, repoName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.repos[repoPath]; ok {
+		return "", fmt.Errorf("%s: %w", repoPath, ErrRepoExists)
+	}
+
+	r.repos[repoPath] = repo
+
+	return repoPath, nil
+}
+
+// Remove unregisters the repository of owner and repoName, it is a
+// no-op if the repository is unknown.
+func (r *Registry) Remove(owner, repoName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.repos, RepoPath(owner, repoName))
+}
+
+// Lookup returns the repository registered under repoPath. A leading
+// slash is ignored and the lookup is case insensitive.
+func (r *Registry) Lookup(repoPath string) (*git.Repository, error) {
+	if r == nil {
+		return nil, ErrRepoUninitialized
+	}
+
+	key := normaliseRepoPath(repoPath)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	repo, ok := r.repos[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrRepoNotFound)
+	}
+
+	return repo, nil
+}
+
+// Paths returns the sorted paths of all registered repositories.
+func (r *Registry) Paths() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	paths := make([]string, 0, len(r.repos))
+	for p := range r.repos {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// RepoPath returns the normalised repository path for owner and
+// repoName, e.g. owner/name.git.
+func RepoPath(owner, repoName string) string {
+	return path.Join(strings.ToLower(owner), fmt.Sprintf("%s.git", strings.ToLower(repoName)))
+}
+
+// normaliseRepoPath returns repoPath in the form of RepoPath, without a
+// leading slash and in lower case.
+func normaliseRepoPath(repoPath string) string {
+	return strings.ToLower(strings.TrimPrefix(repoPath, "/"))
+}
+
+// splitRepoPath splits an URL path into the repository path and the
+// remainder following it. The repository path is identified by the
+// first segment ending in .git, together with its preceding owner
+// segment, which allows the routes to be mounted under a prefix.
+func splitRepoPath(urlPath string) (string, string, bool) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	for i := 1; i < len(segments); i++ {
+		if !strings.HasSuffix(segments[i], ".git") {
+			continue
+		}
+
+		repoPath := strings.ToLower(path.Join(segments[i-1], segments[i]))
+		rest := strings.Join(segments[i+1:], "/")
+
+		return repoPath, rest, true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAddAndLookup(t *testing.T) {
+	t.Parallel()
+
+	reg := server.NewRegistry()
+	repo := repoWithInitCommit(t, filename, content)
+
+	repoPath, err := reg.Add("Bob", "Shed", repo)
+	require.NoError(t, err)
+	require.Equal(t, "bob/shed.git", repoPath)
+
+	actual, err := reg.Lookup("/BOB/shed.git")
+	require.NoError(t, err)
+	require.Equal(t, repo, actual)
+
+	_, err = reg.Add("bob", "shed", repo)
+	require.ErrorIs(t, err, server.ErrRepoExists)
+
+	reg.Remove("bob", "shed")
+
+	_, err = reg.Lookup(repoPath)
+	require.ErrorIs(t, err, server.ErrRepoNotFound)
+}
+
+func TestCloneManyReposFromRegistry(t *testing.T) {
+	t.Parallel()
+
+	reg := server.NewRegistry()
+
+	for i := 0; i < 3; i++ {
+		_, err := reg.Add(owner, fmt.Sprintf("repo%d", i), repoWithInitCommit(t, filename, fmt.Sprintf("content %d", i)))
+		require.NoError(t, err)
+	}
+
+	srv, err := server.NewHTTPTestWithRegistry(reg)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	// registered after the server started
+	_, err = reg.Add("alice", "late", repoWithInitCommit(t, filename, "late content"))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		a := newCloneAssert(t, srv.RepoURL(owner, fmt.Sprintf("repo%d", i)))
+		a.assert(filename, fmt.Sprintf("content %d", i))
+	}
+
+	a := newCloneAssert(t, srv.RepoURL("alice", "late"))
+	a.assert(filename, "late content")
+}
+
+func TestUnknownRepoIsNotFound(t *testing.T) {
+	t.Parallel()
+
+	reg := server.NewRegistry()
+	_, err := reg.Add(owner, repoName, repoWithInitCommit(t, filename, content))
+	require.NoError(t, err)
+
+	srv, err := server.NewWithRegistry(reg)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv.SetupRoutes(mux)
+
+	for name, handler := range map[string]http.Handler{"mux": mux, "server": srv} {
+		handler := handler
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(handler)
+			t.Cleanup(ts.Close)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			url := fmt.Sprintf("%s/%s/info/refs?service=git-upload-pack", ts.URL, server.RepoPath("nobody", "nothing"))
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+			a := newCloneAssert(t, fmt.Sprintf("%s/%s", ts.URL, server.RepoPath(owner, repoName)))
+			a.assert(filename, content)
+		})
+	}
+}
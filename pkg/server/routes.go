@@ -1,7 +1,9 @@
 package server
 
 import (
+	"net/http"
 	"path"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,39 +14,138 @@ const (
 	receivePack = "git-receive-pack"
 )
 
-func (s *Server) pathInfoRefs() string {
-	return path.Join("/", s.RepoPath(), infoRefs)
+// route is a Git HTTP endpoint relative to the repository path, a
+// prefix route also handles every path below it. A route without
+// method accepts any method, leaving the check to its handler.
+type route struct {
+	path    string
+	method  string
+	handler http.HandlerFunc
+	prefix  bool
 }
 
-func (s *Server) pathUploadPack() string {
-	return path.Join("/", s.RepoPath(), uploadPack)
+func (s *Server) routes() []route {
+	routes := []route{
+		{path: infoRefs, method: http.MethodGet, handler: s.GetInfoRefs, prefix: false},
+		{path: uploadPack, method: http.MethodPost, handler: s.GetUploadPack, prefix: false},
+		{path: receivePack, method: http.MethodPost, handler: s.GetReceivePack, prefix: false},
+		{path: archivePath, method: http.MethodGet, handler: s.GetArchive, prefix: true},
+		{path: rawPath, method: http.MethodGet, handler: s.GetRaw, prefix: true},
+	}
+
+	routes = append(routes, s.dumbRoutes()...)
+
+	return append(routes, s.lfsRoutes()...)
+}
+
+// pattern returns the route pattern for the repository, prefix routes
+// end in a slash as expected by http.ServeMux.
+func (rt route) pattern(repoPath string) string {
+	pattern := path.Join("/", repoPath, rt.path)
+	if rt.prefix {
+		pattern += "/"
+	}
+
+	return pattern
+}
+
+// ginPattern returns the route pattern for the repository in the gin
+// syntax, prefix routes end in a catch-all parameter.
+func (rt route) ginPattern(repoPath string) string {
+	pattern := path.Join("/", repoPath, rt.path)
+	if rt.prefix {
+		pattern += "/*rest"
+	}
+
+	return pattern
 }
 
-func (s *Server) pathReceivePack() string {
-	return path.Join("/", s.RepoPath(), receivePack)
+func (rt route) matches(rest string) bool {
+	if rt.prefix {
+		return strings.HasPrefix(rest, rt.path+"/")
+	}
+
+	return rt.path == rest
 }
 
 // SetupRoutes adds required Git HTTP handlers to provided request
-// multiplexer.
+// multiplexer for every repository in the registry, as well as the
+// GitHub API if enabled. Repositories added to the registry afterwards
+// are not routed, use the Server as a http.Handler to serve those.
 func (s *Server) SetupRoutes(r Router) {
-	r.HandleFunc(s.pathInfoRefs(), s.GetInfoRefs)
-	r.HandleFunc(s.pathUploadPack(), s.GetUploadPack)
-	r.HandleFunc(s.pathReceivePack(), s.GetReceivePack)
+	for _, repoPath := range s.registry.Paths() {
+		for _, rt := range s.routes() {
+			r.HandleFunc(rt.pattern(repoPath), rt.handler)
+		}
+
+		if s.github != nil {
+			r.HandleFunc(githubPath(repoPath), s.ServeGitHubAPI)
+			r.HandleFunc(githubPath(repoPath)+"/", s.ServeGitHubAPI)
+		}
+	}
 }
 
 // SetupGinRoutes adds required Git HTTP handlers to provided Gin
 // IRouter, as Gin has a different interface we will wrap it to make
 // the library easier to use for Gin users.
 func (s *Server) SetupGinRoutes(ginRouter gin.IRouter) {
-	ginRouter.GET(s.pathInfoRefs(), func(c *gin.Context) {
-		s.GetInfoRefs(c.Writer, c.Request)
-	})
+	for _, repoPath := range s.registry.Paths() {
+		for _, rt := range s.routes() {
+			handler := rt.handler
+			ginHandler := func(c *gin.Context) {
+				handler(c.Writer, c.Request)
+			}
+
+			if rt.method == "" {
+				ginRouter.Any(rt.ginPattern(repoPath), ginHandler)
+
+				continue
+			}
+
+			ginRouter.Handle(rt.method, rt.ginPattern(repoPath), ginHandler)
+		}
+
+		if s.github != nil {
+			ginHandler := func(c *gin.Context) {
+				s.ServeGitHubAPI(c.Writer, c.Request)
+			}
+
+			ginRouter.Any(githubPath(repoPath), ginHandler)
+			ginRouter.Any(githubPath(repoPath)+"/*rest", ginHandler)
+		}
+	}
+}
+
+// ServeHTTP dispatches the request to the Git HTTP handler of the
+// addressed repository. Unknown repositories and endpoints result in
+// 404 Not Found.
+func (s *Server) ServeHTTP(respWriter http.ResponseWriter, req *http.Request) {
+	if s.isGitHubRequest(req) {
+		s.ServeGitHubAPI(respWriter, req)
+
+		return
+	}
+
+	repoPath, rest, ok := splitRepoPath(req.URL.Path)
+	if !ok {
+		http.NotFound(respWriter, req)
+
+		return
+	}
+
+	if _, err := s.registry.Lookup(repoPath); err != nil {
+		http.Error(respWriter, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	for _, rt := range s.routes() {
+		if rt.matches(rest) {
+			rt.handler(respWriter, req)
 
-	ginRouter.POST(s.pathUploadPack(), func(c *gin.Context) {
-		s.GetUploadPack(c.Writer, c.Request)
-	})
+			return
+		}
+	}
 
-	ginRouter.POST(s.pathReceivePack(), func(c *gin.Context) {
-		s.GetReceivePack(c.Writer, c.Request)
-	})
+	http.NotFound(respWriter, req)
 }
@@ -1,12 +1,12 @@
 // Package server provides two server types: Server and
 // HTTPTestServer.
 //
-// Server represents the server side implementation of Git. It wraps a
-// git repository and the required sessions for responding to
-// git-upload-pack and git-receive-pack requests after discovering
-// available references. HTTPTestServer is an additional server layer
-// which provides a convenient way to use the library as a test
-// service over HTTP.
+// Server represents the server side implementation of Git. It wraps
+// one or more git repositories, held in a Registry, and creates the
+// required sessions for responding to git-upload-pack and
+// git-receive-pack requests after discovering available references.
+// HTTPTestServer is an additional server layer which provides a
+// convenient way to use the library as a test service over HTTP.
 //
 // You can use your own HTTP Server by passing your HTTP Request
 // multiplexer to `SetupRoutes` if your mux implements `server.Router`
@@ -17,15 +17,15 @@ package server
 
 import (
 	"fmt"
-	"path"
+	"log/slog"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	tsrv "github.com/go-git/go-git/v5/plumbing/transport/server"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -36,130 +36,182 @@ var (
 	ErrRepoUninitialized = fmt.Errorf("git repo not initialized")
 	ErrOwnerMissing      = fmt.Errorf("owner is empty")
 	ErrRepoNameMissing   = fmt.Errorf("repoName is empty")
-	ErrNilServer         = fmt.Errorf("server is nil")
 	ErrInvalidAuth       = fmt.Errorf("invalid auth")
+	ErrRegistryMissing   = fmt.Errorf("registry is nil")
 )
 
-// BasicAuth is used to carry authentication for the HTTP endpoints.
+// BasicAuth is used to carry authentication for the HTTP endpoints,
+// see WithAuthenticator for anything beyond a single user.
 type BasicAuth struct {
 	Username string
 	Password string
 }
 
-// Server holds the Git repository as well as the sessions for
-// git-upload-pack and git-receive-pack operations.
+// Server holds the registry of Git repositories it serves and creates
+// the sessions for git-upload-pack and git-receive-pack operations.
+//
+// Owner and RepoName are only set when the Server was created with New
+// for a single repository.
 type Server struct {
 	Owner    string
 	RepoName string
 
 	SessionTimeout time.Duration
-	basicAuth      BasicAuth
+	authenticator  Authenticator
+	authorizer     Authorizer
+
+	registry *Registry
+
+	dumbProtocol bool
+	lfs          *lfsServer
+	github       *githubAPI
 
-	repo *git.Repository
+	protections BranchProtections
+	hooks       []Hooks
+	pushes      *PushRecorder
+	webhooks    *webhooks
 
-	// upSession represents git-upload-pack
-	upSession transport.UploadPackSession
-	// rpSession represents git-receive-pack
-	rpSession transport.ReceivePackSession
+	metrics *metrics
+	tracer  trace.Tracer
+	logger  *slog.Logger
+
+	// optionErr is the first error an Option failed with, it is
+	// returned by NewWithRegistry.
+	optionErr error
 }
 
 type Option func(*Server)
 
+// New returns a Server serving a single repository under owner and
+// repoName.
 func New(repo *git.Repository, owner, repoName string, opts ...Option) (*Server, error) {
-	if repo == nil {
-		return nil, ErrRepoUninitialized
-	}
+	registry := NewRegistry()
 
-	if owner == "" {
-		return nil, ErrOwnerMissing
+	if _, err := registry.Add(owner, repoName, repo); err != nil {
+		return nil, err
 	}
 
-	if repoName == "" {
-		return nil, ErrRepoNameMissing
+	srv, err := NewWithRegistry(registry, opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := repo.Reference(plumbing.HEAD, false)
-	if err != nil {
-		return nil, fmt.Errorf("git reference: %w", err)
+	srv.Owner = strings.ToLower(owner)
+	srv.RepoName = strings.ToLower(repoName)
+
+	return srv, nil
+}
+
+// NewWithRegistry returns a Server serving every repository of the
+// registry. Repositories can be added to the registry after the
+// Server was created.
+func NewWithRegistry(registry *Registry, opts ...Option) (*Server, error) {
+	if registry == nil {
+		return nil, ErrRegistryMissing
 	}
 
 	srv := &Server{
-		Owner:    strings.ToLower(owner),
-		RepoName: strings.ToLower(repoName),
+		Owner:    "",
+		RepoName: "",
 
 		SessionTimeout: defSessionTimeout,
-		basicAuth: BasicAuth{
-			Username: "",
-			Password: "",
-		},
+		authenticator:  nil,
+		authorizer:     nil,
+
+		registry: registry,
 
-		repo: repo,
+		dumbProtocol: false,
+		lfs:          nil,
+		github:       nil,
 
-		upSession: nil,
-		rpSession: nil,
+		protections: BranchProtections{},
+		hooks:       []Hooks{},
+		pushes:      NewPushRecorder(0),
+		webhooks:    newWebhooks(),
+
+		metrics: nil,
+		tracer:  trace.NewNoopTracerProvider().Tracer(tracerName),
+		logger:  slog.New(discardHandler{}),
+
+		optionErr: nil,
 	}
 
 	for _, opt := range opts {
 		opt(srv)
 	}
 
-	if err := srv.newSessions(); err != nil {
-		return nil, fmt.Errorf("newSessions: %w", err)
+	if srv.optionErr != nil {
+		srv.Close()
+
+		return nil, srv.optionErr
 	}
 
+	srv.webhooks.logger = srv.logger
+
 	return srv, nil
 }
 
-// Load provides the object store for the given end point to satisfy
-// Go-Git sessions.
-func (s *Server) Load(*transport.Endpoint) (storer.Storer, error) { //nolint:ireturn
-	if s.repo == nil {
+// Load provides the object store for the given end point, the end
+// point path is resolved against the registry. The handlers do not use
+// it, it makes the Server a Loader of go-git's transport/server package
+// so that go-git clients can reach its repositories in process.
+func (s *Server) Load(ep *transport.Endpoint) (storer.Storer, error) { //nolint:ireturn
+	if s.registry == nil {
 		return nil, ErrRepoUninitialized
 	}
 
-	return s.repo.Storer, nil
+	repo, err := s.registry.Lookup(ep.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.Storer, nil
+}
+
+// Registry returns the registry of repositories served by the Server.
+func (s *Server) Registry() *Registry {
+	return s.registry
 }
 
 // RepoPath returns the relative path to the Git repository, it should
-// be used together with base URL of the HTTP server.
+// be used together with base URL of the HTTP server. It is only
+// meaningful for a Server created with New.
 func (s *Server) RepoPath() string {
-	return path.Join(s.Owner, fmt.Sprintf("%s.git", s.RepoName))
+	return RepoPath(s.Owner, s.RepoName)
 }
 
-func WithBasicAuth(ba BasicAuth) Option {
-	return func(s *Server) {
-		s.basicAuth = ba
-	}
+// Close cancels the webhook deliveries in progress and waits for those
+// in the background to return. Pushes received afterwards are not
+// delivered.
+func (s *Server) Close() {
+	s.webhooks.close()
 }
 
-func (s *Server) newSessions() error {
-	gitSrv := tsrv.NewServer(s)
+// WithBasicAuth requires every request to authenticate as the given
+// user, an empty BasicAuth disables authentication.
+func WithBasicAuth(ba BasicAuth) Option {
+	return func(s *Server) {
+		if ba == (BasicAuth{Username: "", Password: ""}) {
+			s.authenticator = nil
 
-	upSession, err := gitSrv.NewUploadPackSession(nil, nil)
-	if err != nil {
-		return fmt.Errorf("new UploadPackSession: %w", err)
-	}
+			return
+		}
 
-	rpSession, err := gitSrv.NewReceivePackSession(nil, nil)
-	if err != nil {
-		return fmt.Errorf("new ReceivePackSession: %w", err)
+		s.authenticator = StaticUsers{ba.Username: ba.Password}
 	}
-
-	s.upSession = upSession
-	s.rpSession = rpSession
-
-	return nil
 }
 
-func (s *Server) authenticate(username, password string, _ bool) error {
-	if s.basicAuth == (BasicAuth{Username: "", Password: ""}) {
-		return nil
+// repository resolves the repository addressed by the request path.
+func (s *Server) repository(req *http.Request) (string, *git.Repository, error) {
+	repoPath, _, ok := splitRepoPath(req.URL.Path)
+	if !ok {
+		return "", nil, fmt.Errorf("%s: %w", req.URL.Path, ErrRepoNotFound)
 	}
 
-	if s.basicAuth.Username == username &&
-		s.basicAuth.Password == password {
-		return nil
+	repo, err := s.registry.Lookup(repoPath)
+	if err != nil {
+		return "", nil, err
 	}
 
-	return ErrInvalidAuth
+	return repoPath, repo, nil
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	tsrv "github.com/go-git/go-git/v5/plumbing/transport/server"
 	"github.com/sata-form3/go-git-http-backend/pkg/server"
 	"github.com/stretchr/testify/require"
 )
@@ -47,6 +48,8 @@ func TestNewWithInvalidArgs(t *testing.T) {
 	}
 
 	for name, test := range tests {
+		test := test
+
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
@@ -72,6 +75,26 @@ func TestNoPanicsOnLoadWhenNilStruct(t *testing.T) {
 	})
 }
 
+func TestLoadServesGoGitSessions(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.New(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	endpoint := &transport.Endpoint{Path: srv.RepoPath()} //nolint:exhaustivestruct
+	session, err := tsrv.NewServer(srv).NewUploadPackSession(endpoint, nil)
+	require.NoError(t, err)
+
+	advRefs, err := session.AdvertisedReferences()
+	require.NoError(t, err)
+	require.Equal(t, head.Hash(), *advRefs.Head)
+}
+
 //nolint:paralleltest // https://github.com/kunwardeep/paralleltest/issues/12
 func TestURLsAreNormalized(t *testing.T) {
 	t.Parallel()
@@ -94,6 +117,8 @@ func TestURLsAreNormalized(t *testing.T) {
 	}
 
 	for name, test := range tests {
+		test := test
+
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
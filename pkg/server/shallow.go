@@ -0,0 +1,324 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+)
+
+var (
+	ErrNoShallowCommits = fmt.Errorf("no commits selected for shallow requests")
+	ErrDeepenConflict   = fmt.Errorf("deepen and deepen-since (or deepen-not) cannot be used together")
+)
+
+// deepenRequest limits the history sent to a shallow client by a
+// number of commits, by commit date or by references whose history is
+// excluded.
+type deepenRequest struct {
+	depth int
+	since time.Time
+	not   []string
+	// relative counts the depth from the shallow commits of the client
+	// rather than from the wants.
+	relative bool
+}
+
+func (d *deepenRequest) isZero() bool {
+	return d.depth == 0 && d.since.IsZero() && len(d.not) == 0
+}
+
+// newDeepenRequest converts the depth of a protocol version 0 upload
+// request.
+func newDeepenRequest(upReq *packp.UploadRequest, relative bool) *deepenRequest {
+	deepen := &deepenRequest{depth: 0, since: time.Time{}, not: nil, relative: relative}
+
+	switch depth := upReq.Depth.(type) {
+	case packp.DepthCommits:
+		deepen.depth = int(depth)
+	case packp.DepthSince:
+		deepen.since = time.Time(depth)
+	case packp.DepthReference:
+		deepen.not = []string{string(depth)}
+	}
+
+	return deepen
+}
+
+// deepen computes the shallow commits of the client after the fetch
+// and updates spec to stop at those and to send the history of the
+// commits which are no longer shallow. The returned update is nil if
+// the client did not ask to deepen.
+func deepen(repo *git.Repository, spec *packSpec, req *deepenRequest) (*packp.ShallowUpdate, error) {
+	spec.shallows = spec.clientShallows
+
+	if req.isZero() {
+		return nil, nil
+	}
+
+	wants, err := peelCommits(repo, spec.wants)
+	if err != nil {
+		return nil, err
+	}
+
+	var included, boundaries map[plumbing.Hash]bool
+
+	if req.depth > 0 {
+		if !req.since.IsZero() || len(req.not) > 0 {
+			return nil, ErrDeepenConflict
+		}
+
+		starts, depth := wants, req.depth
+		if req.relative {
+			starts, depth = spec.clientShallows, depth+1
+		}
+
+		included, boundaries, err = shallowByDepth(repo, starts, depth)
+	} else {
+		included, boundaries, err = shallowByRevisions(repo, wants, req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	update := &packp.ShallowUpdate{Shallows: []plumbing.Hash{}, Unshallows: []plumbing.Hash{}}
+	clientShallows := map[plumbing.Hash]bool{}
+
+	for _, hash := range spec.clientShallows {
+		clientShallows[hash] = true
+
+		if !included[hash] || boundaries[hash] {
+			continue
+		}
+
+		// the parents of commits which are no longer shallow are sent
+		// although the client has the commits already.
+		update.Unshallows = append(update.Unshallows, hash)
+
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", hash, err)
+		}
+
+		spec.wants = append(spec.wants, commit.ParentHashes...)
+	}
+
+	shallows := []plumbing.Hash{}
+
+	for hash := range boundaries {
+		shallows = append(shallows, hash)
+
+		if !clientShallows[hash] {
+			update.Shallows = append(update.Shallows, hash)
+		}
+	}
+
+	for hash := range clientShallows {
+		if !boundaries[hash] && !included[hash] {
+			shallows = append(shallows, hash)
+		}
+	}
+
+	sortHashes(update.Shallows)
+	sortHashes(update.Unshallows)
+
+	spec.shallows = shallows
+
+	return update, nil
+}
+
+// shallowByDepth walks depth commits from starts, it returns the
+// commits walked and those at the boundary, whose parents are not
+// sent.
+func shallowByDepth(
+	repo *git.Repository, starts []plumbing.Hash, depth int,
+) (map[plumbing.Hash]bool, map[plumbing.Hash]bool, error) {
+	distances := map[plumbing.Hash]int{}
+	boundaries := map[plumbing.Hash]bool{}
+	queue := []plumbing.Hash{}
+
+	for _, start := range starts {
+		if _, ok := distances[start]; !ok {
+			distances[start] = 0
+			queue = append(queue, start)
+		}
+	}
+
+	// breadth first, so every commit is reached at its smallest
+	// distance.
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("commit %s: %w", hash, err)
+		}
+
+		if distances[hash] >= depth-1 {
+			if commit.NumParents() > 0 {
+				boundaries[hash] = true
+			}
+
+			continue
+		}
+
+		for _, parent := range commit.ParentHashes {
+			if _, ok := distances[parent]; !ok {
+				distances[parent] = distances[hash] + 1
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	included := make(map[plumbing.Hash]bool, len(distances))
+	for hash := range distances {
+		included[hash] = true
+	}
+
+	return included, boundaries, nil
+}
+
+// shallowByRevisions walks the commits from wants which are newer than
+// the since date and not reachable from the excluded references.
+func shallowByRevisions(
+	repo *git.Repository, wants []plumbing.Hash, req *deepenRequest,
+) (map[plumbing.Hash]bool, map[plumbing.Hash]bool, error) {
+	excluded := map[plumbing.Hash]bool{}
+
+	for _, name := range req.not {
+		hash, err := repo.ResolveRevision(plumbing.Revision(name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("deepen-not %s: %w", name, err)
+		}
+
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("commit %s: %w", hash, err)
+		}
+
+		err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+			excluded[c.Hash] = true
+
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("walk %s: %w", name, err)
+		}
+	}
+
+	selected := func(commit *object.Commit) bool {
+		return !excluded[commit.Hash] && !commit.Committer.When.Before(req.since)
+	}
+
+	included := map[plumbing.Hash]bool{}
+	boundaries := map[plumbing.Hash]bool{}
+	pending := append([]plumbing.Hash{}, wants...)
+
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if included[hash] {
+			continue
+		}
+
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("commit %s: %w", hash, err)
+		}
+
+		if !selected(commit) {
+			continue
+		}
+
+		included[hash] = true
+
+		parents := []plumbing.Hash{}
+
+		err = commit.Parents().ForEach(func(parent *object.Commit) error {
+			if !selected(parent) {
+				boundaries[hash] = true
+			}
+
+			parents = append(parents, parent.Hash)
+
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("parents of %s: %w", hash, err)
+		}
+
+		if !boundaries[hash] {
+			pending = append(pending, parents...)
+		}
+	}
+
+	if len(included) == 0 {
+		return nil, nil, ErrNoShallowCommits
+	}
+
+	return included, boundaries, nil
+}
+
+// peelCommits returns the commits the wants point to, wanted trees and
+// blobs are left out.
+func peelCommits(repo *git.Repository, wants []plumbing.Hash) ([]plumbing.Hash, error) {
+	commits := make([]plumbing.Hash, 0, len(wants))
+
+	for _, want := range wants {
+		hash, _ := peelTag(repo, want)
+
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", hash, err)
+		}
+
+		if obj.Type() == plumbing.CommitObject {
+			commits = append(commits, hash)
+		}
+	}
+
+	return commits, nil
+}
+
+func sortHashes(hashes []plumbing.Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i].String() < hashes[j].String()
+	})
+}
+
+// writeShallowInfo writes the shallow-info section of a protocol
+// version 2 fetch response.
+func writeShallowInfo(w io.Writer, update *packp.ShallowUpdate) error {
+	enc := pktline.NewEncoder(w)
+
+	if err := enc.EncodeString("shallow-info\n"); err != nil {
+		return fmt.Errorf("encode shallow-info: %w", err)
+	}
+
+	for _, hash := range update.Shallows {
+		if err := enc.Encodef("shallow %s\n", hash); err != nil {
+			return fmt.Errorf("encode shallow: %w", err)
+		}
+	}
+
+	for _, hash := range update.Unshallows {
+		if err := enc.Encodef("unshallow %s\n", hash); err != nil {
+			return fmt.Errorf("encode unshallow: %w", err)
+		}
+	}
+
+	if _, err := io.WriteString(w, delimPkt); err != nil {
+		return fmt.Errorf("write delim: %w", err)
+	}
+
+	return nil
+}
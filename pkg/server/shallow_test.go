@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+// historyStart is the commit date of the first commit of
+// repoWithHistory, every following commit is an hour later.
+var historyStart = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestShallowClone(t *testing.T) {
+	t.Parallel()
+
+	testRepo, commits := repoWithHistory(t, 4)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	for depth := 1; depth <= 5; depth++ {
+		depth := depth
+
+		t.Run(fmt.Sprintf("depth %d", depth), func(t *testing.T) {
+			t.Parallel()
+
+			local, err := git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &git.CloneOptions{
+				URL:   srv.URL(),
+				Depth: depth,
+			})
+			require.NoError(t, err)
+
+			shallows, err := local.Storer.Shallow()
+			require.NoError(t, err)
+
+			if depth < len(commits) {
+				require.Equal(t, []plumbing.Hash{commits[len(commits)-depth]}, shallows)
+			} else {
+				require.Empty(t, shallows)
+			}
+
+			head, err := local.Head()
+			require.NoError(t, err)
+			require.Equal(t, commits[len(commits)-1], head.Hash())
+
+			worktree, err := local.Worktree()
+			require.NoError(t, err)
+			require.Equal(t, "content 3", readFile(t, worktree.Filesystem, filename))
+		})
+	}
+}
+
+func TestShallowWithGitCLI(t *testing.T) {
+	t.Parallel()
+
+	for _, version := range []string{"0", "2"} {
+		version := version
+
+		t.Run("protocol version "+version, func(t *testing.T) {
+			t.Parallel()
+
+			testRepo, commits := repoWithHistory(t, 4)
+
+			_, err := testRepo.CreateTag("v1", commits[1], nil)
+			require.NoError(t, err)
+
+			srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			count := func(dir string) string {
+				return strings.TrimSpace(runGit(t, dir, version, "rev-list", "--count", "HEAD"))
+			}
+
+			dir := t.TempDir()
+			runGit(t, dir, version, "clone", "--depth=1", srv.URL(), ".")
+			require.Equal(t, "1", count(dir))
+			require.Equal(t, commits[3].String(), readShallow(t, dir))
+
+			runGit(t, dir, version, "fetch", "--deepen=2")
+			require.Equal(t, "3", count(dir))
+			require.Equal(t, commits[1].String(), readShallow(t, dir))
+
+			runGit(t, dir, version, "fetch", "--unshallow")
+			require.Equal(t, "4", count(dir))
+			require.NoFileExists(t, filepath.Join(dir, ".git", "shallow"))
+
+			since := t.TempDir()
+			shallowSince := historyStart.Add(2 * time.Hour).Format(time.RFC3339)
+			runGit(t, since, version, "clone", "--shallow-since="+shallowSince, srv.URL(), ".")
+			require.Equal(t, "2", count(since))
+
+			exclude := t.TempDir()
+			runGit(t, exclude, version, "clone", "--shallow-exclude=v1", srv.URL(), ".")
+			require.Equal(t, "2", count(exclude))
+			require.Equal(t, commits[2].String(), readShallow(t, exclude))
+		})
+	}
+}
+
+// repoWithHistory returns a repository with n commits, each changing
+// the content of filename, and the commit hashes from oldest to
+// newest.
+func repoWithHistory(t *testing.T, n int) (*git.Repository, []plumbing.Hash) {
+	t.Helper()
+
+	repo := emptyRepository(t)
+	commits := make([]plumbing.Hash, 0, n)
+
+	for i := 0; i < n; i++ {
+		files := map[string]string{filename: fmt.Sprintf("content %d", i)}
+		when := historyStart.Add(time.Duration(i) * time.Hour)
+
+		commits = append(commits, commitFiles(t, repo, files, fmt.Sprintf("commit %d", i), when))
+	}
+
+	return repo, commits
+}
+
+// readShallow returns the shallow commits of the git checkout in dir.
+func readShallow(t *testing.T, dir string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, ".git", "shallow"))
+	require.NoError(t, err)
+
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// sidebandWriter returns the writer the pack data or report status is
+// written to, which is multiplexed on the pack data channel if the
+// client asked for side-band. The returned muxer is nil without
+// side-band.
+func sidebandWriter(w io.Writer, caps *capability.List) (io.Writer, *sideband.Muxer) {
+	switch {
+	case caps.Supports(capability.Sideband64k):
+		mux := sideband.NewMuxer(sideband.Sideband64k, w)
+
+		return mux, mux
+	case caps.Supports(capability.Sideband):
+		mux := sideband.NewMuxer(sideband.Sideband, w)
+
+		return mux, mux
+	default:
+		return w, nil
+	}
+}
+
+// progressWriter writes messages to the progress channel, which git
+// clients display prefixed with remote:. It is safe for concurrent
+// use.
+type progressWriter struct {
+	mu  sync.Mutex
+	mux *sideband.Muxer
+}
+
+// newProgressWriter returns a writer for the progress channel of mux,
+// messages are discarded without side-band or when disabled.
+func newProgressWriter(mux *sideband.Muxer, enabled bool) io.Writer {
+	if mux == nil || !enabled {
+		return io.Discard
+	}
+
+	return &progressWriter{mu: sync.Mutex{}, mux: mux}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n, err := p.mux.WriteChannel(sideband.ProgressMessage, b)
+	if err != nil {
+		return n, fmt.Errorf("write progress: %w", err)
+	}
+
+	return n, nil
+}
+
+// writeFatal writes a fatal error to the error channel, clients abort
+// on such errors.
+func writeFatal(mux *sideband.Muxer, err error) {
+	if mux == nil {
+		return
+	}
+
+	_, _ = mux.WriteChannel(sideband.ErrorMessage, []byte(fmt.Sprintf("%s\n", err)))
+}
+
+// sendPack writes the pack holding objs to out, reporting progress and
+// failures on the side-band channels. Deltas refer to their base by
+// hash if refDeltas is set. The pack is encoded before any of it is
+// sent, so that compression is reported once the deltas are computed,
+// as git does.
+func (s *Server) sendPack(
+	ctx context.Context, out io.Writer, mux *sideband.Muxer, progress io.Writer,
+	st storer.EncodedObjectStorer, objs []plumbing.Hash, refDeltas bool,
+) {
+	_, span := s.startSpan(ctx, spanEncode, attrPackObjects.Int(len(objs)))
+	defer span.End()
+
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objs))
+	fmt.Fprintf(progress, "Counting objects: 100%% (%d/%d), done.\n", len(objs), len(objs))
+	fmt.Fprintf(progress, "Compressing objects:   0%% (0/%d)\r", len(objs))
+
+	var pack bytes.Buffer
+
+	err := encodePack(&pack, st, objs, refDeltas)
+
+	span.SetAttributes(attrPackSize.Int(pack.Len()))
+	setRequestAttributes(ctx, attrPackObjects.Int(len(objs)), attrPackSize.Int(pack.Len()))
+
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		observe(ctx).fail(err)
+		writeFatal(mux, err)
+
+		return
+	}
+
+	observe(ctx).sentObjects(len(objs))
+	fmt.Fprintf(progress, "Compressing objects: 100%% (%d/%d), done.\n", len(objs), len(objs))
+	fmt.Fprintf(progress, "Total %d, done.\n", len(objs))
+
+	if _, err := pack.WriteTo(out); err != nil {
+		writeFatal(mux, err)
+	}
+}
@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneReportsProgress(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	var progress bytes.Buffer
+
+	_, err = git.CloneContext(context.Background(), memory.NewStorage(), memfs.New(), &git.CloneOptions{
+		URL:      srv.URL(),
+		Progress: &progress,
+	})
+	require.NoError(t, err)
+
+	require.Contains(t, progress.String(), "Enumerating objects: 3, done.")
+	require.Contains(t, progress.String(), "Counting objects: 100% (3/3), done.")
+	require.Contains(t, progress.String(), "Compressing objects:   0% (0/3)\r")
+	require.Contains(t, progress.String(), "Compressing objects: 100% (3/3), done.")
+	require.Contains(t, progress.String(), "Total 3, done.")
+}
+
+func TestProgressPrecedesPackData(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	resp := postCommandV2(t, srv.URL(), "fetch", "want "+head.Hash().String(), "done")
+	defer resp.Body.Close()
+
+	scanner := pktline.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "packfile\n", string(scanner.Bytes()))
+
+	var progress strings.Builder
+
+	for scanner.Scan() && len(scanner.Bytes()) > 0 {
+		line := scanner.Bytes()
+		if sideband.Channel(line[0]) == sideband.PackData {
+			break
+		}
+
+		progress.Write(line[1:])
+	}
+
+	require.Contains(t, progress.String(), "Compressing objects: 100% (3/3), done.")
+	require.Contains(t, progress.String(), "Total 3, done.")
+}
+
+func TestHooksSendRemoteMessages(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithHooks(server.Hooks{
+		PreReceive: func(ctx context.Context, push *server.Push) error {
+			fmt.Fprintln(push.Progress, "checking commits")
+
+			return nil
+		},
+		PostReceive: func(ctx context.Context, push *server.Push) {
+			fmt.Fprintf(push.Progress, "deployed %s\n", push.Commands[0].Name)
+		},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	var progress bytes.Buffer
+
+	err = local.PushContext(context.Background(), &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		Progress:   &progress,
+	})
+	require.NoError(t, err)
+
+	require.Contains(t, progress.String(), "checking commits\n")
+	require.Contains(t, progress.String(), "deployed refs/heads/master\n")
+}
+
+func TestGitCLIShowsRemoteMessages(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName, server.WithHooks(server.Hooks{
+		Update: func(ctx context.Context, push *server.Push, cmd *packp.Command) error {
+			fmt.Fprintln(push.Progress, "CI gate passed")
+
+			return nil
+		},
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+	runGit(t, dir, "0", "clone", srv.URL(), ".")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, filename), []byte("cli content"), 0o600))
+	runGit(t, dir, "0", "commit", "-am", "cli commit")
+
+	out := runGit(t, dir, "0", "push", "origin", "master")
+	require.Contains(t, out, "remote: CI gate passed")
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+var ErrPruneUnsupported = fmt.Errorf("pruning objects not supported by storage")
+
+// Snapshot is the state of the references of the repositories served
+// by a HTTPTestServer, taken by Snapshot and brought back by Restore.
+type Snapshot struct {
+	repos map[string]*repoSnapshot
+}
+
+// repoSnapshot holds the references of a repository as well as the
+// loose objects and packs it had, so objects added later on can be
+// pruned.
+type repoSnapshot struct {
+	refs    []*plumbing.Reference
+	objects map[plumbing.Hash]bool
+	packs   map[plumbing.Hash]bool
+}
+
+// RestoreOption configures Restore and Reset.
+type RestoreOption func(*restoreOptions)
+
+type restoreOptions struct {
+	prune bool
+}
+
+// PruneObjects deletes the objects added to the repositories since the
+// snapshot was taken, e.g. the packs of pushes. Only in-memory and
+// filesystem storages support pruning.
+func PruneObjects() RestoreOption {
+	return func(o *restoreOptions) {
+		o.prune = true
+	}
+}
+
+// Snapshot captures every reference, including HEAD, of the
+// repositories served, so table-driven tests sharing the server can
+// Restore it between test cases.
+func (h *HTTPTestServer) Snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{repos: map[string]*repoSnapshot{}}
+
+	for _, repoPath := range h.Server.registry.Paths() {
+		repo, err := h.Server.registry.Lookup(repoPath)
+		if err != nil {
+			continue
+		}
+
+		repoSnap, err := snapshotRepository(repo)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", repoPath, err)
+		}
+
+		snapshot.repos[repoPath] = repoSnap
+	}
+
+	return snapshot, nil
+}
+
+// Restore sets the references of the repositories back to the
+// snapshot, references created since are deleted. Repositories which
+// were not served when the snapshot was taken are left alone. It must
+// not be called while requests are served.
+func (h *HTTPTestServer) Restore(snapshot *Snapshot, opts ...RestoreOption) error {
+	options := restoreOptions{prune: false}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	for repoPath, repoSnap := range snapshot.repos {
+		repo, err := h.Server.registry.Lookup(repoPath)
+		if errors.Is(err, ErrRepoNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := repoSnap.restore(repo.Storer, options); err != nil {
+			return fmt.Errorf("restore %s: %w", repoPath, err)
+		}
+	}
+
+	return nil
+}
+
+// Reset restores the state the repositories had when the server was
+// created and clears the push history.
+func (h *HTTPTestServer) Reset(opts ...RestoreOption) error {
+	if err := h.Restore(h.initial, opts...); err != nil {
+		return err
+	}
+
+	h.Server.Pushes().Clear()
+
+	return nil
+}
+
+func snapshotRepository(repo *git.Repository) (*repoSnapshot, error) {
+	snapshot := &repoSnapshot{
+		refs:    []*plumbing.Reference{},
+		objects: map[plumbing.Hash]bool{},
+		packs:   map[plumbing.Hash]bool{},
+	}
+
+	refs, err := repo.Storer.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("references: %w", err)
+	}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		snapshot.refs = append(snapshot.refs, ref)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("references: %w", err)
+	}
+
+	if los, ok := repo.Storer.(storer.LooseObjectStorer); ok {
+		err := los.ForEachObjectHash(func(hash plumbing.Hash) error {
+			snapshot.objects[hash] = true
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("objects: %w", err)
+		}
+	}
+
+	if pos, ok := repo.Storer.(storer.PackedObjectStorer); ok {
+		packs, err := pos.ObjectPacks()
+		if err != nil {
+			return nil, fmt.Errorf("packs: %w", err)
+		}
+
+		for _, pack := range packs {
+			snapshot.packs[pack] = true
+		}
+	}
+
+	return snapshot, nil
+}
+
+func (r *repoSnapshot) restore(st storer.Storer, options restoreOptions) error {
+	refs, err := st.IterReferences()
+	if err != nil {
+		return fmt.Errorf("references: %w", err)
+	}
+
+	current := []plumbing.ReferenceName{}
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		current = append(current, ref.Name())
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("references: %w", err)
+	}
+
+	for _, name := range current {
+		if err := st.RemoveReference(name); err != nil {
+			return fmt.Errorf("remove reference %s: %w", name, err)
+		}
+	}
+
+	for _, ref := range r.refs {
+		if err := st.SetReference(ref); err != nil {
+			return fmt.Errorf("set reference %s: %w", ref.Name(), err)
+		}
+	}
+
+	if !options.prune {
+		return nil
+	}
+
+	return r.prune(st)
+}
+
+// prune deletes the objects which were not in the storage when the
+// snapshot was taken. Objects of packs are deleted with the pack.
+func (r *repoSnapshot) prune(st storer.Storer) error {
+	if mem, ok := st.(*memory.Storage); ok {
+		for hash := range mem.Objects {
+			if !r.objects[hash] {
+				delete(mem.Objects, hash)
+				delete(mem.Commits, hash)
+				delete(mem.Trees, hash)
+				delete(mem.Blobs, hash)
+				delete(mem.Tags, hash)
+			}
+		}
+
+		return nil
+	}
+
+	los, looseOK := st.(storer.LooseObjectStorer)
+	pos, packedOK := st.(storer.PackedObjectStorer)
+
+	if !looseOK || !packedOK {
+		return ErrPruneUnsupported
+	}
+
+	added := []plumbing.Hash{}
+
+	err := los.ForEachObjectHash(func(hash plumbing.Hash) error {
+		if !r.objects[hash] {
+			added = append(added, hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("objects: %w", err)
+	}
+
+	for _, hash := range added {
+		if err := los.DeleteLooseObject(hash); err != nil {
+			return fmt.Errorf("delete object %s: %w", hash, err)
+		}
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return fmt.Errorf("packs: %w", err)
+	}
+
+	for _, pack := range packs {
+		if r.packs[pack] {
+			continue
+		}
+
+		if err := pos.DeleteOldObjectPackAndIndex(pack, time.Time{}); err != nil {
+			return fmt.Errorf("delete pack %s: %w", pack, err)
+		}
+	}
+
+	// the index of the deleted packs is cached by filesystem storages.
+	if reindexer, ok := st.(interface{ Reindex() }); ok {
+		reindexer.Reindex()
+	}
+
+	return nil
+}
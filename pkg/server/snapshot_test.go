@@ -0,0 +1,158 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	gitfs "github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	snapshot, err := srv.Snapshot()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		refSpecs []config.RefSpec
+	}{
+		{
+			name:     "update master",
+			refSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		},
+		{
+			name:     "create branch",
+			refSpecs: []config.RefSpec{"refs/heads/master:refs/heads/feature"},
+		},
+		{
+			name:     "delete master",
+			refSpecs: []config.RefSpec{":refs/heads/master"},
+		},
+	}
+
+	// the test cases share the server, so they do not run in parallel.
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			local := cloneRepository(t, srv.URL(), noAuth)
+			commitFile(t, local, filename, tc.name, tc.name)
+			require.NoError(t, push(local, noAuth, tc.refSpecs...))
+
+			require.NoError(t, srv.Restore(snapshot))
+
+			require.Equal(t, []string{"refs/heads/master"}, branchNames(t, testRepo))
+
+			master, err := testRepo.Reference(plumbing.Master, false)
+			require.NoError(t, err)
+			require.Equal(t, head.Hash(), master.Hash())
+
+			headRef, err := testRepo.Reference(plumbing.HEAD, false)
+			require.NoError(t, err)
+			require.Equal(t, plumbing.Master, headRef.Target())
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		repo func(t *testing.T) *git.Repository
+	}{
+		{
+			name: "memory",
+			repo: func(t *testing.T) *git.Repository {
+				t.Helper()
+
+				return repoWithInitCommit(t, filename, content)
+			},
+		},
+		{
+			name: "filesystem",
+			repo: func(t *testing.T) *git.Repository {
+				t.Helper()
+
+				storage := gitfs.NewStorage(memfs.New(), cache.NewObjectLRUDefault())
+
+				repo, err := git.Init(storage, memfs.New())
+				require.NoError(t, err)
+
+				commitFile(t, repo, filename, content, "initial commit")
+
+				return repo
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testRepo := tc.repo(t)
+
+			srv, err := server.NewHTTPTest(testRepo, owner, repoName)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			local := cloneRepository(t, srv.URL(), noAuth)
+			pushed := commitFile(t, local, filename, "pushed content", "pushed commit")
+			require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+
+			_, err = testRepo.CommitObject(pushed)
+			require.NoError(t, err)
+
+			require.NoError(t, srv.Reset(server.PruneObjects()))
+
+			_, err = testRepo.CommitObject(pushed)
+			require.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+			require.Empty(t, srv.Pushes().Events())
+
+			// the pruned objects are sent again by the next push.
+			require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+
+			commit, err := testRepo.CommitObject(pushed)
+			require.NoError(t, err)
+			require.Equal(t, "pushed commit", commit.Message)
+		})
+	}
+}
+
+func branchNames(t *testing.T, repo *git.Repository) []string {
+	t.Helper()
+
+	branches, err := repo.Branches()
+	require.NoError(t, err)
+
+	names := []string{}
+
+	err = branches.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().String())
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	return names
+}
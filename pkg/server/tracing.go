@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sata-form3/go-git-http-backend/pkg/server"
+
+// Span attributes of the Git HTTP endpoints.
+const (
+	attrRepository  = attribute.Key("git.repository")
+	attrService     = attribute.Key("git.service")
+	attrRefs        = attribute.Key("git.refs")
+	attrWants       = attribute.Key("git.wants")
+	attrHaves       = attribute.Key("git.haves")
+	attrCommands    = attribute.Key("git.commands")
+	attrPackObjects = attribute.Key("git.pack.objects")
+	attrPackSize    = attribute.Key("git.pack.size")
+)
+
+// Spans of the phases of a Git HTTP request, children of the request
+// span named after the endpoint.
+const (
+	spanAdvertise = "advertise refs"
+	spanDecode    = "decode request"
+	spanNegotiate = "negotiate"
+	spanPack      = "generate pack"
+	spanUnpack    = "unpack"
+	spanEncode    = "encode response"
+)
+
+// WithTracerProvider traces every endpoint with a span per request,
+// and the info/refs, git-upload-pack and git-receive-pack endpoints
+// with child spans for the phases of the request. The parent span is
+// taken from the W3C trace context headers of the request.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// startRequestSpan starts the span of a request to the service as
+// child of the span propagated by the client, if any.
+func (s *Server) startRequestSpan(
+	req *http.Request, repo, service string,
+) (context.Context, trace.Span) { //nolint:ireturn
+	ctx := propagation.TraceContext{}.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	return s.tracer.Start(ctx, service,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(req.Method),
+			attrRepository.String(repo),
+			attrService.String(requestService(req, service)),
+		),
+	)
+}
+
+func endRequestSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPStatusCode(status))
+
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	span.End()
+}
+
+// startSpan starts the span of a phase of the request of ctx.
+func (s *Server) startSpan(
+	ctx context.Context, name string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) { //nolint:ireturn
+	return s.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// setRequestAttributes adds attributes to the request span of ctx.
+func setRequestAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
@@ -0,0 +1,169 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithTracerProvider(provider))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+
+	spans := exporter.GetSpans()
+	repo := server.RepoPath(owner, repoName)
+
+	tests := []struct {
+		name     string
+		service  string
+		children []string
+		attrs    map[attribute.Key]attribute.Value
+	}{
+		{
+			name:     "info/refs",
+			service:  "git-upload-pack",
+			children: []string{"advertise refs", "encode response"},
+			attrs: map[attribute.Key]attribute.Value{
+				"git.repository":   attribute.StringValue(repo),
+				"git.refs":         attribute.IntValue(1),
+				"http.status_code": attribute.IntValue(http.StatusOK),
+			},
+		},
+		{
+			name:     "git-upload-pack",
+			service:  "git-upload-pack",
+			children: []string{"decode request", "negotiate", "generate pack", "encode response"},
+			attrs: map[attribute.Key]attribute.Value{
+				"git.repository":   attribute.StringValue(repo),
+				"git.wants":        attribute.IntValue(1),
+				"git.haves":        attribute.IntValue(0),
+				"git.pack.objects": attribute.IntValue(3),
+			},
+		},
+		{
+			name:     "git-receive-pack",
+			service:  "git-receive-pack",
+			children: []string{"decode request", "unpack", "encode response"},
+			attrs: map[attribute.Key]attribute.Value{
+				"git.repository":   attribute.StringValue(repo),
+				"git.commands":     attribute.IntValue(1),
+				"http.status_code": attribute.IntValue(http.StatusOK),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			span, ok := findSpan(spans, tc.name, tc.service)
+			require.True(t, ok)
+			require.Equal(t, trace.SpanKindServer, span.SpanKind)
+
+			attrs := spanAttributes(span)
+			for key, value := range tc.attrs {
+				require.Equal(t, value, attrs[key], key)
+			}
+
+			require.Equal(t, tc.children, childNames(spans, span))
+		})
+	}
+
+	upload, _ := findSpan(spans, "git-upload-pack", "git-upload-pack")
+	require.Positive(t, spanAttributes(upload)["git.pack.size"].AsInt64())
+
+	receive, _ := findSpan(spans, "git-receive-pack", "git-receive-pack")
+	require.Positive(t, spanAttributes(receive)["git.pack.size"].AsInt64())
+}
+
+func TestTracingPropagatesTraceContext(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithTracerProvider(provider))
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/info/refs?service=git-upload-pack", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	span, ok := findSpan(exporter.GetSpans(), "info/refs", "git-upload-pack")
+	require.True(t, ok)
+	require.Equal(t, traceID, span.SpanContext.TraceID().String())
+	require.Equal(t, spanID, span.Parent.SpanID().String())
+	require.True(t, span.Parent.IsRemote())
+}
+
+func findSpan(spans tracetest.SpanStubs, name, service string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name && spanAttributes(span)["git.service"].AsString() == service {
+			return span, true
+		}
+	}
+
+	return tracetest.SpanStub{}, false
+}
+
+// childNames returns the names of the children of parent, in the
+// order they started.
+func childNames(spans tracetest.SpanStubs, parent tracetest.SpanStub) []string {
+	names := []string{}
+
+	for _, span := range spans {
+		if span.Parent.SpanID() == parent.SpanContext.SpanID() {
+			names = append(names, span.Name)
+		}
+	}
+
+	return names
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+
+	return attrs
+}
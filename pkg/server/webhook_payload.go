@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// refPayload is the push payload of a reference update.
+type refPayload struct {
+	ref  plumbing.ReferenceName
+	body []byte
+}
+
+type webhookPushPayload struct {
+	Ref        string            `json:"ref"`
+	Before     string            `json:"before"`
+	After      string            `json:"after"`
+	Created    bool              `json:"created"`
+	Deleted    bool              `json:"deleted"`
+	Forced     bool              `json:"forced"`
+	Commits    []webhookCommit   `json:"commits"`
+	HeadCommit *webhookCommit    `json:"head_commit"`
+	Repository webhookRepository `json:"repository"`
+	Pusher     webhookPerson     `json:"pusher"`
+	Sender     githubUser        `json:"sender"`
+}
+
+type webhookPerson struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+type webhookRepository struct {
+	Name          string        `json:"name"`
+	FullName      string        `json:"full_name"`
+	Owner         webhookPerson `json:"owner"`
+	DefaultBranch string        `json:"default_branch"`
+}
+
+type webhookCommit struct {
+	ID        string        `json:"id"`
+	TreeID    string        `json:"tree_id"`
+	Distinct  bool          `json:"distinct"`
+	Message   string        `json:"message"`
+	Timestamp string        `json:"timestamp"`
+	Author    webhookPerson `json:"author"`
+	Committer webhookPerson `json:"committer"`
+	Added     []string      `json:"added"`
+	Removed   []string      `json:"removed"`
+	Modified  []string      `json:"modified"`
+}
+
+// pushPayloads builds the push payload of every applied reference
+// update of the event. Updates whose payload cannot be built are left
+// out, the push itself succeeded regardless.
+func pushPayloads(repo *git.Repository, event PushEvent) []refPayload {
+	payloads := []refPayload{}
+
+	for _, cmd := range event.Commands {
+		if cmd.Err != nil || event.Err != nil {
+			continue
+		}
+
+		payload, err := newPushPayload(repo, event, cmd)
+		if err != nil {
+			continue
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			continue
+		}
+
+		payloads = append(payloads, refPayload{ref: cmd.Name, body: body})
+	}
+
+	return payloads
+}
+
+func newPushPayload(repo *git.Repository, event PushEvent, cmd PushCommand) (*webhookPushPayload, error) {
+	owner, name := path.Split(strings.TrimSuffix(event.RepoPath, ".git"))
+	owner = strings.TrimSuffix(owner, "/")
+
+	payload := &webhookPushPayload{
+		Ref:        cmd.Name.String(),
+		Before:     cmd.Old.String(),
+		After:      cmd.New.String(),
+		Created:    cmd.Old.IsZero(),
+		Deleted:    cmd.New.IsZero(),
+		Forced:     false,
+		Commits:    []webhookCommit{},
+		HeadCommit: nil,
+		Repository: webhookRepository{
+			Name:          name,
+			FullName:      owner + "/" + name,
+			Owner:         webhookPerson{Name: owner, Email: ""},
+			DefaultBranch: defaultBranch(repo),
+		},
+		Pusher: webhookPerson{Name: principalName(event.Principal), Email: ""},
+		Sender: githubUser{Login: principalName(event.Principal)},
+	}
+
+	if payload.Deleted {
+		return payload, nil
+	}
+
+	if !payload.Created {
+		fastForward, err := isFastForward(repo, cmd.Old, cmd.New)
+		if err != nil {
+			return nil, err
+		}
+
+		payload.Forced = !fastForward
+	}
+
+	for _, commit := range refCommits(repo, event.Commits, cmd.New) {
+		webhookCommit, err := newWebhookCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		payload.Commits = append(payload.Commits, webhookCommit)
+	}
+
+	headHash, _ := peelTag(repo, cmd.New)
+
+	head, err := repo.CommitObject(headHash)
+	if err != nil {
+		// references to trees and blobs have no head commit.
+		return payload, nil //nolint:nilerr
+	}
+
+	headCommit, err := newWebhookCommit(head)
+	if err != nil {
+		return nil, err
+	}
+
+	payload.HeadCommit = &headCommit
+
+	return payload, nil
+}
+
+// refCommits returns the pushed commits reachable from hash, oldest
+// first.
+func refCommits(repo *git.Repository, pushed []*object.Commit, hash plumbing.Hash) []*object.Commit {
+	byHash := make(map[plumbing.Hash]*object.Commit, len(pushed))
+	for _, commit := range pushed {
+		byHash[commit.Hash] = commit
+	}
+
+	hash, _ = peelTag(repo, hash)
+
+	reachable := map[plumbing.Hash]bool{}
+	pending := []plumbing.Hash{hash}
+
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		commit, ok := byHash[current]
+		if !ok || reachable[current] {
+			continue
+		}
+
+		reachable[current] = true
+		pending = append(pending, commit.ParentHashes...)
+	}
+
+	// the pushed commits are newest first.
+	commits := []*object.Commit{}
+
+	for i := len(pushed) - 1; i >= 0; i-- {
+		if reachable[pushed[i].Hash] {
+			commits = append(commits, pushed[i])
+		}
+	}
+
+	return commits
+}
+
+// newWebhookCommit describes the commit with the files it changed
+// compared to its first parent.
+func newWebhookCommit(commit *object.Commit) (webhookCommit, error) {
+	webhookCommit := webhookCommit{
+		ID:        commit.Hash.String(),
+		TreeID:    commit.TreeHash.String(),
+		Distinct:  true,
+		Message:   commit.Message,
+		Timestamp: commit.Committer.When.Format(time.RFC3339),
+		Author:    webhookPerson{Name: commit.Author.Name, Email: commit.Author.Email},
+		Committer: webhookPerson{Name: commit.Committer.Name, Email: commit.Committer.Email},
+		Added:     []string{},
+		Removed:   []string{},
+		Modified:  []string{},
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return webhookCommit, fmt.Errorf("tree of %s: %w", commit.Hash, err)
+	}
+
+	var parentTree *object.Tree
+
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return webhookCommit, fmt.Errorf("parent of %s: %w", commit.Hash, err)
+		}
+
+		if parentTree, err = parent.Tree(); err != nil {
+			return webhookCommit, fmt.Errorf("tree of %s: %w", parent.Hash, err)
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return webhookCommit, fmt.Errorf("diff %s: %w", commit.Hash, err)
+	}
+
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return webhookCommit, fmt.Errorf("diff %s: %w", commit.Hash, err)
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			webhookCommit.Added = append(webhookCommit.Added, change.To.Name)
+		case merkletrie.Delete:
+			webhookCommit.Removed = append(webhookCommit.Removed, change.From.Name)
+		case merkletrie.Modify:
+			webhookCommit.Modified = append(webhookCommit.Modified, change.To.Name)
+		}
+	}
+
+	return webhookCommit, nil
+}
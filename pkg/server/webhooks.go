@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	webhookDefaultAttempts = 3
+	webhookDefaultBackoff  = time.Second
+	webhookDefaultLogLimit = 1000
+	webhookTimeout         = 10 * time.Second
+	webhookEventPush       = "push"
+	webhookSignaturePrefix = "sha256="
+)
+
+var ErrWebhookStatus = fmt.Errorf("webhook responded with unsuccessful status")
+
+// Webhook is an endpoint notified of every push, with a GitHub-style
+// push payload per updated reference, see
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#push.
+type Webhook struct {
+	URL string
+	// Secret signs the payloads with HMAC-SHA256, the signature is sent
+	// in the X-Hub-Signature-256 header. Payloads are not signed if the
+	// secret is empty.
+	Secret string
+	// RepoPath restricts the webhook to the repository, e.g.
+	// owner/name.git. The webhook is notified of pushes to every
+	// repository if it is empty or AnyRepository.
+	RepoPath string
+	// MaxAttempts bounds the deliveries of a payload, 3 if zero. A
+	// delivery fails unless the endpoint responds with a 2xx status.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every
+	// further retry, one second if zero.
+	Backoff time.Duration
+}
+
+// WebhookAttempt is an attempt to deliver a payload.
+type WebhookAttempt struct {
+	Time     time.Time
+	Duration time.Duration
+	// StatusCode is the status the endpoint responded with, 0 if the
+	// request failed.
+	StatusCode int
+	Err        error
+}
+
+// WebhookDelivery is the delivery of a payload to a webhook, as kept
+// in the delivery log of the Server.
+type WebhookDelivery struct {
+	// ID is sent in the X-GitHub-Delivery header.
+	ID       string
+	URL      string
+	Event    string
+	RepoPath string
+	Ref      plumbing.ReferenceName
+	Payload  []byte
+	Attempts []WebhookAttempt
+}
+
+// Delivered reports whether the last attempt succeeded.
+func (d WebhookDelivery) Delivered() bool {
+	if len(d.Attempts) == 0 {
+		return false
+	}
+
+	last := d.Attempts[len(d.Attempts)-1]
+
+	return last.Err == nil && last.StatusCode >= 200 && last.StatusCode < 300
+}
+
+// WithWebhook registers a webhook notified after every push which
+// updated at least one reference. Payloads are delivered in the
+// background unless WithSynchronousWebhooks is set.
+func WithWebhook(hook Webhook) Option {
+	return func(s *Server) {
+		s.webhooks.hooks = append(s.webhooks.hooks, hook)
+	}
+}
+
+// WithSynchronousWebhooks delivers the payloads, including the
+// retries, before the push is responded to. It is meant for tests,
+// which can check the deliveries as soon as the push returned.
+func WithSynchronousWebhooks() Option {
+	return func(s *Server) {
+		s.webhooks.synchronous = true
+	}
+}
+
+// WithWebhookDeliveryLimit bounds the number of deliveries the Server
+// keeps in its delivery log, the last 1000 by default. A limit of 0
+// keeps every delivery.
+func WithWebhookDeliveryLimit(limit int) Option {
+	return func(s *Server) {
+		s.webhooks.limit = limit
+	}
+}
+
+// WebhookDeliveries returns the delivery log of the Server, oldest
+// first. Deliveries in progress are included with the attempts made so
+// far.
+func (s *Server) WebhookDeliveries() []WebhookDelivery {
+	return s.webhooks.deliveries()
+}
+
+// SignWebhookPayload returns the X-Hub-Signature-256 header value of
+// the payload signed with secret.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature, the value of the
+// X-Hub-Signature-256 header, matches the payload signed with secret.
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, payload)), []byte(signature))
+}
+
+type webhooks struct {
+	hooks       []Webhook
+	synchronous bool
+	client      *http.Client
+	logger      *slog.Logger
+
+	// ctx is canceled once the Server is closed, which ends the
+	// deliveries in progress. wg tracks those in the background.
+	ctx    context.Context //nolint:containedctx
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	log   []*WebhookDelivery
+	limit int
+}
+
+func newWebhooks() *webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &webhooks{
+		hooks:       []Webhook{},
+		synchronous: false,
+		client:      &http.Client{Timeout: webhookTimeout},
+		logger:      slog.New(discardHandler{}),
+
+		ctx:    ctx,
+		cancel: cancel,
+		wg:     sync.WaitGroup{},
+
+		mu:    sync.Mutex{},
+		log:   []*WebhookDelivery{},
+		limit: webhookDefaultLogLimit,
+	}
+}
+
+// close cancels the deliveries in progress and waits for those in the
+// background to return.
+func (w *webhooks) close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// notify delivers the push payloads of the event to the webhooks of
+// the repository, in order of the webhooks and references when
+// synchronous.
+func (w *webhooks) notify(repo *git.Repository, event PushEvent) {
+	hooks := []Webhook{}
+
+	for _, hook := range w.hooks {
+		if hook.RepoPath == "" || hook.RepoPath == AnyRepository || hook.RepoPath == event.RepoPath {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	payloads := pushPayloads(repo, event)
+
+	for _, hook := range hooks {
+		for _, payload := range payloads {
+			delivery := w.start(hook, event.RepoPath, payload)
+
+			if w.synchronous {
+				w.deliver(hook, delivery)
+
+				continue
+			}
+
+			w.wg.Add(1)
+
+			go func(hook Webhook, delivery *WebhookDelivery) {
+				defer w.wg.Done()
+
+				w.deliver(hook, delivery)
+			}(hook, delivery)
+		}
+	}
+}
+
+// start adds the delivery of the payload to the log.
+func (w *webhooks) start(hook Webhook, repoPath string, payload refPayload) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		ID:       newDeliveryID(),
+		URL:      hook.URL,
+		Event:    webhookEventPush,
+		RepoPath: repoPath,
+		Ref:      payload.ref,
+		Payload:  payload.body,
+		Attempts: []WebhookAttempt{},
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.log = append(w.log, delivery)
+	if w.limit > 0 && len(w.log) > w.limit {
+		w.log = w.log[len(w.log)-w.limit:]
+	}
+
+	return delivery
+}
+
+// deliver posts the payload until it was delivered, the attempts are
+// exhausted or the Server is closed, backing off between attempts. A
+// delivery failing every attempt is logged.
+func (w *webhooks) deliver(hook Webhook, delivery *WebhookDelivery) {
+	attempts, backoff := hook.MaxAttempts, hook.Backoff
+	if attempts <= 0 {
+		attempts = webhookDefaultAttempts
+	}
+
+	if backoff <= 0 {
+		backoff = webhookDefaultBackoff
+	}
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			if !w.wait(backoff) {
+				break
+			}
+
+			backoff *= 2
+		}
+
+		start := time.Now()
+		status, err := w.post(hook, delivery)
+		attempt := WebhookAttempt{Time: start, Duration: time.Since(start), StatusCode: status, Err: err}
+
+		w.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		w.mu.Unlock()
+
+		if attempt.Err == nil && attempt.StatusCode >= 200 && attempt.StatusCode < 300 {
+			return
+		}
+	}
+
+	last := delivery.Attempts[len(delivery.Attempts)-1]
+
+	err := last.Err
+	if err == nil {
+		err = fmt.Errorf("%w: %d", ErrWebhookStatus, last.StatusCode)
+	}
+
+	w.logger.Error("webhook delivery failed",
+		slog.String("id", delivery.ID),
+		slog.String("url", delivery.URL),
+		slog.String("repo", delivery.RepoPath),
+		slog.String("ref", delivery.Ref.String()),
+		slog.Int("attempts", len(delivery.Attempts)),
+		slog.Any("error", err),
+	)
+}
+
+// wait waits for the backoff, it is false if the Server was closed in
+// the meantime.
+func (w *webhooks) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// post sends the payload, it returns the status the endpoint responded
+// with.
+func (w *webhooks) post(hook Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-git-http-backend-Hookshot")
+	req.Header.Set("X-GitHub-Event", delivery.Event)
+	req.Header.Set("X-GitHub-Delivery", delivery.ID)
+
+	if hook.Secret != "" {
+		req.Header.Set("X-Hub-Signature-256", SignWebhookPayload(hook.Secret, delivery.Payload))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook post: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// deliveries returns copies of the logged deliveries, as they are
+// updated concurrently.
+func (w *webhooks) deliveries() []WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deliveries := make([]WebhookDelivery, 0, len(w.log))
+
+	for _, delivery := range w.log {
+		copied := *delivery
+		copied.Attempts = append([]WebhookAttempt{}, delivery.Attempts...)
+		deliveries = append(deliveries, copied)
+	}
+
+	return deliveries
+}
+
+// newDeliveryID returns a random id in the format of a UUID.
+func newDeliveryID() string {
+	var id [16]byte
+
+	_, _ = rand.Read(id[:])
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
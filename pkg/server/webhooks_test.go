@@ -0,0 +1,317 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sata-form3/go-git-http-backend/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+type pushPayload struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Created bool   `json:"created"`
+	Deleted bool   `json:"deleted"`
+	Forced  bool   `json:"forced"`
+	Commits []struct {
+		ID       string   `json:"id"`
+		Message  string   `json:"message"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+	HeadCommit *struct {
+		ID string `json:"id"`
+	} `json:"head_commit"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+}
+
+// webhookReceiver records the webhook requests it receives, responding
+// with the statuses in order and 200 OK once they are used up.
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []webhookRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		received []webhookRequest
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, webhookRequest{header: r.Header.Clone(), body: body})
+
+		if len(received) <= len(statuses) {
+			w.WriteHeader(statuses[len(received)-1])
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]webhookRequest{}, received...)
+	}
+}
+
+func TestWebhookDeliversPushPayloads(t *testing.T) {
+	t.Parallel()
+
+	const secret = "webhook-secret"
+
+	receiver, received := webhookReceiver(t)
+	otherRepo, otherReceived := webhookReceiver(t)
+
+	testRepo := repoWithInitCommit(t, filename, content)
+
+	head, err := testRepo.Head()
+	require.NoError(t, err)
+
+	srv, err := server.NewHTTPTest(testRepo, owner, repoName,
+		server.WithWebhook(server.Webhook{URL: receiver.URL, Secret: secret}),
+		server.WithWebhook(server.Webhook{URL: otherRepo.URL, RepoPath: server.RepoPath(owner, "tools")}),
+		server.WithSynchronousWebhooks(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	first := commitFile(t, local, filename, "modified content", "modify file")
+	second := commitFile(t, local, "added", "added content", "add file")
+
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/feature"))
+
+	requests := received()
+	require.Len(t, requests, 2)
+	require.Empty(t, otherReceived())
+
+	for _, req := range requests {
+		require.Equal(t, "push", req.header.Get("X-GitHub-Event"))
+		require.NotEmpty(t, req.header.Get("X-GitHub-Delivery"))
+		require.True(t, server.VerifyWebhookSignature(secret, req.body, req.header.Get("X-Hub-Signature-256")))
+	}
+
+	var master pushPayload
+
+	require.NoError(t, json.Unmarshal(requests[0].body, &master))
+	require.Equal(t, "refs/heads/master", master.Ref)
+	require.Equal(t, head.Hash().String(), master.Before)
+	require.Equal(t, second.String(), master.After)
+	require.False(t, master.Created)
+	require.False(t, master.Forced)
+	require.Equal(t, owner+"/"+repoName, master.Repository.FullName)
+	require.Equal(t, "anonymous", master.Pusher.Name)
+	require.Equal(t, second.String(), master.HeadCommit.ID)
+
+	require.Len(t, master.Commits, 2)
+	require.Equal(t, first.String(), master.Commits[0].ID)
+	require.Equal(t, []string{filename}, master.Commits[0].Modified)
+	require.Equal(t, second.String(), master.Commits[1].ID)
+	require.Equal(t, []string{"added"}, master.Commits[1].Added)
+
+	var feature pushPayload
+
+	require.NoError(t, json.Unmarshal(requests[1].body, &feature))
+	require.Equal(t, "refs/heads/feature", feature.Ref)
+	require.Equal(t, plumbing.ZeroHash.String(), feature.Before)
+	require.True(t, feature.Created)
+
+	require.NoError(t, push(local, noAuth, ":refs/heads/feature"))
+
+	requests = received()
+	require.Len(t, requests, 3)
+
+	var deleted pushPayload
+
+	require.NoError(t, json.Unmarshal(requests[2].body, &deleted))
+	require.True(t, deleted.Deleted)
+	require.Equal(t, plumbing.ZeroHash.String(), deleted.After)
+	require.Empty(t, deleted.Commits)
+	require.Nil(t, deleted.HeadCommit)
+
+	deliveries := srv.WebhookDeliveries()
+	require.Len(t, deliveries, 3)
+
+	for i, delivery := range deliveries {
+		require.True(t, delivery.Delivered())
+		require.Equal(t, requests[i].header.Get("X-GitHub-Delivery"), delivery.ID)
+		require.Equal(t, requests[i].body, delivery.Payload)
+	}
+
+	require.Equal(t, plumbing.ReferenceName("refs/heads/feature"), deliveries[2].Ref)
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	t.Parallel()
+
+	const backoff = 20 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		delivered bool
+	}{
+		{
+			name:      "delivered after retries",
+			statuses:  []int{http.StatusInternalServerError, http.StatusBadGateway},
+			attempts:  3,
+			delivered: true,
+		},
+		{
+			name:      "attempts exhausted",
+			statuses:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			attempts:  3,
+			delivered: false,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			receiver, received := webhookReceiver(t, tc.statuses...)
+
+			srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+				server.WithWebhook(server.Webhook{URL: receiver.URL, MaxAttempts: 3, Backoff: backoff}),
+				server.WithSynchronousWebhooks(),
+			)
+			require.NoError(t, err)
+
+			t.Cleanup(srv.Stop)
+
+			local := cloneRepository(t, srv.URL(), noAuth)
+			commitFile(t, local, filename, "pushed content", "second commit")
+			require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+
+			require.Len(t, received(), tc.attempts)
+
+			deliveries := srv.WebhookDeliveries()
+			require.Len(t, deliveries, 1)
+			require.Equal(t, tc.delivered, deliveries[0].Delivered())
+
+			attempts := deliveries[0].Attempts
+			require.Len(t, attempts, tc.attempts)
+			require.Equal(t, tc.statuses[0], attempts[0].StatusCode)
+			require.GreaterOrEqual(t, attempts[1].Time.Sub(attempts[0].Time), backoff)
+			require.GreaterOrEqual(t, attempts[2].Time.Sub(attempts[1].Time), 2*backoff)
+		})
+	}
+}
+
+func TestWebhookDeliversInBackground(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&requests, 1)
+	}))
+	t.Cleanup(receiver.Close)
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithWebhook(server.Webhook{URL: receiver.URL}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+
+	// the push does not wait for the blocked receiver.
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+	require.Zero(t, atomic.LoadInt32(&requests))
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		deliveries := srv.WebhookDeliveries()
+
+		return len(deliveries) == 1 && deliveries[0].Delivered()
+	}, 5*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+}
+
+func TestWebhookRetriesEndOnStop(t *testing.T) {
+	t.Parallel()
+
+	receiver, received := webhookReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithWebhook(server.Webhook{URL: receiver.URL, MaxAttempts: 3, Backoff: time.Hour}),
+	)
+	require.NoError(t, err)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master"))
+
+	require.Eventually(t, func() bool {
+		return len(received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the retry backing off for an hour is canceled.
+	start := time.Now()
+	srv.Stop()
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	deliveries := srv.WebhookDeliveries()
+	require.Len(t, deliveries, 1)
+	require.Len(t, deliveries[0].Attempts, 1)
+	require.False(t, deliveries[0].Delivered())
+	require.Len(t, received(), 1)
+}
+
+func TestWebhookDeliveryLimit(t *testing.T) {
+	t.Parallel()
+
+	receiver, received := webhookReceiver(t)
+
+	srv, err := server.NewHTTPTest(repoWithInitCommit(t, filename, content), owner, repoName,
+		server.WithWebhook(server.Webhook{URL: receiver.URL}),
+		server.WithSynchronousWebhooks(),
+		server.WithWebhookDeliveryLimit(1),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(srv.Stop)
+
+	local := cloneRepository(t, srv.URL(), noAuth)
+	commitFile(t, local, filename, "pushed content", "second commit")
+	require.NoError(t, push(local, noAuth, "refs/heads/master:refs/heads/master", "refs/heads/master:refs/heads/feature"))
+
+	require.Len(t, received(), 2)
+
+	deliveries := srv.WebhookDeliveries()
+	require.Len(t, deliveries, 1)
+	require.Equal(t, plumbing.ReferenceName("refs/heads/feature"), deliveries[0].Ref)
+}